@@ -1,7 +1,10 @@
 package config
 
 import (
+	"time"
+
 	"github.com/NYTimes/gizmo/server"
+	"github.com/nytimes/video-captions-api/credentials"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -12,4 +15,65 @@ type CaptionsServiceConfig struct {
 	Logger      *log.Logger
 	BucketName  string `envconfig:"BUCKET_NAME"`
 	CallbackURL string `envconfig:"CALLBACK_URL"`
+	// CallbackAPIKey is the default shared secret inbound callbacks are verified with
+	CallbackAPIKey string `envconfig:"CALLBACK_API_KEY"`
+	// CallbackSecrets overrides CallbackAPIKey per provider, e.g. "3play:secret1,amara:secret2"
+	CallbackSecrets map[string]string `envconfig:"CALLBACK_SECRETS"`
+	// CallbackTolerance is how old a signed callback can be before it is rejected
+	CallbackTolerance time.Duration `envconfig:"CALLBACK_TOLERANCE" default:"5m"`
+	// ProviderTimeout bounds every call made to a provider
+	ProviderTimeout time.Duration `envconfig:"PROVIDER_TIMEOUT" default:"30s"`
+	// ProviderTimeouts overrides ProviderTimeout per provider, e.g. "3play:1m,amara:45s"
+	ProviderTimeouts map[string]time.Duration `envconfig:"PROVIDER_TIMEOUTS"`
+	// ProviderBreakerThreshold is the number of consecutive failed calls that
+	// suspends calls to a provider for ProviderBreakerCooldown, 0 disables breakers
+	ProviderBreakerThreshold int           `envconfig:"PROVIDER_BREAKER_THRESHOLD" default:"5"`
+	ProviderBreakerCooldown  time.Duration `envconfig:"PROVIDER_BREAKER_COOLDOWN" default:"30s"`
+	// ProviderRateLimits is the number of calls per second allowed per provider,
+	// e.g. "3play:5,amara:2", in bursts of up to ProviderRateBurst calls
+	ProviderRateLimits map[string]float64 `envconfig:"PROVIDER_RATE_LIMITS"`
+	ProviderRateBurst  int                `envconfig:"PROVIDER_RATE_BURST" default:"10"`
+	// DefaultProviders is the ordered list of providers jobs that don't name one
+	// are dispatched to, e.g. "3play,rev"
+	DefaultProviders []string `envconfig:"DEFAULT_PROVIDERS"`
+	// RoutingRulesFile is a YAML file with the rules picking a provider for jobs that don't name one
+	RoutingRulesFile string `envconfig:"ROUTING_RULES_FILE"`
+	// ReconcileInterval is how often jobs that aren't done are refreshed from
+	// their providers in the background, 0 disables the reconciler
+	ReconcileInterval time.Duration `envconfig:"RECONCILE_INTERVAL" default:"1m"`
+	// ReconcileMinBackoff and ReconcileMaxBackoff bound the time between two
+	// refreshes of a job, which grows with the job age
+	ReconcileMinBackoff time.Duration `envconfig:"RECONCILE_MIN_BACKOFF" default:"1m"`
+	ReconcileMaxBackoff time.Duration `envconfig:"RECONCILE_MAX_BACKOFF" default:"1h"`
+	// MaintenanceInterval is how often webhook deliveries are retried and jobs
+	// past their deadline are flagged, 0 disables both
+	MaintenanceInterval time.Duration `envconfig:"MAINTENANCE_INTERVAL" default:"30s"`
+	// OutputMaxAttempts is how many times downloading and storing an output is
+	// tried before it is marked as failed, 0 retries forever
+	OutputMaxAttempts int `envconfig:"OUTPUT_MAX_ATTEMPTS" default:"5"`
+	// OutputRetryBackoff is the delay before retrying an output, doubled after every failure
+	OutputRetryBackoff time.Duration `envconfig:"OUTPUT_RETRY_BACKOFF" default:"1m"`
+	// DispatchWorkers is the number of jobs dispatched at the same time by the
+	// dispatch queue, 0 dispatches jobs synchronously when they are created
+	DispatchWorkers int `envconfig:"DISPATCH_WORKERS" default:"4"`
+	// ProviderConcurrency caps the dispatches in flight per provider, e.g. "3play:2,rev:5"
+	ProviderConcurrency map[string]int `envconfig:"PROVIDER_CONCURRENCY"`
+	// ProviderTurnarounds is how long providers that don't report their
+	// turnaround take to deliver a job, e.g. "rev:24h", jobs get their deadline from it
+	ProviderTurnarounds map[string]time.Duration `envconfig:"PROVIDER_TURNAROUNDS"`
+	// WebhookSecret signs webhook deliveries of job callback URLs and of
+	// subscriptions without their own secret
+	WebhookSecret string `envconfig:"WEBHOOK_SECRET"`
+	// WebhookMaxAttempts is how many times a webhook delivery is tried, 0 retries forever
+	WebhookMaxAttempts int `envconfig:"WEBHOOK_MAX_ATTEMPTS" default:"8"`
+	// WebhookRetryBackoff is the delay before retrying a delivery, doubled after every failure
+	WebhookRetryBackoff time.Duration `envconfig:"WEBHOOK_RETRY_BACKOFF" default:"30s"`
+	// WebhookTimeout bounds every webhook request
+	WebhookTimeout time.Duration `envconfig:"WEBHOOK_TIMEOUT" default:"10s"`
+	// WebhookWorkers is the number of webhook deliveries attempted at the same
+	// time in the background, 0 leaves them to the maintenance loop
+	WebhookWorkers int `envconfig:"WEBHOOK_WORKERS" default:"4"`
+	// Credentials is where providers look up their API keys at call time, they
+	// fall back to their own config when it is nil or has no credential for a job
+	Credentials credentials.Store `ignored:"true"`
 }
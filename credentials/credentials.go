@@ -0,0 +1,108 @@
+// Package credentials looks up the secrets providers authenticate with when
+// they call vendor APIs, so they can change without a redeploy.
+package credentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// ErrNotFound indicates that a store holds no credential for a key
+var ErrNotFound = errors.New("credential not found")
+
+// Key identifies the credential of a provider. JobType and Tenant are optional,
+// see Lookup for how less specific credentials are used.
+type Key struct {
+	Provider string
+	JobType  string
+	Tenant   string
+}
+
+// Store holds provider credentials. Get returns ErrNotFound when the exact key
+// has no credential.
+type Store interface {
+	Get(ctx context.Context, key Key) (string, error)
+}
+
+// Config holds the credential store config
+type Config struct {
+	// Store is one of env, file or secretmanager
+	Store     string `envconfig:"CREDENTIALS_STORE" default:"env"`
+	EnvPrefix string `envconfig:"CREDENTIALS_ENV_PREFIX" default:"CREDENTIAL"`
+	File      string `envconfig:"CREDENTIALS_FILE"`
+	// Project is the Google Cloud project holding the secrets
+	Project  string        `envconfig:"CREDENTIALS_PROJECT"`
+	CacheTTL time.Duration `envconfig:"CREDENTIALS_CACHE_TTL" default:"5m"`
+}
+
+// LoadConfigFromEnv loads the credential store config from environment
+func LoadConfigFromEnv() Config {
+	var cfg Config
+	envconfig.Process("", &cfg)
+	return cfg
+}
+
+// NewStore creates the store selected by the config
+func NewStore(ctx context.Context, cfg Config) (Store, error) {
+	switch cfg.Store {
+	case "", "env":
+		return NewEnvStore(cfg.EnvPrefix), nil
+	case "file":
+		if cfg.File == "" {
+			return nil, errors.New("CREDENTIALS_FILE is required by the file credential store")
+		}
+		return NewFileStore(cfg.File), nil
+	case "secretmanager":
+		if cfg.Project == "" {
+			return nil, errors.New("a project is required by the secretmanager credential store")
+		}
+		accessor, err := NewGoogleSecretAccessor(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return NewSecretManagerStore(accessor, cfg.Project, cfg.CacheTTL), nil
+	default:
+		return nil, fmt.Errorf("unknown credential store %q", cfg.Store)
+	}
+}
+
+// Lookup returns the most specific credential of the key, trying the tenant
+// and job type, the tenant, the job type and finally the provider alone.
+func Lookup(ctx context.Context, store Store, key Key) (string, error) {
+	return lookup(ctx, store, key.fallbacks())
+}
+
+// LookupSpecific is Lookup without the credential of the provider alone, for
+// callers with credentials of their own to try before it.
+func LookupSpecific(ctx context.Context, store Store, key Key) (string, error) {
+	fallbacks := key.fallbacks()
+	return lookup(ctx, store, fallbacks[:len(fallbacks)-1])
+}
+
+func lookup(ctx context.Context, store Store, keys []Key) (string, error) {
+	for _, candidate := range keys {
+		secret, err := store.Get(ctx, candidate)
+		if err != ErrNotFound {
+			return secret, err
+		}
+	}
+	return "", ErrNotFound
+}
+
+func (k Key) fallbacks() []Key {
+	keys := make([]Key, 0, 4)
+	if k.Tenant != "" {
+		if k.JobType != "" {
+			keys = append(keys, k)
+		}
+		keys = append(keys, Key{Provider: k.Provider, Tenant: k.Tenant})
+	}
+	if k.JobType != "" {
+		keys = append(keys, Key{Provider: k.Provider, JobType: k.JobType})
+	}
+	return append(keys, Key{Provider: k.Provider})
+}
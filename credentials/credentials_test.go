@@ -0,0 +1,86 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mapStore map[Key]string
+
+func (s mapStore) Get(_ context.Context, key Key) (string, error) {
+	if secret, ok := s[key]; ok {
+		return secret, nil
+	}
+	return "", ErrNotFound
+}
+
+type brokenStore struct{}
+
+func (brokenStore) Get(context.Context, Key) (string, error) {
+	return "", errors.New("store is down")
+}
+
+func TestLookup(t *testing.T) {
+	store := mapStore{
+		{Provider: "3play"}:                                         "default-key",
+		{Provider: "3play", JobType: "captions"}:                    "captions-key",
+		{Provider: "3play", Tenant: "opinion"}:                      "opinion-key",
+		{Provider: "3play", JobType: "captions", Tenant: "opinion"}: "opinion-captions-key",
+	}
+	tests := []struct {
+		name   string
+		key    Key
+		secret string
+	}{
+		{"Exact", Key{"3play", "captions", "opinion"}, "opinion-captions-key"},
+		{"Tenant", Key{"3play", "transcript", "opinion"}, "opinion-key"},
+		{"Job type", Key{"3play", "captions", "games"}, "captions-key"},
+		{"Provider", Key{"3play", "transcript", "games"}, "default-key"},
+		{"No tenant", Key{Provider: "3play"}, "default-key"},
+	}
+	for _, test := range tests {
+		secret, err := Lookup(context.Background(), store, test.key)
+		assert.Nil(t, err, test.name)
+		assert.Equal(t, test.secret, secret, test.name)
+	}
+
+	_, err := Lookup(context.Background(), store, Key{Provider: "amara", Tenant: "opinion"})
+	assert.Equal(t, ErrNotFound, err)
+	_, err = Lookup(context.Background(), brokenStore{}, Key{Provider: "amara"})
+	assert.EqualError(t, err, "store is down")
+}
+
+func TestLookupSpecific(t *testing.T) {
+	store := mapStore{
+		{Provider: "3play"}:                      "default-key",
+		{Provider: "3play", JobType: "captions"}: "captions-key",
+	}
+	secret, err := LookupSpecific(context.Background(), store, Key{"3play", "captions", "opinion"})
+	assert.Nil(t, err)
+	assert.Equal(t, "captions-key", secret)
+	_, err = LookupSpecific(context.Background(), store, Key{"3play", "transcript", "opinion"})
+	assert.Equal(t, ErrNotFound, err)
+	_, err = LookupSpecific(context.Background(), store, Key{Provider: "3play"})
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestNewStore(t *testing.T) {
+	assert := assert.New(t)
+	store, err := NewStore(context.Background(), Config{Store: "env", EnvPrefix: "CREDENTIAL"})
+	assert.Nil(err)
+	assert.IsType(&EnvStore{}, store)
+
+	store, err = NewStore(context.Background(), Config{Store: "file", File: "testdata/credentials.yaml"})
+	assert.Nil(err)
+	assert.IsType(&FileStore{}, store)
+
+	_, err = NewStore(context.Background(), Config{Store: "file"})
+	assert.EqualError(err, "CREDENTIALS_FILE is required by the file credential store")
+	_, err = NewStore(context.Background(), Config{Store: "secretmanager"})
+	assert.EqualError(err, "a project is required by the secretmanager credential store")
+	_, err = NewStore(context.Background(), Config{Store: "vault"})
+	assert.EqualError(err, `unknown credential store "vault"`)
+}
@@ -0,0 +1,55 @@
+package credentials
+
+import (
+	"context"
+	"os"
+	"strings"
+)
+
+// EnvStore reads credentials from environment variables when they are looked
+// up, named after the key:
+//
+//	<prefix>_<PROVIDER>
+//	<prefix>_<PROVIDER>_JOB_<JOB TYPE>
+//	<prefix>_<PROVIDER>_TENANT_<TENANT>
+//	<prefix>_<PROVIDER>_TENANT_<TENANT>_JOB_<JOB TYPE>
+//
+// Names are upper cased and characters other than letters and digits are
+// replaced by underscores, e.g. CREDENTIAL_3PLAY_TENANT_OPINION_JOB_CAPTIONS.
+type EnvStore struct {
+	Prefix string
+}
+
+// NewEnvStore creates an EnvStore
+func NewEnvStore(prefix string) *EnvStore {
+	return &EnvStore{Prefix: prefix}
+}
+
+// Get implements Store
+func (s *EnvStore) Get(_ context.Context, key Key) (string, error) {
+	secret, ok := os.LookupEnv(s.VariableName(key))
+	if !ok || secret == "" {
+		return "", ErrNotFound
+	}
+	return secret, nil
+}
+
+// VariableName returns the environment variable holding the key credential
+func (s *EnvStore) VariableName(key Key) string {
+	parts := []string{s.Prefix, key.Provider}
+	if key.Tenant != "" {
+		parts = append(parts, "TENANT", key.Tenant)
+	}
+	if key.JobType != "" {
+		parts = append(parts, "JOB", key.JobType)
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, strings.Join(parts, "_"))
+}
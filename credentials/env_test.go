@@ -0,0 +1,30 @@
+package credentials
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnvStoreVariableName(t *testing.T) {
+	store := NewEnvStore("CREDENTIAL")
+	assert.Equal(t, "CREDENTIAL_3PLAY", store.VariableName(Key{Provider: "3play"}))
+	assert.Equal(t, "CREDENTIAL_3PLAY_JOB_CAPTIONS", store.VariableName(Key{Provider: "3play", JobType: "captions"}))
+	assert.Equal(t, "CREDENTIAL_ACME_CO_TENANT_OPINION_DESK_JOB_CAPTIONS",
+		store.VariableName(Key{Provider: "acme-co", JobType: "captions", Tenant: "opinion.desk"}))
+}
+
+func TestEnvStoreGet(t *testing.T) {
+	os.Setenv("TEST_CREDENTIAL_AMARA_TENANT_OPINION", "opinion-token")
+	defer os.Unsetenv("TEST_CREDENTIAL_AMARA_TENANT_OPINION")
+	store := NewEnvStore("TEST_CREDENTIAL")
+
+	secret, err := store.Get(context.Background(), Key{Provider: "amara", Tenant: "opinion"})
+	assert.Nil(t, err)
+	assert.Equal(t, "opinion-token", secret)
+
+	_, err = store.Get(context.Background(), Key{Provider: "amara"})
+	assert.Equal(t, ErrNotFound, err)
+}
@@ -0,0 +1,87 @@
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileStore reads credentials from a YAML file, which is read again whenever it
+// changes:
+//
+//	credentials:
+//	  - provider: 3play
+//	    secret: default-key
+//	  - provider: 3play
+//	    job_type: captions
+//	    tenant: opinion
+//	    secret: opinion-captions-key
+type FileStore struct {
+	path string
+
+	mtx     sync.Mutex
+	modTime time.Time
+	secrets map[Key]string
+}
+
+type credentialsFile struct {
+	Credentials []struct {
+		Provider string `yaml:"provider"`
+		JobType  string `yaml:"job_type"`
+		Tenant   string `yaml:"tenant"`
+		Secret   string `yaml:"secret"`
+	} `yaml:"credentials"`
+}
+
+// NewFileStore creates a FileStore, the file is read on the first lookup
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path: path}
+}
+
+// Get implements Store
+func (s *FileStore) Get(_ context.Context, key Key) (string, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if err := s.reload(); err != nil {
+		return "", err
+	}
+	secret, ok := s.secrets[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return secret, nil
+}
+
+// reload reads the file when it was modified since it was last read
+func (s *FileStore) reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	if s.secrets != nil && info.ModTime().Equal(s.modTime) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var file credentialsFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("invalid credentials file: %v", err)
+	}
+	secrets := make(map[Key]string, len(file.Credentials))
+	for i, credential := range file.Credentials {
+		if credential.Provider == "" {
+			return fmt.Errorf("invalid credentials file: credential %d has no provider", i+1)
+		}
+		secrets[Key{credential.Provider, credential.JobType, credential.Tenant}] = credential.Secret
+	}
+	s.secrets = secrets
+	s.modTime = info.ModTime()
+	return nil
+}
@@ -0,0 +1,58 @@
+package credentials
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStore(t *testing.T) {
+	assert := assert.New(t)
+	store := NewFileStore("testdata/credentials.yaml")
+
+	secret, err := Lookup(context.Background(), store, Key{"3play", "captions", "opinion"})
+	assert.Nil(err)
+	assert.Equal("opinion-captions-key", secret)
+	secret, err = Lookup(context.Background(), store, Key{"3play", "transcript", "games"})
+	assert.Nil(err)
+	assert.Equal("default-key", secret)
+	_, err = store.Get(context.Background(), Key{Provider: "amara"})
+	assert.Equal(ErrNotFound, err)
+}
+
+func TestFileStoreReload(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "credentials.yaml")
+	write := func(content string, modTime time.Time) {
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, modTime, modTime)
+	}
+	store := NewFileStore(path)
+	now := time.Now()
+
+	write("credentials:\n  - provider: rev\n    secret: old-token\n", now.Add(-time.Minute))
+	secret, err := store.Get(context.Background(), Key{Provider: "rev"})
+	assert.Nil(err)
+	assert.Equal("old-token", secret)
+
+	write("credentials:\n  - provider: rev\n    secret: new-token\n", now)
+	secret, err = store.Get(context.Background(), Key{Provider: "rev"})
+	assert.Nil(err)
+	assert.Equal("new-token", secret)
+
+	write("credentials:\n  - secret: orphan\n", now.Add(time.Minute))
+	_, err = store.Get(context.Background(), Key{Provider: "rev"})
+	assert.EqualError(err, "invalid credentials file: credential 1 has no provider")
+}
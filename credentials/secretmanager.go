@@ -0,0 +1,151 @@
+package credentials
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2/google"
+)
+
+const secretManagerURL = "https://secretmanager.googleapis.com/v1"
+
+// SecretAccessor reads the latest version of a secret, returning ErrNotFound
+// when the secret doesn't exist
+type SecretAccessor interface {
+	AccessSecret(ctx context.Context, project, secretID string) ([]byte, error)
+}
+
+// SecretManagerStore reads credentials from a secret manager, named after the key:
+//
+//	captions-<provider>
+//	captions-<provider>-job-<job type>
+//	captions-<provider>-tenant-<tenant>
+//	captions-<provider>-tenant-<tenant>-job-<job type>
+//
+// Secrets, and the fact that a secret doesn't exist, are cached for TTL.
+type SecretManagerStore struct {
+	Accessor SecretAccessor
+	Project  string
+	TTL      time.Duration
+
+	now   func() time.Time
+	mtx   sync.Mutex
+	cache map[Key]cachedSecret
+}
+
+type cachedSecret struct {
+	secret  string
+	found   bool
+	expires time.Time
+}
+
+// NewSecretManagerStore creates a SecretManagerStore
+func NewSecretManagerStore(accessor SecretAccessor, project string, ttl time.Duration) *SecretManagerStore {
+	return &SecretManagerStore{
+		Accessor: accessor,
+		Project:  project,
+		TTL:      ttl,
+		now:      time.Now,
+		cache:    make(map[Key]cachedSecret),
+	}
+}
+
+// Get implements Store
+func (s *SecretManagerStore) Get(ctx context.Context, key Key) (string, error) {
+	s.mtx.Lock()
+	cached, ok := s.cache[key]
+	s.mtx.Unlock()
+	if ok && s.now().Before(cached.expires) {
+		if !cached.found {
+			return "", ErrNotFound
+		}
+		return cached.secret, nil
+	}
+
+	data, err := s.Accessor.AccessSecret(ctx, s.Project, SecretID(key))
+	if err != nil && err != ErrNotFound {
+		return "", err
+	}
+	cached = cachedSecret{secret: string(data), found: err == nil, expires: s.now().Add(s.TTL)}
+	s.mtx.Lock()
+	s.cache[key] = cached
+	s.mtx.Unlock()
+	if !cached.found {
+		return "", ErrNotFound
+	}
+	return cached.secret, nil
+}
+
+// SecretID returns the ID of the secret holding the key credential
+func SecretID(key Key) string {
+	parts := []string{"captions", key.Provider}
+	if key.Tenant != "" {
+		parts = append(parts, "tenant", key.Tenant)
+	}
+	if key.JobType != "" {
+		parts = append(parts, "job", key.JobType)
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '-'
+	}, strings.Join(parts, "-"))
+}
+
+// googleSecretAccessor reads secrets from the Google Secret Manager REST API
+type googleSecretAccessor struct {
+	httpClient *http.Client
+	baseURL    string
+}
+
+// NewGoogleSecretAccessor creates a SecretAccessor for Google Secret Manager
+// authenticated with the application default credentials
+func NewGoogleSecretAccessor(ctx context.Context) (SecretAccessor, error) {
+	httpClient, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/cloud-platform")
+	if err != nil {
+		return nil, err
+	}
+	return &googleSecretAccessor{httpClient, secretManagerURL}, nil
+}
+
+// AccessSecret implements SecretAccessor
+func (a *googleSecretAccessor) AccessSecret(ctx context.Context, project, secretID string) ([]byte, error) {
+	target := fmt.Sprintf("%s/projects/%s/secrets/%s/versions/latest:access", a.baseURL, url.PathEscape(project), url.PathEscape(secretID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := a.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return nil, fmt.Errorf("could not access secret %s: status %d: %s", secretID, res.StatusCode, body)
+	}
+	var version struct {
+		Payload struct {
+			// Data is base64 encoded, which encoding/json decodes into []byte
+			Data []byte `json:"data"`
+		} `json:"payload"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&version); err != nil {
+		return nil, err
+	}
+	return version.Payload.Data, nil
+}
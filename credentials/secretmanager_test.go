@@ -0,0 +1,90 @@
+package credentials
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeAccessor struct {
+	mtx     sync.Mutex
+	secrets map[string]string
+	calls   []string
+}
+
+func (a *fakeAccessor) AccessSecret(_ context.Context, project, secretID string) ([]byte, error) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	name := project + "/" + secretID
+	a.calls = append(a.calls, name)
+	secret, ok := a.secrets[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return []byte(secret), nil
+}
+
+func TestSecretID(t *testing.T) {
+	assert.Equal(t, "captions-3play", SecretID(Key{Provider: "3play"}))
+	assert.Equal(t, "captions-3play-tenant-opinion_desk-job-captions",
+		SecretID(Key{Provider: "3play", JobType: "captions", Tenant: "Opinion_Desk"}))
+	assert.Equal(t, "captions-generic-acme-job-asr", SecretID(Key{Provider: "generic.acme", JobType: "asr"}))
+}
+
+func TestSecretManagerStore(t *testing.T) {
+	assert := assert.New(t)
+	accessor := &fakeAccessor{secrets: map[string]string{
+		"project/captions-amara":                "default-token",
+		"project/captions-amara-tenant-opinion": "opinion-token",
+	}}
+	store := NewSecretManagerStore(accessor, "project", time.Minute)
+	now := time.Now()
+	store.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		secret, err := Lookup(context.Background(), store, Key{"amara", "captions", "opinion"})
+		assert.Nil(err)
+		assert.Equal("opinion-token", secret)
+	}
+	assert.Equal([]string{"project/captions-amara-tenant-opinion-job-captions", "project/captions-amara-tenant-opinion"}, accessor.calls)
+
+	accessor.secrets["project/captions-amara-tenant-opinion"] = "rotated-token"
+	now = now.Add(2 * time.Minute)
+	secret, err := Lookup(context.Background(), store, Key{"amara", "captions", "opinion"})
+	assert.Nil(err)
+	assert.Equal("rotated-token", secret)
+}
+
+func TestGoogleSecretAccessor(t *testing.T) {
+	assert := assert.New(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/projects/project/secrets/captions-rev/versions/latest:access":
+			// "rev-token" base64 encoded
+			fmt.Fprint(w, `{"name": "captions-rev", "payload": {"data": "cmV2LXRva2Vu"}}`)
+		case "/v1/projects/project/secrets/captions-broken/versions/latest:access":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("permission denied"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	accessor := &googleSecretAccessor{server.Client(), server.URL + "/v1"}
+
+	secret, err := accessor.AccessSecret(context.Background(), "project", "captions-rev")
+	assert.Nil(err)
+	assert.Equal("rev-token", string(secret))
+
+	_, err = accessor.AccessSecret(context.Background(), "project", "captions-amara")
+	assert.Equal(ErrNotFound, err)
+
+	_, err = accessor.AccessSecret(context.Background(), "project", "captions-broken")
+	assert.EqualError(err, "could not access secret captions-broken: status 403: permission denied")
+}
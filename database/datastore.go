@@ -10,6 +10,11 @@ import (
 const (
 	entityKind      string = "Jobs"
 	entityNamespace string = "captions-jobs"
+
+	// subscription, delivery and event kinds are suffixes of the job kind
+	subscriptionKindSuffix string = "Subscriptions"
+	deliveryKindSuffix     string = "Deliveries"
+	eventKindSuffix        string = "Events"
 )
 
 // DatastoreClient is a datastore interface with operations used by the captions API
@@ -18,6 +23,27 @@ type DatastoreClient interface {
 	Get(context.Context, *datastore.Key, interface{}) error
 	Delete(context.Context, *datastore.Key) error
 	GetAll(context.Context, *datastore.Query, interface{}) ([]*datastore.Key, error)
+	// Transact runs fn in a transaction, retrying it on contention
+	Transact(context.Context, func(DatastoreTransaction) error) error
+}
+
+// DatastoreTransaction is a datastore transaction with operations used by the captions API
+type DatastoreTransaction interface {
+	Get(*datastore.Key, interface{}) error
+	Put(*datastore.Key, interface{}) (*datastore.PendingKey, error)
+}
+
+// datastoreClient adapts a datastore.Client to DatastoreClient
+type datastoreClient struct {
+	*datastore.Client
+}
+
+// Transact implements DatastoreClient
+func (c datastoreClient) Transact(ctx context.Context, fn func(DatastoreTransaction) error) error {
+	_, err := c.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		return fn(tx)
+	})
+	return err
 }
 
 // DatastoreDatabase is a datastore client that implements DB interface
@@ -35,19 +61,18 @@ func NewDatastoreDatabase(projectID string) (*DatastoreDatabase, error) {
 		return nil, err
 	}
 	return &DatastoreDatabase{
-		client,
+		datastoreClient{client},
 		entityKind,
 		entityNamespace,
 	}, nil
 }
 
 // StoreJob stores a job
-func (d *DatastoreDatabase) StoreJob(job *Job) (string, error) {
-	if _, err := d.GetJob(job.ID); err == nil {
+func (d *DatastoreDatabase) StoreJob(ctx context.Context, job *Job) (string, error) {
+	if _, err := d.GetJob(ctx, job.ID); err == nil {
 		return "", errors.New("job already exists")
 	}
 
-	ctx := context.Background()
 	key := newNameKeyWithNamespace(d.kind, job.ID, d.namespace)
 	_, err := d.client.Put(ctx, key, job)
 	if err != nil {
@@ -58,9 +83,8 @@ func (d *DatastoreDatabase) StoreJob(job *Job) (string, error) {
 }
 
 // GetJob retrieves a job from database
-func (d *DatastoreDatabase) GetJob(id string) (*Job, error) {
+func (d *DatastoreDatabase) GetJob(ctx context.Context, id string) (*Job, error) {
 	result := &Job{}
-	ctx := context.Background()
 	key := newNameKeyWithNamespace(d.kind, id, d.namespace)
 	err := d.client.Get(ctx, key, result)
 	if err == datastore.ErrNoSuchEntity {
@@ -73,9 +97,8 @@ func (d *DatastoreDatabase) GetJob(id string) (*Job, error) {
 }
 
 // GetJobs retrieves all jobs in database
-func (d *DatastoreDatabase) GetJobs(parentID string) ([]Job, error) {
+func (d *DatastoreDatabase) GetJobs(ctx context.Context, parentID string) ([]Job, error) {
 	var jobs []Job
-	ctx := context.Background()
 	query := datastore.NewQuery(d.kind).Namespace(d.namespace).Filter("ParentID =", parentID)
 	_, err := d.client.GetAll(ctx, query, &jobs)
 	if err != nil {
@@ -88,28 +111,49 @@ func (d *DatastoreDatabase) GetJobs(parentID string) ([]Job, error) {
 }
 
 // UpdateJob updates a job
-func (d *DatastoreDatabase) UpdateJob(id string, job *Job) error {
-	_, err := d.GetJob(id)
+func (d *DatastoreDatabase) UpdateJob(ctx context.Context, id string, job *Job) error {
+	_, err := d.GetJob(ctx, id)
 	if err != nil {
 		return err
 	}
 
-	ctx := context.Background()
 	key := newNameKeyWithNamespace(d.kind, id, d.namespace)
 	_, err = d.client.Put(ctx, key, job)
 	return err
 }
 
+// ModifyJob applies fn to a job and stores it in a transaction
+func (d *DatastoreDatabase) ModifyJob(ctx context.Context, id string, fn func(*Job) error) (*Job, error) {
+	key := newNameKeyWithNamespace(d.kind, id, d.namespace)
+	var job *Job
+	err := d.client.Transact(ctx, func(tx DatastoreTransaction) error {
+		job = &Job{}
+		if err := tx.Get(key, job); err != nil {
+			if err == datastore.ErrNoSuchEntity {
+				return ErrJobNotFound
+			}
+			return err
+		}
+		if err := fn(job); err != nil {
+			return err
+		}
+		_, err := tx.Put(key, job)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
 // DeleteJob deletes a job from database
-func (d *DatastoreDatabase) DeleteJob(id string) error {
-	ctx := context.Background()
+func (d *DatastoreDatabase) DeleteJob(ctx context.Context, id string) error {
 	key := newNameKeyWithNamespace(d.kind, id, d.namespace)
 	return d.client.Delete(ctx, key)
 }
 
 // GetJobByProviderID returns a job associated with a given provider ID
-func (d *DatastoreDatabase) GetJobByProviderID(providerID string) (*Job, error) {
-	ctx := context.Background()
+func (d *DatastoreDatabase) GetJobByProviderID(ctx context.Context, providerID string) (*Job, error) {
 	query := datastore.NewQuery(d.kind).Namespace(d.namespace).Filter("ProviderParams.ProviderID =", providerID).Limit(1)
 	var jobs []Job
 	if _, err := d.client.GetAll(ctx, query, &jobs); err != nil {
@@ -121,6 +165,109 @@ func (d *DatastoreDatabase) GetJobByProviderID(providerID string) (*Job, error)
 	return &jobs[0], nil
 }
 
+// GetJobsByMediaURL returns all jobs created for a given media URL
+func (d *DatastoreDatabase) GetJobsByMediaURL(ctx context.Context, mediaURL string) ([]Job, error) {
+	var jobs []Job
+	query := datastore.NewQuery(d.kind).Namespace(d.namespace).Filter("MediaURL =", mediaURL)
+	if _, err := d.client.GetAll(ctx, query, &jobs); err != nil {
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, ErrNoJobs
+	}
+	return jobs, nil
+}
+
+// GetPendingJobs returns all jobs that aren't done
+func (d *DatastoreDatabase) GetPendingJobs(ctx context.Context) ([]Job, error) {
+	var jobs []Job
+	query := datastore.NewQuery(d.kind).Namespace(d.namespace).Filter("Done =", false)
+	if _, err := d.client.GetAll(ctx, query, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// GetQueuedJobs returns the jobs waiting to be dispatched
+func (d *DatastoreDatabase) GetQueuedJobs(ctx context.Context) ([]Job, error) {
+	var jobs []Job
+	query := datastore.NewQuery(d.kind).Namespace(d.namespace).Filter("Status =", string(StatusQueued))
+	if _, err := d.client.GetAll(ctx, query, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// StoreSubscription stores a webhook subscription
+func (d *DatastoreDatabase) StoreSubscription(ctx context.Context, subscription *Subscription) error {
+	key := newNameKeyWithNamespace(d.kind+subscriptionKindSuffix, subscription.ID, d.namespace)
+	_, err := d.client.Put(ctx, key, subscription)
+	return err
+}
+
+// GetSubscriptions returns all webhook subscriptions
+func (d *DatastoreDatabase) GetSubscriptions(ctx context.Context) ([]Subscription, error) {
+	var subscriptions []Subscription
+	query := datastore.NewQuery(d.kind + subscriptionKindSuffix).Namespace(d.namespace)
+	if _, err := d.client.GetAll(ctx, query, &subscriptions); err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+// DeleteSubscription deletes a webhook subscription
+func (d *DatastoreDatabase) DeleteSubscription(ctx context.Context, id string) error {
+	key := newNameKeyWithNamespace(d.kind+subscriptionKindSuffix, id, d.namespace)
+	if err := d.client.Get(ctx, key, &Subscription{}); err == datastore.ErrNoSuchEntity {
+		return ErrSubscriptionNotFound
+	}
+	return d.client.Delete(ctx, key)
+}
+
+// SaveDelivery stores or updates a webhook delivery
+func (d *DatastoreDatabase) SaveDelivery(ctx context.Context, delivery *Delivery) error {
+	key := newNameKeyWithNamespace(d.kind+deliveryKindSuffix, delivery.ID, d.namespace)
+	_, err := d.client.Put(ctx, key, delivery)
+	return err
+}
+
+// GetDeliveries returns all webhook deliveries of a job
+func (d *DatastoreDatabase) GetDeliveries(ctx context.Context, jobID string) ([]Delivery, error) {
+	var deliveries []Delivery
+	query := datastore.NewQuery(d.kind+deliveryKindSuffix).Namespace(d.namespace).Filter("JobID =", jobID)
+	if _, err := d.client.GetAll(ctx, query, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// GetPendingDeliveries returns all webhook deliveries that are still pending
+func (d *DatastoreDatabase) GetPendingDeliveries(ctx context.Context) ([]Delivery, error) {
+	var deliveries []Delivery
+	query := datastore.NewQuery(d.kind+deliveryKindSuffix).Namespace(d.namespace).Filter("Pending =", true)
+	if _, err := d.client.GetAll(ctx, query, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// AppendJobEvent stores an event of a job history
+func (d *DatastoreDatabase) AppendJobEvent(ctx context.Context, event *JobEvent) error {
+	key := newNameKeyWithNamespace(d.kind+eventKindSuffix, event.ID, d.namespace)
+	_, err := d.client.Put(ctx, key, event)
+	return err
+}
+
+// GetJobEvents returns the history of a job
+func (d *DatastoreDatabase) GetJobEvents(ctx context.Context, jobID string) ([]JobEvent, error) {
+	var events []JobEvent
+	query := datastore.NewQuery(d.kind+eventKindSuffix).Namespace(d.namespace).Filter("JobID =", jobID)
+	if _, err := d.client.GetAll(ctx, query, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
 func newNameKeyWithNamespace(kind, name, namespace string) *datastore.Key {
 	key := datastore.NameKey(kind, name, nil)
 	key.Namespace = namespace
@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"errors"
 	"reflect"
 	"testing"
 
@@ -39,6 +40,24 @@ func (c *datastoreTestClient) GetAll(_ context.Context, q *datastore.Query, dst
 	return nil, nil
 }
 
+func (c *datastoreTestClient) Transact(ctx context.Context, fn func(DatastoreTransaction) error) error {
+	return fn(datastoreTestTransaction{ctx, c})
+}
+
+type datastoreTestTransaction struct {
+	ctx    context.Context
+	client *datastoreTestClient
+}
+
+func (tx datastoreTestTransaction) Get(key *datastore.Key, dst interface{}) error {
+	return tx.client.Get(tx.ctx, key, dst)
+}
+
+func (tx datastoreTestTransaction) Put(key *datastore.Key, src interface{}) (*datastore.PendingKey, error) {
+	_, err := tx.client.Put(tx.ctx, key, src)
+	return nil, err
+}
+
 func newTestDB() *DatastoreDatabase {
 	return &DatastoreDatabase{
 		&datastoreTestClient{
@@ -52,6 +71,7 @@ func newTestDB() *DatastoreDatabase {
 func TestStoreJob(t *testing.T) {
 	assert := assert.New(t)
 	db := newTestDB()
+	ctx := context.Background()
 
 	job := &Job{
 		ID:       "123",
@@ -59,36 +79,38 @@ func TestStoreJob(t *testing.T) {
 	}
 
 	assert.Equal(0, len(db.client.(*datastoreTestClient).jobs))
-	id, err := db.StoreJob(job)
+	id, err := db.StoreJob(ctx, job)
 	assert.Equal(job.ID, id)
 	assert.Nil(err)
 	assert.Equal(1, len(db.client.(*datastoreTestClient).jobs))
 
-	_, err = db.StoreJob(job)
+	_, err = db.StoreJob(ctx, job)
 	assert.EqualError(err, "job already exists")
 }
 
 func TestGetJob(t *testing.T) {
 	assert := assert.New(t)
 	db := newTestDB()
+	ctx := context.Background()
 
 	job := &Job{
 		ID:       "123",
 		MediaURL: "https://abc.com/123.mp4",
 	}
 
-	db.StoreJob(job)
-	result, err := db.GetJob("123")
+	db.StoreJob(ctx, job)
+	result, err := db.GetJob(ctx, "123")
 	assert.Equal(job, result)
 	assert.Nil(err)
 
-	_, err = db.GetJob("456")
+	_, err = db.GetJob(ctx, "456")
 	assert.Equal(err, ErrJobNotFound)
 }
 
 func TestUpdateJob(t *testing.T) {
 	assert := assert.New(t)
 	db := newTestDB()
+	ctx := context.Background()
 
 	job := &Job{
 		ID:       "123",
@@ -99,30 +121,59 @@ func TestUpdateJob(t *testing.T) {
 		ID:       "123",
 		MediaURL: "https://abc.com/another.mp4",
 	}
-	db.StoreJob(job)
-	err := db.UpdateJob("123", newJob)
+	db.StoreJob(ctx, job)
+	err := db.UpdateJob(ctx, "123", newJob)
 	assert.Nil(err)
 	assert.Equal(1, len(db.client.(*datastoreTestClient).jobs))
-	afterChange, err := db.GetJob("123")
+	afterChange, err := db.GetJob(ctx, "123")
 	assert.Nil(err)
 	assert.Equal(newJob, afterChange)
 
-	err = db.UpdateJob("234", newJob)
+	err = db.UpdateJob(ctx, "234", newJob)
 	assert.Equal(err, ErrJobNotFound)
 }
 
+func TestModifyJob(t *testing.T) {
+	assert := assert.New(t)
+	db := newTestDB()
+	ctx := context.Background()
+	db.StoreJob(ctx, &Job{ID: "123", Status: StatusCancelled, Done: true})
+
+	job, err := db.ModifyJob(ctx, "123", func(job *Job) error {
+		job.Details = "checked"
+		return nil
+	})
+	assert.Nil(err)
+	assert.Equal(StatusCancelled, job.Status)
+	stored, _ := db.GetJob(ctx, "123")
+	assert.Equal("checked", stored.Details)
+	assert.True(stored.Done)
+
+	_, err = db.ModifyJob(ctx, "123", func(job *Job) error {
+		job.Details = "discarded"
+		return errors.New("not now")
+	})
+	assert.EqualError(err, "not now")
+	stored, _ = db.GetJob(ctx, "123")
+	assert.Equal("checked", stored.Details)
+
+	_, err = db.ModifyJob(ctx, "456", func(*Job) error { return nil })
+	assert.Equal(ErrJobNotFound, err)
+}
+
 func TestDeleteJob(t *testing.T) {
 	assert := assert.New(t)
 	db := newTestDB()
+	ctx := context.Background()
 
 	job := &Job{
 		ID:       "123",
 		MediaURL: "https://abc.com/123.mp4",
 	}
 
-	db.StoreJob(job)
+	db.StoreJob(ctx, job)
 	assert.Equal(1, len(db.client.(*datastoreTestClient).jobs))
-	err := db.DeleteJob("123")
+	err := db.DeleteJob(ctx, "123")
 	assert.Nil(err)
 	assert.Equal(0, len(db.client.(*datastoreTestClient).jobs))
 }
@@ -1,6 +1,9 @@
 package database
 
-import "errors"
+import (
+	"context"
+	"errors"
+)
 
 var (
 	// ErrNoJobs indicates that no jobs can be found for a given parent ID.
@@ -12,10 +15,27 @@ var (
 
 // DB interface for database interactions
 type DB interface {
-	StoreJob(*Job) (string, error)
-	UpdateJob(string, *Job) error
-	GetJob(string) (*Job, error)
-	DeleteJob(string) error
-	GetJobs(string) ([]Job, error)
-	GetJobByProviderID(string) (*Job, error)
+	StoreJob(context.Context, *Job) (string, error)
+	UpdateJob(context.Context, string, *Job) error
+	// ModifyJob reads a job, applies fn to it and stores it in one transaction,
+	// so changes made to the job in the meantime aren't overwritten. fn may run
+	// more than once and must not use the DB, the job isn't stored when fn fails.
+	ModifyJob(context.Context, string, func(*Job) error) (*Job, error)
+	GetJob(context.Context, string) (*Job, error)
+	DeleteJob(context.Context, string) error
+	GetJobs(context.Context, string) ([]Job, error)
+	GetJobByProviderID(context.Context, string) (*Job, error)
+	GetJobsByMediaURL(context.Context, string) ([]Job, error)
+	GetPendingJobs(context.Context) ([]Job, error)
+	GetQueuedJobs(context.Context) ([]Job, error)
+	StoreSubscription(context.Context, *Subscription) error
+	GetSubscriptions(context.Context) ([]Subscription, error)
+	DeleteSubscription(context.Context, string) error
+	// SaveDelivery creates or updates a webhook delivery
+	SaveDelivery(context.Context, *Delivery) error
+	GetDeliveries(context.Context, string) ([]Delivery, error)
+	GetPendingDeliveries(context.Context) ([]Delivery, error)
+	// AppendJobEvent adds an event to the history of a job, events are never updated
+	AppendJobEvent(context.Context, *JobEvent) error
+	GetJobEvents(context.Context, string) ([]JobEvent, error)
 }
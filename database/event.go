@@ -0,0 +1,45 @@
+package database
+
+import "time"
+
+// JobEventType is the kind of a JobEvent
+type JobEventType string
+
+// Job event types
+const (
+	JobEventCreated        JobEventType = "created"
+	JobEventImported       JobEventType = "imported"
+	JobEventDispatched     JobEventType = "dispatched"
+	JobEventStatusChanged  JobEventType = "status_changed"
+	JobEventOutputStored   JobEventType = "output_stored"
+	JobEventCaptionUpdated JobEventType = "caption_updated"
+	JobEventResubmitted    JobEventType = "resubmitted"
+	JobEventCancelled      JobEventType = "cancelled"
+	JobEventOverdue        JobEventType = "overdue"
+	JobEventError          JobEventType = "error"
+)
+
+// JobEvent is an entry of the append-only history of a job
+type JobEvent struct {
+	ID        string       `json:"id"`
+	JobID     string       `json:"job_id"`
+	Type      JobEventType `json:"type"`
+	CreatedAt time.Time    `json:"created_at"`
+	Provider  string       `json:"provider,omitempty"`
+	// Status is the job status after the event
+	Status JobStatus `json:"status,omitempty"`
+	// ProviderStatus is the status reported by the vendor on status changes
+	ProviderStatus string `json:"provider_status,omitempty"`
+	Details        string `json:"details,omitempty" datastore:",noindex"`
+	// Payload is the raw vendor response a status change was read from
+	Payload string `json:"payload,omitempty" datastore:",noindex"`
+}
+
+// ByEventCreatedAt implements sort.Interface for []JobEvent by CreatedAt field.
+type ByEventCreatedAt []JobEvent
+
+func (b ByEventCreatedAt) Len() int { return len(b) }
+
+func (b ByEventCreatedAt) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+
+func (b ByEventCreatedAt) Less(i, j int) bool { return b[i].CreatedAt.Before(b[j].CreatedAt) }
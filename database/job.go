@@ -8,8 +8,12 @@ import (
 
 // ProviderJob holds data coming from a Provider
 type ProviderJob struct {
-	ID          string
-	Status      string
+	ID     string
+	Status JobStatus
+	// RawStatus is the status as reported by the vendor
+	RawStatus string
+	// Payload is the raw vendor response the job was read from, when available
+	Payload     []byte
 	Details     string
 	Params      map[string]string
 	Cancellable bool
@@ -20,7 +24,7 @@ type Job struct {
 	ID             string         `json:"id"`
 	ParentID       string         `json:"parent_id"`
 	MediaURL       string         `json:"media_url"`
-	Status         string         `json:"status"`
+	Status         JobStatus      `json:"status"`
 	Provider       string         `json:"provider"`
 	ProviderParams ProviderParams `json:"provider_params"`
 	CreatedAt      time.Time      `json:"created_at"`
@@ -30,6 +34,65 @@ type Job struct {
 	Details        string         `json:"details,omitempty"`
 	CaptionFile    UploadedFile   `json:"caption_file,omitempty"`
 	JobType        string         `json:"job_type"`
+	// Providers is the ordered list of providers the job can be dispatched to,
+	// Provider is the one that accepted it
+	Providers        []string          `json:"providers,omitempty"`
+	DispatchAttempts []DispatchAttempt `json:"dispatch_attempts,omitempty"`
+	// Tenant selects the provider credentials the job is dispatched with
+	Tenant string `json:"tenant,omitempty"`
+	// CaptionVersions holds the caption files an upload job had before CaptionFile
+	CaptionVersions []CaptionVersion `json:"caption_versions,omitempty"`
+	// Submissions archives the earlier submissions of a resubmitted job
+	Submissions []Submission `json:"submissions,omitempty"`
+	// ProviderStatus is the last status reported by the vendor, before it
+	// was translated to Status
+	ProviderStatus string `json:"provider_status,omitempty"`
+	// CallbackURL is notified of the job webhook events
+	CallbackURL string `json:"callback_url,omitempty"`
+	// LastCheckedAt is when the reconciler last refreshed the job from its provider
+	LastCheckedAt time.Time `json:"last_checked_at"`
+	// Deadline is when the job is expected to be delivered, from the
+	// turnaround of its provider when it was dispatched
+	Deadline time.Time `json:"deadline"`
+	// OverdueAt is when the job was found to be past its Deadline
+	OverdueAt time.Time `json:"overdue_at"`
+	// Priority orders the dispatch queue, higher first
+	Priority int       `json:"priority"`
+	QueuedAt time.Time `json:"queued_at"`
+	// LeaseExpiresAt is when the dispatch of a dispatching job is given up on,
+	// the worker that claimed it was interrupted if it is still dispatching
+	LeaseExpiresAt time.Time `json:"-"`
+	// QueuePosition is the rank of a queued job in the dispatch queue, from 1
+	QueuePosition int `json:"queue_position,omitempty" datastore:"-"`
+}
+
+// Submission is the state a job was in when it was resubmitted
+type Submission struct {
+	Provider         string            `json:"provider"`
+	ProviderParams   ProviderParams    `json:"provider_params"`
+	Status           JobStatus         `json:"status"`
+	Details          string            `json:"details,omitempty" datastore:",noindex"`
+	DispatchAttempts []DispatchAttempt `json:"dispatch_attempts,omitempty"`
+	ResubmittedAt    time.Time         `json:"resubmitted_at"`
+}
+
+// CaptionVersion is a caption file replaced by a newer upload. The file is
+// kept in storage at URL, GET /jobs/{id}/caption/{version} redirects to it.
+type CaptionVersion struct {
+	Version    int       `json:"version"`
+	Name       string    `json:"name"`
+	URL        string    `json:"url" datastore:",noindex"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// DispatchAttempt records an attempt to dispatch a Job to a provider
+type DispatchAttempt struct {
+	Provider string    `json:"provider"`
+	Error    string    `json:"error,omitempty" datastore:",noindex"`
+	At       time.Time `json:"at"`
+	// Indeterminate is set when the provider may have accepted the job even
+	// though the attempt failed, e.g. it timed out
+	Indeterminate bool `json:"indeterminate,omitempty"`
 }
 
 // UploadedFile contains the uploaded file and its name
@@ -43,6 +106,21 @@ type JobOutput struct {
 	URL      string `json:"url"`
 	Type     string `json:"type"`
 	Filename string `json:"filename"`
+	// Attempts counts the downloads and stores of the output, LastError and
+	// NextRetryAt describe the last failed one
+	Attempts    int       `json:"attempts,omitempty"`
+	LastError   string    `json:"last_error,omitempty" datastore:",noindex"`
+	NextRetryAt time.Time `json:"next_retry_at"`
+	// Failed is true when the output won't be retried anymore
+	Failed bool `json:"failed,omitempty"`
+}
+
+// ResetOutputs clears the stored URLs and attempts of the job outputs so they
+// are downloaded again
+func (j *Job) ResetOutputs() {
+	for i, output := range j.Outputs {
+		j.Outputs[i] = JobOutput{Type: output.Type, Filename: output.Filename}
+	}
 }
 
 // JobSummary minimal information about a Job
@@ -63,12 +141,13 @@ func (b ByCreatedAt) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
 
 func (b ByCreatedAt) Less(i, j int) bool { return b[i].CreatedAt.Before(b[j].CreatedAt) }
 
-// UpdateStatus update Job status and mark as done if needed
-func (j *Job) UpdateStatus(status, details string) bool {
-	if j.Status == status {
+// UpdateStatus update Job status and mark as done if needed, transitions
+// JobStatus doesn't allow are ignored
+func (j *Job) UpdateStatus(status JobStatus, details string) bool {
+	if j.Status == status || !j.Status.CanTransitionTo(status) {
 		return false
 	}
-	if status == "error" && !j.Done {
+	if status == StatusError || status == StatusCancelled {
 		j.Done = true
 	}
 	j.Status = status
@@ -76,6 +155,26 @@ func (j *Job) UpdateStatus(status, details string) bool {
 	return true
 }
 
+// ByQueueOrder implements sort.Interface for []Job in the order queued jobs are
+// dispatched: by Priority, then by QueuedAt.
+type ByQueueOrder []Job
+
+func (b ByQueueOrder) Len() int { return len(b) }
+
+func (b ByQueueOrder) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+
+func (b ByQueueOrder) Less(i, j int) bool {
+	if b[i].Priority != b[j].Priority {
+		return b[i].Priority > b[j].Priority
+	}
+	return b[i].QueuedAt.Before(b[j].QueuedAt)
+}
+
+// IsOverdue tells whether a job that isn't done is past its deadline
+func (j *Job) IsOverdue(now time.Time) bool {
+	return !j.Done && !j.Deadline.IsZero() && now.After(j.Deadline)
+}
+
 func (j *Job) GetProviderID() string {
 	return j.ProviderParams["ProviderID"]
 }
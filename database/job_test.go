@@ -2,6 +2,7 @@ package database
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -28,3 +29,69 @@ func TestJobUpdate(t *testing.T) {
 	assert.False(job.UpdateStatus("error", "more details"))
 	assert.Equal(job.Details, "error details")
 }
+
+func TestJobUpdateTransitions(t *testing.T) {
+	assert := assert.New(t)
+	job := Job{Status: StatusProcessing}
+
+	assert.True(job.UpdateStatus(StatusInReview, ""))
+	assert.True(job.UpdateStatus(StatusCancelled, "cancelled by vendor"))
+	assert.True(job.Done)
+	assert.False(job.UpdateStatus(StatusProcessing, ""))
+	assert.False(job.UpdateStatus(StatusDelivered, ""))
+	assert.Equal(StatusCancelled, job.Status)
+	assert.Equal("cancelled by vendor", job.Details)
+
+	job = Job{Status: StatusDelivered}
+	assert.False(job.UpdateStatus(StatusProcessing, ""))
+	assert.False(job.UpdateStatus(JobStatus("archived"), ""))
+	assert.False(job.UpdateStatus(StatusProcessing, ""))
+	assert.Equal(StatusDelivered, job.Status)
+
+	// jobs stored with a vendor status can move to a canonical one
+	job = Job{Status: JobStatus("in_progress")}
+	assert.True(job.UpdateStatus(StatusProcessing, ""))
+}
+
+func TestJobIsOverdue(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	job := Job{Status: StatusProcessing}
+	assert.False(job.IsOverdue(now))
+
+	job.Deadline = now.Add(time.Minute)
+	assert.False(job.IsOverdue(now))
+	assert.True(job.IsOverdue(now.Add(time.Hour)))
+
+	job.Done = true
+	assert.False(job.IsOverdue(now.Add(time.Hour)))
+}
+
+func TestJobStatusCanTransitionTo(t *testing.T) {
+	tests := []struct {
+		from, to JobStatus
+		allowed  bool
+	}{
+		{StatusProcessing, StatusComplete, true},
+		{StatusComplete, StatusDelivered, true},
+		{StatusError, StatusProcessing, true},
+		{StatusError, StatusComplete, false},
+		{StatusCancelled, StatusProcessing, false},
+		{StatusCancelled, StatusCancelled, true},
+		{StatusQueued, StatusDispatching, true},
+		{StatusQueued, StatusProcessing, false},
+		{StatusDispatching, StatusProcessing, true},
+		{StatusDispatching, StatusQueued, false},
+		{StatusError, StatusQueued, true},
+		{StatusQueued, StatusDelivered, false},
+		{StatusProcessing, StatusQueued, false},
+		{JobStatus("in_progress"), StatusDelivered, true},
+		{StatusProcessing, JobStatus("My status"), false},
+		{StatusCancelled, JobStatus("archived"), false},
+		{JobStatus("archived"), JobStatus("archived"), false},
+		{StatusProcessing, JobStatus(""), false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.allowed, test.from.CanTransitionTo(test.to), "%s -> %s", test.from, test.to)
+	}
+}
@@ -1,26 +1,33 @@
 package database
 
 import (
+	"context"
 	"errors"
 	"sync"
 )
 
 // MemoryDatabase memory based database implementation for the DB interface
 type MemoryDatabase struct {
-	mtx  sync.Mutex
-	jobs map[string]*Job
+	mtx           sync.Mutex
+	jobs          map[string]*Job
+	subscriptions map[string]Subscription
+	deliveries    map[string]Delivery
+	events        map[string][]JobEvent
 }
 
 // NewMemoryDatabase creates a MemoryDatabase
 func NewMemoryDatabase() *MemoryDatabase {
 	return &MemoryDatabase{
-		jobs: make(map[string]*Job),
+		jobs:          make(map[string]*Job),
+		subscriptions: make(map[string]Subscription),
+		deliveries:    make(map[string]Delivery),
+		events:        make(map[string][]JobEvent),
 	}
 }
 
 // StoreJob stores Job in-memory
-func (db *MemoryDatabase) StoreJob(job *Job) (string, error) {
-	if _, err := db.GetJob(job.ID); err == nil {
+func (db *MemoryDatabase) StoreJob(ctx context.Context, job *Job) (string, error) {
+	if _, err := db.GetJob(ctx, job.ID); err == nil {
 		return "", errors.New("job already exists")
 	}
 
@@ -32,8 +39,8 @@ func (db *MemoryDatabase) StoreJob(job *Job) (string, error) {
 }
 
 // UpdateJob updates Job in-memory
-func (db *MemoryDatabase) UpdateJob(id string, job *Job) error {
-	if _, err := db.GetJob(id); err != nil {
+func (db *MemoryDatabase) UpdateJob(ctx context.Context, id string, job *Job) error {
+	if _, err := db.GetJob(ctx, id); err != nil {
 		return ErrJobNotFound
 	}
 
@@ -44,8 +51,25 @@ func (db *MemoryDatabase) UpdateJob(id string, job *Job) error {
 	return nil
 }
 
+// ModifyJob applies fn to a copy of a Job and stores it
+func (db *MemoryDatabase) ModifyJob(_ context.Context, id string, fn func(*Job) error) (*Job, error) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	stored, ok := db.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	job := *stored
+	if err := fn(&job); err != nil {
+		return nil, err
+	}
+	db.jobs[id] = &job
+	return &job, nil
+}
+
 // GetJob returns a Job given its ID
-func (db *MemoryDatabase) GetJob(id string) (*Job, error) {
+func (db *MemoryDatabase) GetJob(_ context.Context, id string) (*Job, error) {
 	db.mtx.Lock()
 	defer db.mtx.Unlock()
 
@@ -56,7 +80,7 @@ func (db *MemoryDatabase) GetJob(id string) (*Job, error) {
 }
 
 // DeleteJob deletes a Job given its ID
-func (db *MemoryDatabase) DeleteJob(id string) error {
+func (db *MemoryDatabase) DeleteJob(_ context.Context, id string) error {
 	db.mtx.Lock()
 	defer db.mtx.Unlock()
 
@@ -65,7 +89,7 @@ func (db *MemoryDatabase) DeleteJob(id string) error {
 }
 
 // GetJobs Returns all Jobs stored for the same ParentID
-func (db *MemoryDatabase) GetJobs(parentID string) ([]Job, error) {
+func (db *MemoryDatabase) GetJobs(_ context.Context, parentID string) ([]Job, error) {
 	db.mtx.Lock()
 	defer db.mtx.Unlock()
 
@@ -80,7 +104,7 @@ func (db *MemoryDatabase) GetJobs(parentID string) ([]Job, error) {
 }
 
 // GetJobByProviderID Returns returns a job matching the ProviderID
-func (db *MemoryDatabase) GetJobByProviderID(providerID string) (*Job, error) {
+func (db *MemoryDatabase) GetJobByProviderID(_ context.Context, providerID string) (*Job, error) {
 	db.mtx.Lock()
 	defer db.mtx.Unlock()
 
@@ -94,3 +118,135 @@ func (db *MemoryDatabase) GetJobByProviderID(providerID string) (*Job, error) {
 
 	return nil, ErrNoJobs
 }
+
+// GetPendingJobs returns all Jobs that aren't done
+func (db *MemoryDatabase) GetPendingJobs(_ context.Context) ([]Job, error) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	var jobList []Job
+	for _, job := range db.jobs {
+		if !job.Done {
+			jobList = append(jobList, *job)
+		}
+	}
+	return jobList, nil
+}
+
+// GetQueuedJobs returns all Jobs waiting to be dispatched
+func (db *MemoryDatabase) GetQueuedJobs(_ context.Context) ([]Job, error) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	var jobList []Job
+	for _, job := range db.jobs {
+		if job.Status == StatusQueued {
+			jobList = append(jobList, *job)
+		}
+	}
+	return jobList, nil
+}
+
+// GetJobsByMediaURL returns all Jobs created for the same media URL
+func (db *MemoryDatabase) GetJobsByMediaURL(_ context.Context, mediaURL string) ([]Job, error) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	var jobList []Job
+	for _, job := range db.jobs {
+		if mediaURL == job.MediaURL {
+			jobList = append(jobList, *job)
+		}
+	}
+	if len(jobList) == 0 {
+		return nil, ErrNoJobs
+	}
+	return jobList, nil
+}
+
+// StoreSubscription stores a webhook Subscription in-memory
+func (db *MemoryDatabase) StoreSubscription(_ context.Context, subscription *Subscription) error {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	db.subscriptions[subscription.ID] = *subscription
+	return nil
+}
+
+// GetSubscriptions returns all webhook Subscriptions
+func (db *MemoryDatabase) GetSubscriptions(_ context.Context) ([]Subscription, error) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	var subscriptions []Subscription
+	for _, subscription := range db.subscriptions {
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
+
+// DeleteSubscription deletes a webhook Subscription
+func (db *MemoryDatabase) DeleteSubscription(_ context.Context, id string) error {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	if _, ok := db.subscriptions[id]; !ok {
+		return ErrSubscriptionNotFound
+	}
+	delete(db.subscriptions, id)
+	return nil
+}
+
+// AppendJobEvent appends an event to the in-memory history of its Job
+func (db *MemoryDatabase) AppendJobEvent(_ context.Context, event *JobEvent) error {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	db.events[event.JobID] = append(db.events[event.JobID], *event)
+	return nil
+}
+
+// GetJobEvents returns the history of a Job in the order it was appended
+func (db *MemoryDatabase) GetJobEvents(_ context.Context, jobID string) ([]JobEvent, error) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	return append([]JobEvent(nil), db.events[jobID]...), nil
+}
+
+// SaveDelivery stores or updates a webhook Delivery in-memory
+func (db *MemoryDatabase) SaveDelivery(_ context.Context, delivery *Delivery) error {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	db.deliveries[delivery.ID] = *delivery
+	return nil
+}
+
+// GetDeliveries returns all webhook Deliveries of a Job
+func (db *MemoryDatabase) GetDeliveries(_ context.Context, jobID string) ([]Delivery, error) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	var deliveries []Delivery
+	for _, delivery := range db.deliveries {
+		if delivery.JobID == jobID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries, nil
+}
+
+// GetPendingDeliveries returns all webhook Deliveries that are still pending
+func (db *MemoryDatabase) GetPendingDeliveries(_ context.Context) ([]Delivery, error) {
+	db.mtx.Lock()
+	defer db.mtx.Unlock()
+
+	var deliveries []Delivery
+	for _, delivery := range db.deliveries {
+		if delivery.Pending {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,61 @@
+package database
+
+// JobStatus is the canonical status of a job, providers translate their vendor
+// statuses to one of these
+type JobStatus string
+
+// Canonical job statuses
+const (
+	StatusQueued      JobStatus = "queued"
+	StatusDispatching JobStatus = "dispatching"
+	StatusProcessing  JobStatus = "processing"
+	StatusInReview    JobStatus = "in review"
+	StatusComplete    JobStatus = "complete"
+	StatusDelivered   JobStatus = "delivered"
+	StatusCancelled   JobStatus = "cancelled"
+	StatusError       JobStatus = "error"
+)
+
+// statusTransitions lists the statuses a job can move to from each canonical status
+var statusTransitions = map[JobStatus][]JobStatus{
+	// queued jobs wait to be dispatched, dispatching ones are claimed by a
+	// dispatch queue worker
+	StatusQueued:      {StatusDispatching, StatusCancelled, StatusError},
+	StatusDispatching: {StatusProcessing, StatusCancelled, StatusError},
+	StatusProcessing:  {StatusInReview, StatusComplete, StatusDelivered, StatusCancelled, StatusError},
+	StatusInReview:    {StatusProcessing, StatusComplete, StatusDelivered, StatusCancelled, StatusError},
+	StatusComplete:    {StatusInReview, StatusDelivered, StatusCancelled, StatusError},
+	StatusDelivered:   {StatusInReview, StatusComplete, StatusError},
+	// failed jobs can be resubmitted, right away or through the dispatch queue
+	StatusError:     {StatusProcessing, StatusQueued},
+	StatusCancelled: {},
+}
+
+// Known tells whether a status is one of the canonical statuses
+func (s JobStatus) Known() bool {
+	_, ok := statusTransitions[s]
+	return ok
+}
+
+// CanTransitionTo tells whether a job can move from s to next. Jobs only move
+// to canonical statuses, jobs stored with a status that isn't canonical can move
+// to any of them.
+func (s JobStatus) CanTransitionTo(next JobStatus) bool {
+	if !next.Known() {
+		return false
+	}
+	if s == next || !s.Known() {
+		return true
+	}
+	for _, allowed := range statusTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// IsReady tells whether the outputs of a job with this status can be downloaded
+func (s JobStatus) IsReady() bool {
+	return s == StatusComplete || s == StatusDelivered
+}
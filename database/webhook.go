@@ -0,0 +1,49 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrSubscriptionNotFound indicates that no webhook subscription can be found for a given ID.
+var ErrSubscriptionNotFound = errors.New("subscription not found")
+
+// Subscription is a webhook notified of the events of every job
+type Subscription struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Events filters the notified events, all of them when empty
+	Events []string `json:"events,omitempty"`
+	// Secret signs the deliveries, instead of the service webhook secret
+	Secret    string    `json:"-" datastore:",noindex"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Delivery is a webhook notification of a job event and the attempts made to deliver it
+type Delivery struct {
+	ID    string `json:"id"`
+	JobID string `json:"job_id"`
+	// SubscriptionID is empty for deliveries to the job callback_url
+	SubscriptionID string    `json:"subscription_id,omitempty"`
+	URL            string    `json:"url"`
+	Event          string    `json:"event"`
+	Payload        []byte    `json:"-" datastore:",noindex"`
+	CreatedAt      time.Time `json:"created_at"`
+	Attempts       int       `json:"attempts"`
+	LastStatusCode int       `json:"last_status_code,omitempty"`
+	LastError      string    `json:"last_error,omitempty" datastore:",noindex"`
+	NextRetryAt    time.Time `json:"next_retry_at"`
+	DeliveredAt    time.Time `json:"delivered_at"`
+	// Pending is true until the delivery succeeded or failed for good
+	Pending bool `json:"pending"`
+	Failed  bool `json:"failed,omitempty"`
+}
+
+// ByDeliveryCreatedAt implements sort.Interface for []Delivery by CreatedAt field.
+type ByDeliveryCreatedAt []Delivery
+
+func (b ByDeliveryCreatedAt) Len() int { return len(b) }
+
+func (b ByDeliveryCreatedAt) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+
+func (b ByDeliveryCreatedAt) Less(i, j int) bool { return b[i].CreatedAt.Before(b[j].CreatedAt) }
@@ -9,9 +9,12 @@ require (
 	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/nytimes/amara v0.4.0
 	github.com/nytimes/threeplay v0.3.2
+	github.com/prometheus/client_golang v0.9.4
 	github.com/sirupsen/logrus v1.7.0
 	github.com/stretchr/testify v1.7.0
 	github.com/tdewolff/parse/v2 v2.4.3
+	golang.org/x/oauth2 v0.0.0-20210218202405-ba52d332ba99
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
 
 go 1.13
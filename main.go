@@ -1,10 +1,14 @@
 package main
 
 import (
+	"context"
+
 	"github.com/NYTimes/gizmo/server"
 	"github.com/nytimes/video-captions-api/config"
+	"github.com/nytimes/video-captions-api/credentials"
 	"github.com/nytimes/video-captions-api/database"
 	"github.com/nytimes/video-captions-api/providers"
+	"github.com/nytimes/video-captions-api/routing"
 	"github.com/nytimes/video-captions-api/service"
 	"github.com/kelseyhightower/envconfig"
 )
@@ -17,13 +21,49 @@ func main() {
 	if err != nil {
 		server.Log.Fatal("Unable to create Datastore client", err)
 	}
+	credentialsConfig := credentials.LoadConfigFromEnv()
+	if credentialsConfig.Project == "" {
+		credentialsConfig.Project = cfg.ProjectID
+	}
+	cfg.Credentials, err = credentials.NewStore(context.Background(), credentialsConfig)
+	if err != nil {
+		server.Log.Fatal("Unable to create credential store: ", err)
+	}
 	threeplayConfig := providers.Load3PlayConfigFromEnv()
 	amaraConfig := providers.LoadAmaraConfigFromEnv()
+	revConfig := providers.LoadRevConfigFromEnv()
+	commandConfig := providers.LoadCommandConfigFromEnv()
+	sandboxConfig := providers.LoadSandboxConfigFromEnv()
 	captionsService := service.NewCaptionsService(&cfg, db)
 
-	captionsService.AddProvider(providers.New3PlayProvider(&threeplayConfig, &cfg))
+	captionsService.AddProvider(providers.New3PlayProvider(&threeplayConfig, &cfg, db))
 	captionsService.AddProvider(providers.NewAmaraProvider(&amaraConfig, &cfg))
 	captionsService.AddProvider(providers.NewUploadProvider(&cfg, db))
+	captionsService.AddProvider(providers.NewRevProvider(&revConfig, &cfg))
+	captionsService.AddProvider(providers.NewCommandProvider(&commandConfig, &cfg))
+	if sandboxConfig.Configured() {
+		captionsService.AddProvider(providers.NewSandboxProvider(&sandboxConfig, &cfg))
+	}
+
+	genericHTTPConfig := providers.LoadGenericHTTPConfigFromEnv()
+	genericProviders, err := providers.LoadGenericHTTPProviders(&genericHTTPConfig, &cfg)
+	if err != nil {
+		server.Log.Fatal("Unable to load generic HTTP providers: ", err)
+	}
+	for _, provider := range genericProviders {
+		captionsService.AddProvider(provider)
+	}
+	if cfg.RoutingRulesFile != "" {
+		router, err := routing.LoadRouter(cfg.RoutingRulesFile)
+		if err != nil {
+			server.Log.Fatal("Unable to load routing rules: ", err)
+		}
+		captionsService.SetRouter(router)
+	}
+	captionsService.StartWebhookSender(context.Background(), &cfg)
+	captionsService.StartDispatchQueue(context.Background(), &cfg)
+	captionsService.StartReconciler(context.Background(), &cfg)
+	captionsService.StartMaintenance(context.Background(), &cfg)
 	server.Init("video-captions-api", cfg.Server)
 
 	err = server.Register(captionsService)
@@ -1,23 +1,40 @@
 package providers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/nytimes/video-captions-api/config"
+	"github.com/nytimes/video-captions-api/credentials"
 	"github.com/nytimes/video-captions-api/database"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/nytimes/amara"
 	log "github.com/sirupsen/logrus"
 )
 
+// amaraAPIURL is the Amara API root, the amara client doesn't expose it
+const amaraAPIURL = "https://amara.org/api"
+
 // AmaraProvider amara client wrapper that implements the Provider interface
 type AmaraProvider struct {
 	*amara.Client
 	logger   *log.Logger
 	username string
 	team     string
+	token    string
+	config   AmaraConfig
+
+	credentials credentials.Store
+	mtx         sync.Mutex
+	// clients holds the clients of credentials other than AMARA_TOKEN, by token
+	clients map[string]*amara.Client
 }
 
 // AmaraConfig holds Amara related config
@@ -25,6 +42,19 @@ type AmaraConfig struct {
 	Username string `envconfig:"AMARA_USERNAME"`
 	Team     string `envconfig:"AMARA_TEAM"`
 	Token    string `envconfig:"AMARA_TOKEN"`
+	// RatePerMinute and Turnaround are what the team charges and takes per media minute,
+	// jobs can't be estimated when no rate is set
+	RatePerMinute float64       `envconfig:"AMARA_RATE_PER_MINUTE"`
+	Currency      string        `envconfig:"AMARA_CURRENCY" default:"USD"`
+	Turnaround    time.Duration `envconfig:"AMARA_TURNAROUND" default:"72h"`
+}
+
+// AmaraNotification is the payload of an Amara team notification
+type AmaraNotification struct {
+	Event        string `json:"event"`
+	VideoID      string `json:"video_id"`
+	LanguageCode string `json:"language_code"`
+	Team         string `json:"team"`
 }
 
 // NewAmaraProvider creates an AmaraProvider
@@ -32,14 +62,39 @@ func NewAmaraProvider(cfg *AmaraConfig, svcCfg *config.CaptionsServiceConfig) Pr
 	client := amara.NewClient(cfg.Token, cfg.Team)
 	client.EnableRateLimitProtection()
 	return &AmaraProvider{
-		client,
-		svcCfg.Logger,
-		cfg.Username,
-		cfg.Team,
+		Client:      client,
+		logger:      svcCfg.Logger,
+		username:    cfg.Username,
+		team:        cfg.Team,
+		token:       cfg.Token,
+		config:      *cfg,
+		credentials: svcCfg.Credentials,
+		clients:     make(map[string]*amara.Client),
 	}
 }
 
-// LoadAmaraConfigFromEnv loads Amara username, token and team from environment
+// clientFor returns a client authenticated with the credential of the job
+// tenant and job type, falling back to AMARA_TOKEN, and the token it uses
+func (c *AmaraProvider) clientFor(ctx context.Context, job *database.Job) (*amara.Client, string, error) {
+	token, err := lookupCredential(ctx, c.credentials, c.GetName(), job, "", c.token)
+	if err != nil {
+		return nil, "", err
+	}
+	if token == c.token {
+		return c.Client, token, nil
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	client, ok := c.clients[token]
+	if !ok {
+		client = amara.NewClient(token, c.team)
+		client.EnableRateLimitProtection()
+		c.clients[token] = client
+	}
+	return client, token, nil
+}
+
+// LoadAmaraConfigFromEnv loads Amara username, token, team and rates from environment
 func LoadAmaraConfigFromEnv() AmaraConfig {
 	var providerConfig AmaraConfig
 	envconfig.Process("", &providerConfig)
@@ -51,9 +106,46 @@ func (c *AmaraProvider) GetName() string {
 	return "amara"
 }
 
+// GetCapabilities returns the output formats and params supported by Amara
+func (c *AmaraProvider) GetCapabilities() Capabilities {
+	return Capabilities{
+		Name:             c.GetName(),
+		OutputTypes:      []string{"vtt", "srt", "sbv", "ssa", "dfxp", "txt", "json"},
+		Cancellable:      true,
+		AdditionalParams: true,
+		Importable:       true,
+	}
+}
+
+// Turnaround returns the configured delivery time of the team
+func (c *AmaraProvider) Turnaround(_ database.ProviderParams) (time.Duration, error) {
+	return c.config.Turnaround, nil
+}
+
+// Estimate prices a job at the configured rate per media minute
+func (c *AmaraProvider) Estimate(req EstimateRequest) (*Estimate, error) {
+	if c.config.RatePerMinute <= 0 {
+		return nil, errors.New("amara rates are not configured")
+	}
+	return &Estimate{
+		Cost:       perMinuteCost(req.Duration, c.config.RatePerMinute),
+		Currency:   c.config.Currency,
+		Turnaround: c.config.Turnaround,
+		Details:    fmt.Sprintf("%.2f %s per minute", c.config.RatePerMinute, c.config.Currency),
+	}, nil
+}
+
 // Download download latest subtitle version from Amara
-func (c *AmaraProvider) Download(job *database.Job, captionFormat string) ([]byte, error) {
-	sub, err := c.GetRawSubtitles(job.GetProviderID(), "en", captionFormat)
+func (c *AmaraProvider) Download(ctx context.Context, job *database.Job, captionFormat string) ([]byte, error) {
+	client, _, err := c.clientFor(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	var sub []byte
+	err = withContext(ctx, func() (err error) {
+		sub, err = client.GetRawSubtitles(job.GetProviderID(), "en", captionFormat)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -61,25 +153,40 @@ func (c *AmaraProvider) Download(job *database.Job, captionFormat string) ([]byt
 }
 
 // GetProviderJob returns current job status from Amara
-func (c *AmaraProvider) GetProviderJob(job *database.Job) (*database.ProviderJob, error) {
-	subs, err := c.GetSubtitleInfo(job.GetProviderID(), "en")
-	status := "in review"
+func (c *AmaraProvider) GetProviderJob(ctx context.Context, job *database.Job) (*database.ProviderJob, error) {
+	client, _, err := c.clientFor(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	var subs *amara.SubtitleInfo
+	err = withContext(ctx, func() (err error) {
+		subs, err = client.GetSubtitleInfo(job.GetProviderID(), "en")
+		return err
+	})
+	status := database.StatusInReview
 	if err != nil {
 		return nil, err
 	}
-	lang, err := c.GetLanguage(job.GetProviderID(), "en")
+	var lang *amara.Language
+	err = withContext(ctx, func() (err error) {
+		lang, err = client.GetLanguage(job.GetProviderID(), "en")
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	rawStatus := "subtitles_incomplete"
 	if lang.SubtitlesComplete {
-		status = "delivered"
+		status = database.StatusDelivered
+		rawStatus = "subtitles_complete"
 	}
 
 	return &database.ProviderJob{
-		ID:      job.GetProviderID(),
-		Status:  status,
-		Details: "Version " + strconv.Itoa(subs.VersionNumber),
+		ID:        job.GetProviderID(),
+		Status:    status,
+		RawStatus: rawStatus,
+		Details:   "Version " + strconv.Itoa(subs.VersionNumber),
 		Params: map[string]string{
 			"SubVersion": strconv.Itoa(subs.VersionNumber),
 		},
@@ -87,7 +194,11 @@ func (c *AmaraProvider) GetProviderJob(job *database.Job) (*database.ProviderJob
 }
 
 // DispatchJob creates a video and adds subtitle to it
-func (c *AmaraProvider) DispatchJob(job *database.Job) error {
+func (c *AmaraProvider) DispatchJob(ctx context.Context, job *database.Job) error {
+	client, _, err := c.clientFor(ctx, job)
+	if err != nil {
+		return err
+	}
 	params := url.Values{}
 
 	for k, v := range job.ProviderParams {
@@ -97,29 +208,44 @@ func (c *AmaraProvider) DispatchJob(job *database.Job) error {
 	params.Add("team", c.team)
 	params.Add("video_url", job.MediaURL)
 
-	video, err := c.CreateVideo(params)
+	var video *amara.Video
+	err = withContext(ctx, func() (err error) {
+		video, err = client.CreateVideo(params)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("could not create video: %v", err)
+		return fmt.Errorf("could not create video: %w", err)
 	}
 	if video.ID == "" {
 		return fmt.Errorf("received invalid video: %v", video)
 	}
-	subs, err := c.CreateSubtitles(video.ID, job.Language, "vtt", params)
+	var subs *amara.SubtitleInfo
+	err = withContext(ctx, func() (err error) {
+		subs, err = client.CreateSubtitles(video.ID, job.Language, "vtt", params)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("could not create subtitles: %v", err)
+		return fmt.Errorf("could not create subtitles: %w", err)
 	}
 
 	// when we create a video, complete is already true,
 	// making it harder for us to know when it's actually complete.
 	// calling UpdateLanguage just to set complete to false.
-	_, err = c.UpdateLanguage(video.ID, job.Language, false)
+	err = withContext(ctx, func() error {
+		_, err := client.UpdateLanguage(video.ID, job.Language, false)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("could not update language: %v", err)
+		return fmt.Errorf("could not update language: %w", err)
 	}
 
-	editorSession, err := c.EditorLogin(video.ID, job.Language, c.username)
+	var editorSession *amara.EditorLoginSession
+	err = withContext(ctx, func() (err error) {
+		editorSession, err = client.EditorLogin(video.ID, job.Language, c.username)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("could not create editor login: %v", err)
+		return fmt.Errorf("could not create editor login: %w", err)
 	}
 
 	job.ProviderParams["ProviderID"] = video.ID
@@ -128,7 +254,67 @@ func (c *AmaraProvider) DispatchJob(job *database.Job) error {
 	return nil
 }
 
-// CancelJob dummy method as amara cannot cancel jobs
-func (c *AmaraProvider) CancelJob(job *database.Job) (bool, error) {
-	return false, nil
+// ParseCallback parses an Amara team notification, jobs are resolved by video ID
+func (c *AmaraProvider) ParseCallback(query url.Values, body []byte) (*Callback, error) {
+	var notification AmaraNotification
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return nil, err
+	}
+	if notification.VideoID == "" {
+		return nil, ErrInvalidProviderID
+	}
+	return &Callback{
+		JobID:      query.Get("job_id"),
+		ProviderID: notification.VideoID,
+		Status:     notification.Event,
+	}, nil
+}
+
+// CancelJob deletes the video from Amara so the team stops working on it.
+// Videos with complete subtitles are no longer cancellable.
+func (c *AmaraProvider) CancelJob(ctx context.Context, job *database.Job) (bool, error) {
+	providerID := job.GetProviderID()
+	if providerID == "" {
+		return false, ErrInvalidProviderID
+	}
+	client, token, err := c.clientFor(ctx, job)
+	if err != nil {
+		return false, err
+	}
+	language := job.Language
+	if language == "" {
+		language = "en"
+	}
+	var lang *amara.Language
+	err = withContext(ctx, func() (err error) {
+		lang, err = client.GetLanguage(providerID, language)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	if lang.SubtitlesComplete {
+		return false, errors.New("job is not cancellable")
+	}
+
+	return true, deleteAmaraVideo(ctx, client, token, providerID)
+}
+
+// deleteAmaraVideo removes a video and its subtitle requests from Amara
+func deleteAmaraVideo(ctx context.Context, client *amara.Client, token, videoID string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, fmt.Sprintf("%s/videos/%s/", amaraAPIURL, videoID), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-api-key", token)
+	req.Header.Set("X-API-FUTURE", "20190619")
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("could not delete amara video %s: status %d", videoID, res.StatusCode)
+	}
+	return nil
 }
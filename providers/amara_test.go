@@ -0,0 +1,174 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nytimes/video-captions-api/config"
+	"github.com/nytimes/video-captions-api/database"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAmaraAPI is an in-memory stand-in for the Amara videos API
+type fakeAmaraAPI struct {
+	mtx      sync.Mutex
+	complete bool
+	deleted  []string
+	apiKeys  []string
+}
+
+func (f *fakeAmaraAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.apiKeys = append(f.apiKeys, r.Header.Get("X-api-key"))
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/videos/abc/languages/en/":
+		if f.complete {
+			w.Write([]byte(`{"language_code": "en", "subtitles_complete": true}`))
+			return
+		}
+		w.Write([]byte(`{"language_code": "en", "subtitles_complete": false}`))
+	case r.Method == http.MethodGet && r.URL.Path == "/api/videos/abc/languages/en/subtitles/":
+		w.Write([]byte(`{"version_number": 2}`))
+	case r.Method == http.MethodDelete && r.URL.Path == "/api/videos/abc/":
+		f.deleted = append(f.deleted, "abc")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestAmaraProvider() (*AmaraProvider, *fakeAmaraAPI, *httptest.Server) {
+	api := &fakeAmaraAPI{}
+	server := httptest.NewServer(api)
+	target, _ := url.Parse(server.URL)
+	cfg := &AmaraConfig{Username: "user", Team: "team", Token: "token"}
+	provider := NewAmaraProvider(cfg, &config.CaptionsServiceConfig{Logger: log.New()}).(*AmaraProvider)
+	provider.EmbedHTTPClient(&http.Client{Transport: rewriteTransport{target}})
+	provider.MaxRetries = 1
+	return provider, api, server
+}
+
+func newAmaraJob(providerID string) *database.Job {
+	return &database.Job{
+		ID:             "123",
+		MediaURL:       "http://vp.nyt.com/video.mp4",
+		Provider:       "amara",
+		Language:       "en",
+		ProviderParams: map[string]string{"ProviderID": providerID},
+	}
+}
+
+func TestAmaraGetProviderJob(t *testing.T) {
+	assert := assert.New(t)
+	provider, api, server := newTestAmaraProvider()
+	defer server.Close()
+
+	providerJob, err := provider.GetProviderJob(context.Background(), newAmaraJob("abc"))
+	assert.Nil(err)
+	assert.Equal(database.StatusInReview, providerJob.Status)
+	assert.Equal("subtitles_incomplete", providerJob.RawStatus)
+	assert.Equal("Version 2", providerJob.Details)
+
+	api.complete = true
+	providerJob, err = provider.GetProviderJob(context.Background(), newAmaraJob("abc"))
+	assert.Nil(err)
+	assert.Equal(database.StatusDelivered, providerJob.Status)
+	assert.Equal("subtitles_complete", providerJob.RawStatus)
+}
+
+func TestAmaraCancelJob(t *testing.T) {
+	assert := assert.New(t)
+	provider, api, server := newTestAmaraProvider()
+	defer server.Close()
+
+	cancelled, err := provider.CancelJob(context.Background(), newAmaraJob("abc"))
+	assert.Nil(err)
+	assert.True(cancelled)
+	assert.Equal([]string{"abc"}, api.deleted)
+	assert.Equal([]string{"token", "token"}, api.apiKeys)
+}
+
+func TestAmaraCancelJobComplete(t *testing.T) {
+	assert := assert.New(t)
+	provider, api, server := newTestAmaraProvider()
+	defer server.Close()
+	api.complete = true
+
+	cancelled, err := provider.CancelJob(context.Background(), newAmaraJob("abc"))
+	assert.EqualError(err, "job is not cancellable")
+	assert.False(cancelled)
+	assert.Empty(api.deleted)
+}
+
+func TestAmaraCancelJobErrors(t *testing.T) {
+	assert := assert.New(t)
+	provider, _, server := newTestAmaraProvider()
+	defer server.Close()
+
+	cancelled, err := provider.CancelJob(context.Background(), newAmaraJob(""))
+	assert.Equal(ErrInvalidProviderID, err)
+	assert.False(cancelled)
+
+	cancelled, err = provider.CancelJob(context.Background(), newAmaraJob("missing"))
+	assert.NotNil(err)
+	assert.False(cancelled)
+}
+
+func TestAmaraCancelJobContext(t *testing.T) {
+	assert := assert.New(t)
+	api := &fakeAmaraAPI{}
+	release := make(chan struct{})
+	aborted := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+				close(aborted)
+			}
+			return
+		}
+		api.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	defer close(release)
+	target, _ := url.Parse(server.URL)
+	cfg := &AmaraConfig{Username: "user", Team: "team", Token: "token"}
+	provider := NewAmaraProvider(cfg, &config.CaptionsServiceConfig{Logger: log.New()}).(*AmaraProvider)
+	provider.EmbedHTTPClient(&http.Client{Transport: rewriteTransport{target}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	cancelled, err := provider.CancelJob(ctx, newAmaraJob("abc"))
+	assert.True(cancelled)
+	assert.True(errors.Is(err, context.DeadlineExceeded))
+	// the DELETE request itself is cancelled
+	select {
+	case <-aborted:
+	case <-time.After(5 * time.Second):
+		t.Error("DELETE request wasn't cancelled")
+	}
+}
+
+func TestAmaraEstimate(t *testing.T) {
+	assert := assert.New(t)
+	cfg := &AmaraConfig{RatePerMinute: 2, Currency: "EUR", Turnaround: 72 * time.Hour}
+	provider := NewAmaraProvider(cfg, &config.CaptionsServiceConfig{Logger: log.New()}).(*AmaraProvider)
+
+	estimate, err := provider.Estimate(EstimateRequest{Duration: 2*time.Minute + 30*time.Second})
+	assert.Nil(err)
+	assert.Equal(&Estimate{Cost: 5, Currency: "EUR", Turnaround: 72 * time.Hour, Details: "2.00 EUR per minute"}, estimate)
+
+	provider.config.RatePerMinute = 0
+	_, err = provider.Estimate(EstimateRequest{Duration: time.Minute})
+	assert.EqualError(err, "amara rates are not configured")
+}
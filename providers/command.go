@@ -0,0 +1,305 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/nytimes/video-captions-api/config"
+	"github.com/nytimes/video-captions-api/database"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	commandProviderName string = "command"
+	commandStatusFile   string = "status.json"
+)
+
+// CommandProvider runs a local executable to generate captions for media that
+// cannot be sent to an outside vendor. The executable is called with the media
+// file or URL, the language code and an output directory where it must write
+// captions.vtt and/or captions.srt:
+//
+//	<path> [args...] <media> <language> <output dir>
+//
+// The same values are available to it as CAPTIONS_MEDIA, CAPTIONS_LANGUAGE,
+// CAPTIONS_OUTPUT_DIR and CAPTIONS_FORMATS environment variables. The media must
+// be an absolute URL or path, so it can't be mistaken for an option.
+//
+// The command runs in its own process group, which is killed on cancellation or
+// timeout. Running commands are only tracked by the instance that started them:
+// after a restart, or when polled from another instance, a job whose command
+// hadn't exited is reported as an error.
+type CommandProvider struct {
+	logger *log.Logger
+	config CommandConfig
+
+	mtx  sync.Mutex
+	runs map[string]*commandRun
+}
+
+// CommandConfig holds the command provider config
+type CommandConfig struct {
+	Path    string        `envconfig:"COMMAND_PROVIDER_PATH"`
+	Args    []string      `envconfig:"COMMAND_PROVIDER_ARGS"`
+	WorkDir string        `envconfig:"COMMAND_PROVIDER_WORK_DIR" default:"/tmp/captions-command"`
+	Timeout time.Duration `envconfig:"COMMAND_PROVIDER_TIMEOUT" default:"2h"`
+	// ExitStatuses maps exit codes other than 0 to job statuses, e.g. "3:in review".
+	// Unmapped non-zero exit codes, or codes mapped to unknown statuses, are
+	// reported as errors.
+	ExitStatuses map[int]database.JobStatus `envconfig:"COMMAND_PROVIDER_EXIT_STATUSES"`
+}
+
+// commandRun is a running command
+type commandRun struct {
+	cancel    context.CancelFunc
+	done      chan struct{}
+	cancelled bool
+}
+
+// commandResult is persisted in the job's work directory when the command exits
+type commandResult struct {
+	ExitCode  int       `json:"exit_code"`
+	Cancelled bool      `json:"cancelled"`
+	Stderr    string    `json:"stderr"`
+	EndedAt   time.Time `json:"ended_at"`
+}
+
+// NewCommandProvider creates a CommandProvider
+func NewCommandProvider(cfg *CommandConfig, svcCfg *config.CaptionsServiceConfig) Provider {
+	for code, status := range cfg.ExitStatuses {
+		if !status.Known() {
+			svcCfg.Logger.Warnf("Command provider exit code %d maps to unknown job status %q, it is reported as an error", code, status)
+		}
+	}
+	return &CommandProvider{
+		logger: svcCfg.Logger,
+		config: *cfg,
+		runs:   make(map[string]*commandRun),
+	}
+}
+
+// LoadCommandConfigFromEnv loads the command provider config from environment
+func LoadCommandConfigFromEnv() CommandConfig {
+	var providerConfig CommandConfig
+	envconfig.Process("", &providerConfig)
+	return providerConfig
+}
+
+// GetName returns provider name
+func (c *CommandProvider) GetName() string {
+	return commandProviderName
+}
+
+// GetCapabilities returns the output formats supported by the command provider
+func (c *CommandProvider) GetCapabilities() Capabilities {
+	return Capabilities{
+		Name:        commandProviderName,
+		OutputTypes: []string{"vtt", "srt"},
+		Cancellable: true,
+	}
+}
+
+// DispatchJob starts the command in the background, the job ID is used as provider ID
+func (c *CommandProvider) DispatchJob(_ context.Context, job *database.Job) error {
+	if c.config.Path == "" {
+		return errors.New("command provider is not configured")
+	}
+	if job.MediaURL == "" {
+		return errors.New("command provider requires a media_url")
+	}
+	if err := validateCommandMedia(job.MediaURL); err != nil {
+		return err
+	}
+
+	dir := c.jobDir(job.ID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("could not create work directory: %v", err)
+	}
+
+	formats := make([]string, len(job.Outputs))
+	for i, output := range job.Outputs {
+		formats[i] = output.Type
+	}
+
+	runCtx, cancel := context.WithTimeout(context.Background(), c.config.Timeout)
+	args := append(append([]string{}, c.config.Args...), job.MediaURL, job.Language, dir)
+	// exec.CommandContext would only kill the command, not the processes it
+	// started, which keep its stderr open and Wait blocked
+	cmd := exec.Command(c.config.Path, args...)
+	setProcessGroup(cmd)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"CAPTIONS_MEDIA="+job.MediaURL,
+		"CAPTIONS_LANGUAGE="+job.Language,
+		"CAPTIONS_OUTPUT_DIR="+dir,
+		"CAPTIONS_FORMATS="+strings.Join(formats, ","),
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return fmt.Errorf("could not start command: %v", err)
+	}
+
+	run := &commandRun{cancel: cancel, done: make(chan struct{})}
+	c.mtx.Lock()
+	c.runs[job.ID] = run
+	c.mtx.Unlock()
+
+	jobLogger := c.logger.WithFields(log.Fields{"JobID": job.ID, "Provider": commandProviderName})
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-runCtx.Done():
+			if err := killProcessGroup(cmd); err != nil {
+				jobLogger.WithError(err).Warn("Could not kill command")
+			}
+		case <-exited:
+		}
+	}()
+	go func() {
+		defer close(run.done)
+		defer cancel()
+		err := cmd.Wait()
+		close(exited)
+		if err != nil {
+			jobLogger.WithError(err).Warn("Command failed")
+		}
+
+		c.mtx.Lock()
+		result := commandResult{
+			ExitCode:  cmd.ProcessState.ExitCode(),
+			Cancelled: run.cancelled,
+			Stderr:    stderr.String(),
+			EndedAt:   time.Now(),
+		}
+		c.mtx.Unlock()
+
+		// the result is stored before the run is forgotten so the job is never
+		// seen as neither running nor finished
+		if err := c.writeResult(dir, result); err != nil {
+			jobLogger.WithError(err).Error("Could not store command result")
+		}
+		c.mtx.Lock()
+		delete(c.runs, job.ID)
+		c.mtx.Unlock()
+	}()
+
+	job.ProviderParams["ProviderID"] = job.ID
+	return nil
+}
+
+// GetProviderJob maps the command state and exit code to a job status
+func (c *CommandProvider) GetProviderJob(_ context.Context, job *database.Job) (*database.ProviderJob, error) {
+	providerID := job.GetProviderID()
+	c.mtx.Lock()
+	_, running := c.runs[providerID]
+	c.mtx.Unlock()
+	if running {
+		return &database.ProviderJob{ID: providerID, Status: database.StatusProcessing, Cancellable: true}, nil
+	}
+
+	result, err := c.readResult(c.jobDir(providerID))
+	if os.IsNotExist(err) {
+		return &database.ProviderJob{ID: providerID, Status: database.StatusError, Details: "command is no longer running"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	providerJob := &database.ProviderJob{
+		ID:     providerID,
+		Params: map[string]string{"ExitCode": fmt.Sprint(result.ExitCode)},
+	}
+	switch {
+	case result.Cancelled:
+		providerJob.Status = database.StatusCancelled
+	case result.ExitCode == 0:
+		providerJob.Status = database.StatusComplete
+	default:
+		providerJob.Status = database.StatusError
+		if status := c.config.ExitStatuses[result.ExitCode]; status.Known() {
+			providerJob.Status = status
+		}
+		providerJob.Details = strings.TrimSpace(result.Stderr)
+	}
+	return providerJob, nil
+}
+
+// Download returns the captions file written by the command
+func (c *CommandProvider) Download(_ context.Context, job *database.Job, captionsType string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(c.jobDir(job.GetProviderID()), "captions."+captionsType))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("command did not produce %s captions", captionsType)
+	}
+	return data, err
+}
+
+// CancelJob kills the command if it is still running and waits for it to exit
+// until ctx is done
+func (c *CommandProvider) CancelJob(ctx context.Context, job *database.Job) (bool, error) {
+	c.mtx.Lock()
+	run, ok := c.runs[job.GetProviderID()]
+	if ok {
+		run.cancelled = true
+		run.cancel()
+	}
+	c.mtx.Unlock()
+	if !ok {
+		return false, errors.New("job is not cancellable")
+	}
+	select {
+	case <-run.done:
+		return true, nil
+	case <-ctx.Done():
+		return true, ctx.Err()
+	}
+}
+
+// validateCommandMedia checks that the media is an absolute URL or path
+func validateCommandMedia(media string) error {
+	if filepath.IsAbs(media) {
+		return nil
+	}
+	if u, err := url.Parse(media); err == nil && u.Scheme != "" && (u.Host != "" || u.Path != "") {
+		return nil
+	}
+	return fmt.Errorf("command provider requires an absolute media URL or path, got %q", media)
+}
+
+func (c *CommandProvider) jobDir(providerID string) string {
+	return filepath.Join(c.config.WorkDir, filepath.Base(providerID))
+}
+
+func (c *CommandProvider) writeResult(dir string, result commandResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, commandStatusFile), data, 0644)
+}
+
+func (c *CommandProvider) readResult(dir string) (*commandResult, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, commandStatusFile))
+	if err != nil {
+		return nil, err
+	}
+	var result commandResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
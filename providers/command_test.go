@@ -0,0 +1,183 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nytimes/video-captions-api/config"
+	"github.com/nytimes/video-captions-api/database"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCommandProvider(t *testing.T) (*CommandProvider, string) {
+	workDir, err := ioutil.TempDir("", "captions-command")
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, _ := filepath.Abs("testdata/fake-asr.sh")
+	provider := NewCommandProvider(&CommandConfig{
+		Path:         script,
+		WorkDir:      workDir,
+		Timeout:      time.Minute,
+		ExitStatuses: map[int]database.JobStatus{3: database.StatusInReview, 4: "reviewing"},
+	}, &config.CaptionsServiceConfig{Logger: log.New()})
+	return provider.(*CommandProvider), workDir
+}
+
+func newCommandJob(media string) *database.Job {
+	return &database.Job{
+		ID:             "123",
+		MediaURL:       media,
+		Provider:       "command",
+		Language:       "en",
+		ProviderParams: map[string]string{},
+		Outputs:        []database.JobOutput{{Type: "vtt"}},
+	}
+}
+
+// waitForCommand blocks until the command started for the job exits
+func waitForCommand(provider *CommandProvider, job *database.Job) {
+	provider.mtx.Lock()
+	run, ok := provider.runs[job.ID]
+	provider.mtx.Unlock()
+	if ok {
+		<-run.done
+	}
+}
+
+func TestCommandDispatchJob(t *testing.T) {
+	assert := assert.New(t)
+	provider, workDir := newTestCommandProvider(t)
+	defer os.RemoveAll(workDir)
+	job := newCommandJob("/media/video.mp4")
+
+	err := provider.DispatchJob(context.Background(), job)
+	assert.Nil(err)
+	assert.Equal("123", job.GetProviderID())
+	waitForCommand(provider, job)
+
+	providerJob, err := provider.GetProviderJob(context.Background(), job)
+	assert.Nil(err)
+	assert.Equal(database.StatusComplete, providerJob.Status)
+	assert.Equal("0", providerJob.Params["ExitCode"])
+
+	vtt, err := provider.Download(context.Background(), job, "vtt")
+	assert.Nil(err)
+	assert.Equal("WEBVTT\n\n00:00:00.000 --> 00:00:01.000\nHello (en)\n", string(vtt))
+
+	_, err = provider.Download(context.Background(), job, "dfxp")
+	assert.EqualError(err, "command did not produce dfxp captions")
+}
+
+func TestCommandDispatchJobInvalidMedia(t *testing.T) {
+	provider, workDir := newTestCommandProvider(t)
+	defer os.RemoveAll(workDir)
+	for _, media := range []string{"--help", "video.mp4", "-o/tmp/x"} {
+		err := provider.DispatchJob(context.Background(), newCommandJob(media))
+		assert.EqualError(t, err, fmt.Sprintf("command provider requires an absolute media URL or path, got %q", media))
+	}
+	assert.Nil(t, provider.DispatchJob(context.Background(), newCommandJob("https://vp.nyt.com/video.mp4")))
+}
+
+func TestCommandDispatchJobNotConfigured(t *testing.T) {
+	provider, workDir := newTestCommandProvider(t)
+	defer os.RemoveAll(workDir)
+	provider.config.Path = ""
+	err := provider.DispatchJob(context.Background(), newCommandJob("/media/video.mp4"))
+	assert.EqualError(t, err, "command provider is not configured")
+}
+
+func TestCommandGetProviderJob(t *testing.T) {
+	tests := []struct {
+		name     string
+		media    string
+		status   database.JobStatus
+		exitCode string
+		details  string
+	}{
+		{"Failed command", "/media/fail.mp4", "error", "2", "could not decode /media/fail.mp4"},
+		{"Mapped exit code", "/media/review.mp4", "in review", "3", ""},
+		{"Exit code mapped to an unknown status", "/media/unmapped.mp4", "error", "4", ""},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			provider, workDir := newTestCommandProvider(t)
+			defer os.RemoveAll(workDir)
+			job := newCommandJob(test.media)
+
+			assert.Nil(provider.DispatchJob(context.Background(), job))
+			waitForCommand(provider, job)
+
+			providerJob, err := provider.GetProviderJob(context.Background(), job)
+			assert.Nil(err)
+			assert.Equal(test.status, providerJob.Status)
+			assert.Equal(test.exitCode, providerJob.Params["ExitCode"])
+			assert.Equal(test.details, providerJob.Details)
+		})
+	}
+}
+
+func TestCommandGetProviderJobLostRun(t *testing.T) {
+	provider, workDir := newTestCommandProvider(t)
+	defer os.RemoveAll(workDir)
+	job := newCommandJob("/media/video.mp4")
+	job.ProviderParams["ProviderID"] = "456"
+
+	providerJob, err := provider.GetProviderJob(context.Background(), job)
+	assert.Nil(t, err)
+	assert.Equal(t, database.StatusError, providerJob.Status)
+	assert.Equal(t, "command is no longer running", providerJob.Details)
+}
+
+func TestCommandCancelJob(t *testing.T) {
+	assert := assert.New(t)
+	provider, workDir := newTestCommandProvider(t)
+	defer os.RemoveAll(workDir)
+	job := newCommandJob("/media/slow.mp4")
+	assert.Nil(provider.DispatchJob(context.Background(), job))
+
+	providerJob, err := provider.GetProviderJob(context.Background(), job)
+	assert.Nil(err)
+	assert.Equal(database.StatusProcessing, providerJob.Status)
+	assert.True(providerJob.Cancellable)
+
+	cancelled, err := provider.CancelJob(context.Background(), job)
+	assert.True(cancelled)
+	assert.Nil(err)
+
+	providerJob, err = provider.GetProviderJob(context.Background(), job)
+	assert.Nil(err)
+	assert.Equal(database.StatusCancelled, providerJob.Status)
+
+	cancelled, err = provider.CancelJob(context.Background(), job)
+	assert.False(cancelled)
+	assert.EqualError(err, "job is not cancellable")
+}
+
+func TestCommandCancelJobKillsChildren(t *testing.T) {
+	assert := assert.New(t)
+	provider, workDir := newTestCommandProvider(t)
+	defer os.RemoveAll(workDir)
+	job := newCommandJob("/media/wrapper.mp4")
+	assert.Nil(provider.DispatchJob(context.Background(), job))
+	// let the wrapper start its child
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cancelled, err := provider.CancelJob(ctx, job)
+	assert.True(cancelled)
+	assert.Nil(err)
+
+	providerJob, err := provider.GetProviderJob(context.Background(), job)
+	assert.Nil(err)
+	assert.Equal(database.StatusCancelled, providerJob.Status)
+}
@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package providers
+
+import "os/exec"
+
+// setProcessGroup does nothing, Windows has no process groups
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup only kills the command, the processes it started keep running
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
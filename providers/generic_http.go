@@ -0,0 +1,416 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/nytimes/video-captions-api/config"
+	"github.com/nytimes/video-captions-api/credentials"
+	"github.com/nytimes/video-captions-api/database"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// GenericHTTPConfig holds the location of the generic HTTP providers config file
+type GenericHTTPConfig struct {
+	ConfigFile string `envconfig:"GENERIC_HTTP_CONFIG"`
+}
+
+// GenericHTTPFile is the layout of the generic HTTP providers config file
+type GenericHTTPFile struct {
+	Providers []GenericHTTPVendor `yaml:"providers"`
+}
+
+// GenericHTTPVendor describes how to talk to a vendor API. URLs and bodies are
+// text/template templates executed with the job (.Job), the vendor job ID
+// (.ProviderID), the vendor caption format (.Format) and the job provider params
+// (.Params). The base URL and header values are expanded with environment
+// variables so secrets can be kept out of the file, ${CREDENTIAL} in a header
+// value is replaced by the vendor credential of the job tenant and job type, or
+// by the CREDENTIAL_<VENDOR> variable.
+type GenericHTTPVendor struct {
+	Name             string            `yaml:"name"`
+	BaseURL          string            `yaml:"base_url"`
+	Headers          map[string]string `yaml:"headers"`
+	OutputTypes      []string          `yaml:"output_types"`
+	Languages        []string          `yaml:"languages"`
+	AdditionalParams bool              `yaml:"additional_params"`
+	Dispatch         GenericHTTPCall   `yaml:"dispatch"`
+	Status           GenericHTTPCall   `yaml:"status"`
+	Download         GenericHTTPCall   `yaml:"download"`
+	Cancel           *GenericHTTPCall  `yaml:"cancel"`
+	// StatusMap maps vendor statuses to job statuses, unmapped statuses are
+	// only used as is when they are job statuses
+	StatusMap map[string]database.JobStatus `yaml:"status_map"`
+	// CancellableStatuses lists the vendor statuses a job can be cancelled in
+	CancellableStatuses []string `yaml:"cancellable_statuses"`
+}
+
+// GenericHTTPCall is a single vendor API call
+type GenericHTTPCall struct {
+	Method string `yaml:"method"`
+	URL    string `yaml:"url"`
+	Body   string `yaml:"body"`
+	// IDPath, StatusPath and DetailsPath are dot separated paths into the JSON
+	// response, e.g. "data.jobs.0.id"
+	IDPath      string `yaml:"id_path"`
+	StatusPath  string `yaml:"status_path"`
+	DetailsPath string `yaml:"details_path"`
+	// Formats maps output types to the vendor's format names
+	Formats map[string]string `yaml:"formats"`
+
+	url  *template.Template
+	body *template.Template
+}
+
+// genericHTTPData is the data URL and body templates are executed with
+type genericHTTPData struct {
+	Job        *database.Job
+	ProviderID string
+	Format     string
+	Params     map[string]string
+}
+
+// genericHTTPError is returned for non 2xx responses from the vendor API
+type genericHTTPError struct {
+	Provider   string
+	StatusCode int
+	Body       string
+}
+
+// Error implements the error interface
+func (e genericHTTPError) Error() string {
+	return fmt.Sprintf("%s: status %d: %s", e.Provider, e.StatusCode, e.Body)
+}
+
+// HTTPStatus returns the status of the response
+func (e genericHTTPError) HTTPStatus() int {
+	return e.StatusCode
+}
+
+var genericHTTPFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"query": url.QueryEscape,
+	"path":  url.PathEscape,
+}
+
+// GenericHTTPProvider is a Provider driven by a GenericHTTPVendor description
+type GenericHTTPProvider struct {
+	httpClient  *http.Client
+	logger      *log.Logger
+	vendor      GenericHTTPVendor
+	credentials credentials.Store
+}
+
+// LoadGenericHTTPConfigFromEnv loads the generic HTTP providers config file location from environment
+func LoadGenericHTTPConfigFromEnv() GenericHTTPConfig {
+	var providerConfig GenericHTTPConfig
+	envconfig.Process("", &providerConfig)
+	return providerConfig
+}
+
+// LoadGenericHTTPProviders reads the config file and creates a provider for
+// every vendor in it. No providers are returned when no file is configured.
+func LoadGenericHTTPProviders(cfg *GenericHTTPConfig, svcCfg *config.CaptionsServiceConfig) ([]Provider, error) {
+	if cfg.ConfigFile == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(cfg.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+	var file GenericHTTPFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid generic HTTP config: %v", err)
+	}
+
+	var providers []Provider
+	for _, vendor := range file.Providers {
+		provider, err := NewGenericHTTPProvider(vendor, svcCfg)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+// NewGenericHTTPProvider validates the vendor description and creates a GenericHTTPProvider
+func NewGenericHTTPProvider(vendor GenericHTTPVendor, svcCfg *config.CaptionsServiceConfig) (Provider, error) {
+	if vendor.Name == "" {
+		return nil, errors.New("generic HTTP provider requires a name")
+	}
+	if vendor.Dispatch.IDPath == "" {
+		return nil, fmt.Errorf("%s: dispatch requires an id_path", vendor.Name)
+	}
+	if vendor.Status.StatusPath == "" {
+		return nil, fmt.Errorf("%s: status requires a status_path", vendor.Name)
+	}
+	for vendorStatus, status := range vendor.StatusMap {
+		if !status.Known() {
+			return nil, fmt.Errorf("%s: status_map maps %q to unknown job status %q", vendor.Name, vendorStatus, status)
+		}
+	}
+
+	calls := map[string]*GenericHTTPCall{
+		"dispatch": &vendor.Dispatch,
+		"status":   &vendor.Status,
+		"download": &vendor.Download,
+	}
+	if vendor.Cancel != nil {
+		cancel := *vendor.Cancel
+		vendor.Cancel = &cancel
+		calls["cancel"] = vendor.Cancel
+	}
+	for name, call := range calls {
+		if err := call.parse(vendor.Name + "." + name); err != nil {
+			return nil, fmt.Errorf("%s: invalid %s call: %v", vendor.Name, name, err)
+		}
+	}
+
+	return &GenericHTTPProvider{
+		&http.Client{},
+		svcCfg.Logger,
+		vendor,
+		svcCfg.Credentials,
+	}, nil
+}
+
+func (call *GenericHTTPCall) parse(name string) error {
+	if call.URL == "" {
+		return errors.New("url is required")
+	}
+	if call.Method == "" {
+		call.Method = http.MethodGet
+	}
+	var err error
+	call.url, err = template.New(name + ".url").Funcs(genericHTTPFuncs).Parse(call.URL)
+	if err != nil {
+		return err
+	}
+	if call.Body != "" {
+		call.body, err = template.New(name + ".body").Funcs(genericHTTPFuncs).Parse(call.Body)
+	}
+	return err
+}
+
+// GetName returns provider name
+func (c *GenericHTTPProvider) GetName() string {
+	return c.vendor.Name
+}
+
+// GetCapabilities returns the output formats and languages listed in the vendor description
+func (c *GenericHTTPProvider) GetCapabilities() Capabilities {
+	return Capabilities{
+		Name:             c.vendor.Name,
+		OutputTypes:      c.vendor.OutputTypes,
+		Languages:        c.vendor.Languages,
+		Cancellable:      c.vendor.Cancel != nil,
+		AdditionalParams: c.vendor.AdditionalParams,
+	}
+}
+
+// DispatchJob submits the job to the vendor and stores the ID found at the dispatch id_path
+func (c *GenericHTTPProvider) DispatchJob(ctx context.Context, job *database.Job) error {
+	jobLogger := c.logger.WithFields(log.Fields{"JobID": job.ID, "Provider": job.Provider})
+	result, err := c.callJSON(ctx, &c.vendor.Dispatch, c.templateData(job, ""))
+	if err != nil {
+		jobLogger.WithError(err).Error("Failed to submit job")
+		return err
+	}
+	id, ok := extractJSONPath(result, c.vendor.Dispatch.IDPath)
+	if !ok || id == "" {
+		return fmt.Errorf("%s: no job ID at %q", c.vendor.Name, c.vendor.Dispatch.IDPath)
+	}
+
+	job.ProviderParams["ProviderID"] = id
+	return nil
+}
+
+// GetProviderJob returns the vendor job status mapped through the status_map
+func (c *GenericHTTPProvider) GetProviderJob(ctx context.Context, job *database.Job) (*database.ProviderJob, error) {
+	result, err := c.callJSON(ctx, &c.vendor.Status, c.templateData(job, ""))
+	if err != nil {
+		return nil, err
+	}
+	vendorStatus, ok := extractJSONPath(result, c.vendor.Status.StatusPath)
+	if !ok {
+		return nil, fmt.Errorf("%s: no job status at %q", c.vendor.Name, c.vendor.Status.StatusPath)
+	}
+
+	status, ok := c.vendor.StatusMap[vendorStatus]
+	if !ok && database.JobStatus(vendorStatus).Known() {
+		status = database.JobStatus(vendorStatus)
+	}
+	providerJob := &database.ProviderJob{
+		ID:          job.GetProviderID(),
+		Status:      status,
+		RawStatus:   vendorStatus,
+		Cancellable: c.vendor.Cancel != nil && contains(c.vendor.CancellableStatuses, vendorStatus),
+	}
+	providerJob.Payload, _ = json.Marshal(result)
+	if c.vendor.Status.DetailsPath != "" {
+		providerJob.Details, _ = extractJSONPath(result, c.vendor.Status.DetailsPath)
+	}
+	return providerJob, nil
+}
+
+// Download downloads the job captions in the given format
+func (c *GenericHTTPProvider) Download(ctx context.Context, job *database.Job, captionsType string) ([]byte, error) {
+	format := captionsType
+	if c.vendor.Download.Formats != nil {
+		var ok bool
+		if format, ok = c.vendor.Download.Formats[captionsType]; !ok {
+			return nil, fmt.Errorf("unsupported caption format: %s", captionsType)
+		}
+	}
+	return c.call(ctx, &c.vendor.Download, c.templateData(job, format))
+}
+
+// CancelJob cancels the job on the vendor, if a cancel call is configured
+func (c *GenericHTTPProvider) CancelJob(ctx context.Context, job *database.Job) (bool, error) {
+	if c.vendor.Cancel == nil {
+		return false, errors.New("job is not cancellable")
+	}
+	if _, err := c.call(ctx, c.vendor.Cancel, c.templateData(job, "")); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (c *GenericHTTPProvider) templateData(job *database.Job, format string) genericHTTPData {
+	return genericHTTPData{
+		Job:        job,
+		ProviderID: job.GetProviderID(),
+		Format:     format,
+		Params:     job.ProviderParams,
+	}
+}
+
+func (c *GenericHTTPProvider) callJSON(ctx context.Context, call *GenericHTTPCall, data genericHTTPData) (interface{}, error) {
+	body, err := c.call(ctx, call, data)
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("%s: invalid JSON response: %v", c.vendor.Name, err)
+	}
+	return result, nil
+}
+
+func (c *GenericHTTPProvider) call(ctx context.Context, call *GenericHTTPCall, data genericHTTPData) ([]byte, error) {
+	var path bytes.Buffer
+	if err := call.url.Execute(&path, data); err != nil {
+		return nil, err
+	}
+	var body io.Reader
+	if call.body != nil {
+		var buf bytes.Buffer
+		if err := call.body.Execute(&buf, data); err != nil {
+			return nil, err
+		}
+		body = &buf
+	}
+
+	target := path.String()
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		target = strings.TrimSuffix(os.ExpandEnv(c.vendor.BaseURL), "/") + target
+	}
+	req, err := http.NewRequestWithContext(ctx, call.Method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.vendor.Headers {
+		value, err := c.expandHeader(ctx, data.Job, v)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(k, value)
+	}
+	if body != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, genericHTTPError{c.vendor.Name, res.StatusCode, string(resBody)}
+	}
+	return resBody, nil
+}
+
+// expandHeader expands environment variables in a header value, ${CREDENTIAL}
+// is looked up in the credential store, falling back to the CREDENTIAL_<VENDOR>
+// environment variable
+func (c *GenericHTTPProvider) expandHeader(ctx context.Context, job *database.Job, value string) (string, error) {
+	var err error
+	expanded := os.Expand(value, func(name string) string {
+		if name != "CREDENTIAL" {
+			return os.Getenv(name)
+		}
+		fallback := os.Getenv(credentials.NewEnvStore(name).VariableName(credentials.Key{Provider: c.vendor.Name}))
+		var credential string
+		credential, err = lookupCredential(ctx, c.credentials, c.vendor.Name, job, "", fallback)
+		return credential
+	})
+	return expanded, err
+}
+
+// extractJSONPath walks a decoded JSON value following a dot separated path,
+// numeric segments index into arrays. Scalars are returned as strings.
+func extractJSONPath(value interface{}, path string) (string, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if value, ok = v[key]; !ok {
+				return "", false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return "", false
+			}
+			value = v[i]
+		default:
+			return "", false
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case nil:
+		return "", false
+	default:
+		data, _ := json.Marshal(v)
+		return string(data), true
+	}
+}
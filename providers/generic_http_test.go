@@ -0,0 +1,326 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nytimes/video-captions-api/config"
+	"github.com/nytimes/video-captions-api/credentials"
+	"github.com/nytimes/video-captions-api/database"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeVendorAPI is an in-memory vendor API matching testdata/generic-http.yaml
+type fakeVendorAPI struct {
+	mtx      sync.Mutex
+	states   map[string]string
+	requests []map[string]interface{}
+}
+
+func (f *fakeVendorAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if r.Header.Get("X-Api-Key") != "acme-key" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte("invalid api key"))
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/transcripts")
+	switch {
+	case r.Method == http.MethodPost && path == "":
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.requests = append(f.requests, body)
+		f.states["t-1"] = "queued"
+		fmt.Fprint(w, `{"data": {"transcript": {"id": "t-1"}}}`)
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/abort"):
+		id := strings.TrimSuffix(strings.TrimPrefix(path, "/"), "/abort")
+		if f.states[id] != "queued" {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte("transcript already started"))
+			return
+		}
+		f.states[id] = "aborted"
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/export"):
+		switch r.URL.Query().Get("format") {
+		case "webvtt":
+			w.Write([]byte("WEBVTT\n\n00:00:00.000 --> 00:00:01.000\nHello"))
+		case "subrip":
+			w.Write([]byte("1\n00:00:00,000 --> 00:00:01,000\nHello"))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	case r.Method == http.MethodGet:
+		state, ok := f.states[strings.TrimPrefix(path, "/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("transcript not found"))
+			return
+		}
+		if state == "failed" {
+			fmt.Fprint(w, `{"data": {"transcript": {"state": "failed", "errors": [{"message": "media unreachable"}]}}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"data": {"transcript": {"state": %q}}}`, state)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeVendorAPI) setState(id, state string) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.states[id] = state
+}
+
+func newTestGenericHTTPProvider(t *testing.T) (*GenericHTTPProvider, *fakeVendorAPI, *httptest.Server) {
+	api := &fakeVendorAPI{states: make(map[string]string)}
+	server := httptest.NewServer(api)
+	os.Setenv("ACME_BASE_URL", server.URL)
+	os.Setenv("ACME_API_KEY", "acme-key")
+
+	providers, err := LoadGenericHTTPProviders(
+		&GenericHTTPConfig{ConfigFile: "testdata/generic-http.yaml"},
+		&config.CaptionsServiceConfig{Logger: log.New()},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(providers) != 1 {
+		t.Fatalf("expected 1 provider, got %d", len(providers))
+	}
+	return providers[0].(*GenericHTTPProvider), api, server
+}
+
+func newGenericHTTPJob() *database.Job {
+	return &database.Job{
+		ID:             "123",
+		MediaURL:       "http://vp.nyt.com/video.mp4",
+		Provider:       "acme",
+		Language:       "es",
+		ProviderParams: map[string]string{"priority": "high"},
+	}
+}
+
+func TestLoadGenericHTTPProviders(t *testing.T) {
+	assert := assert.New(t)
+	provider, _, server := newTestGenericHTTPProvider(t)
+	defer server.Close()
+
+	assert.Equal("acme", provider.GetName())
+	assert.Equal(Capabilities{
+		Name:             "acme",
+		OutputTypes:      []string{"vtt", "srt"},
+		Languages:        []string{"en", "es"},
+		Cancellable:      true,
+		AdditionalParams: true,
+	}, provider.GetCapabilities())
+
+	providers, err := LoadGenericHTTPProviders(&GenericHTTPConfig{}, &config.CaptionsServiceConfig{})
+	assert.Nil(err)
+	assert.Empty(providers)
+}
+
+func TestNewGenericHTTPProviderInvalid(t *testing.T) {
+	svcCfg := &config.CaptionsServiceConfig{Logger: log.New()}
+	valid := GenericHTTPVendor{
+		Name:     "acme",
+		Dispatch: GenericHTTPCall{Method: "POST", URL: "/jobs", IDPath: "id"},
+		Status:   GenericHTTPCall{URL: "/jobs/{{.ProviderID}}", StatusPath: "status"},
+		Download: GenericHTTPCall{URL: "/jobs/{{.ProviderID}}/captions"},
+	}
+	_, err := NewGenericHTTPProvider(valid, svcCfg)
+	assert.Nil(t, err)
+
+	noName := valid
+	noName.Name = ""
+	_, err = NewGenericHTTPProvider(noName, svcCfg)
+	assert.EqualError(t, err, "generic HTTP provider requires a name")
+
+	badTemplate := valid
+	badTemplate.Download.URL = "/jobs/{{.ProviderID"
+	_, err = NewGenericHTTPProvider(badTemplate, svcCfg)
+	assert.Contains(t, err.Error(), "acme: invalid download call")
+
+	noStatusPath := valid
+	noStatusPath.Status.StatusPath = ""
+	_, err = NewGenericHTTPProvider(noStatusPath, svcCfg)
+	assert.EqualError(t, err, "acme: status requires a status_path")
+
+	unknownStatus := valid
+	unknownStatus.StatusMap = map[string]database.JobStatus{"archived": "archived"}
+	_, err = NewGenericHTTPProvider(unknownStatus, svcCfg)
+	assert.EqualError(t, err, `acme: status_map maps "archived" to unknown job status "archived"`)
+}
+
+func TestGenericHTTPDispatchJob(t *testing.T) {
+	assert := assert.New(t)
+	provider, api, server := newTestGenericHTTPProvider(t)
+	defer server.Close()
+	job := newGenericHTTPJob()
+
+	err := provider.DispatchJob(context.Background(), job)
+	assert.Nil(err)
+	assert.Equal("t-1", job.GetProviderID())
+	assert.Len(api.requests, 1)
+	assert.Equal(map[string]interface{}{
+		"source":    map[string]interface{}{"url": "http://vp.nyt.com/video.mp4"},
+		"lang":      "es",
+		"reference": "123",
+		"priority":  "high",
+	}, api.requests[0])
+
+	os.Setenv("ACME_API_KEY", "wrong")
+	err = provider.DispatchJob(context.Background(), newGenericHTTPJob())
+	assert.EqualError(err, "acme: status 401: invalid api key")
+}
+
+func TestGenericHTTPGetProviderJob(t *testing.T) {
+	assert := assert.New(t)
+	provider, api, server := newTestGenericHTTPProvider(t)
+	defer server.Close()
+	job := newGenericHTTPJob()
+	provider.DispatchJob(context.Background(), job)
+
+	providerJob, err := provider.GetProviderJob(context.Background(), job)
+	assert.Nil(err)
+	assert.Equal(&database.ProviderJob{
+		ID:          "t-1",
+		Status:      database.StatusProcessing,
+		RawStatus:   "queued",
+		Payload:     []byte(`{"data":{"transcript":{"state":"queued"}}}`),
+		Cancellable: true,
+	}, providerJob)
+
+	api.setState("t-1", "failed")
+	providerJob, err = provider.GetProviderJob(context.Background(), job)
+	assert.Nil(err)
+	assert.Equal(database.StatusError, providerJob.Status)
+	assert.Equal("media unreachable", providerJob.Details)
+	assert.False(providerJob.Cancellable)
+
+	// vendor statuses that aren't mapped don't translate to a job status
+	api.setState("t-1", "archived")
+	providerJob, err = provider.GetProviderJob(context.Background(), job)
+	assert.Nil(err)
+	assert.Equal(database.JobStatus(""), providerJob.Status)
+	assert.Equal("archived", providerJob.RawStatus)
+
+	api.setState("t-1", "complete")
+	providerJob, err = provider.GetProviderJob(context.Background(), job)
+	assert.Nil(err)
+	assert.Equal(database.StatusComplete, providerJob.Status)
+
+	job.ProviderParams["ProviderID"] = "404"
+	_, err = provider.GetProviderJob(context.Background(), job)
+	assert.EqualError(err, "acme: status 404: transcript not found")
+}
+
+func TestGenericHTTPDownload(t *testing.T) {
+	assert := assert.New(t)
+	provider, _, server := newTestGenericHTTPProvider(t)
+	defer server.Close()
+	job := newGenericHTTPJob()
+	provider.DispatchJob(context.Background(), job)
+
+	vtt, err := provider.Download(context.Background(), job, "vtt")
+	assert.Nil(err)
+	assert.Equal("WEBVTT\n\n00:00:00.000 --> 00:00:01.000\nHello", string(vtt))
+
+	srt, err := provider.Download(context.Background(), job, "srt")
+	assert.Nil(err)
+	assert.Equal("1\n00:00:00,000 --> 00:00:01,000\nHello", string(srt))
+
+	_, err = provider.Download(context.Background(), job, "dfxp")
+	assert.EqualError(err, "unsupported caption format: dfxp")
+}
+
+func TestGenericHTTPCancelJob(t *testing.T) {
+	assert := assert.New(t)
+	provider, api, server := newTestGenericHTTPProvider(t)
+	defer server.Close()
+	job := newGenericHTTPJob()
+	provider.DispatchJob(context.Background(), job)
+
+	cancelled, err := provider.CancelJob(context.Background(), job)
+	assert.True(cancelled)
+	assert.Nil(err)
+
+	api.setState("t-1", "transcribing")
+	cancelled, err = provider.CancelJob(context.Background(), job)
+	assert.False(cancelled)
+	assert.EqualError(err, "acme: status 409: transcript already started")
+
+	provider.vendor.Cancel = nil
+	cancelled, err = provider.CancelJob(context.Background(), job)
+	assert.False(cancelled)
+	assert.EqualError(err, "job is not cancellable")
+}
+
+func TestGenericHTTPCredentialHeader(t *testing.T) {
+	assert := assert.New(t)
+	provider, _, server := newTestGenericHTTPProvider(t)
+	defer server.Close()
+	provider.vendor.Headers = map[string]string{"X-Api-Key": "${CREDENTIAL}"}
+	provider.credentials = credentials.NewFileStore("testdata/credentials.yaml")
+
+	job := newGenericHTTPJob()
+	job.JobType = "captions"
+	assert.Nil(provider.DispatchJob(context.Background(), job))
+
+	job = newGenericHTTPJob()
+	job.JobType = "transcript"
+	err := provider.DispatchJob(context.Background(), job)
+	assert.EqualError(err, "acme: status 401: invalid api key")
+}
+
+func TestGenericHTTPCredentialFallback(t *testing.T) {
+	assert := assert.New(t)
+	provider, _, server := newTestGenericHTTPProvider(t)
+	defer server.Close()
+	defer os.Unsetenv("CREDENTIAL")
+	defer os.Unsetenv("CREDENTIAL_ACME")
+	provider.vendor.Headers = map[string]string{"X-Api-Key": "${CREDENTIAL}"}
+
+	// the credential of another vendor isn't used
+	os.Setenv("CREDENTIAL", "acme-key")
+	err := provider.DispatchJob(context.Background(), newGenericHTTPJob())
+	assert.EqualError(err, "acme: status 401: invalid api key")
+
+	os.Setenv("CREDENTIAL_ACME", "acme-key")
+	assert.Nil(provider.DispatchJob(context.Background(), newGenericHTTPJob()))
+}
+
+func TestExtractJSONPath(t *testing.T) {
+	var value interface{}
+	json.Unmarshal([]byte(`{"a": {"b": [{"c": "x"}, {"c": 42}], "d": true, "e": null}}`), &value)
+	tests := []struct {
+		path  string
+		value string
+		ok    bool
+	}{
+		{"a.b.0.c", "x", true},
+		{"a.b.1.c", "42", true},
+		{"a.d", "true", true},
+		{"a.e", "", false},
+		{"a.b.2.c", "", false},
+		{"a.missing", "", false},
+		{"a.d.x", "", false},
+	}
+	for _, test := range tests {
+		got, ok := extractJSONPath(value, test.path)
+		assert.Equal(t, test.value, got, test.path)
+		assert.Equal(t, test.ok, ok, test.path)
+	}
+}
@@ -1,12 +1,236 @@
 package providers
 
-import "github.com/nytimes/video-captions-api/database"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nytimes/video-captions-api/credentials"
+	"github.com/nytimes/video-captions-api/database"
+)
+
+var (
+	// ErrProviderNotFound indicates that no provider is registered with a given name.
+	ErrProviderNotFound = errors.New("provider not found")
+
+	// ErrInvalidProviderID indicates that a callback doesn't identify a provider job.
+	ErrInvalidProviderID = errors.New("invalid Provider ID")
+
+	// ErrCaptionNotUpdatable indicates that a job status can't move to delivered,
+	// e.g. it was cancelled, so its caption can't be replaced.
+	ErrCaptionNotUpdatable = errors.New("caption of a cancelled or failed job cannot be updated")
+)
 
 // Provider is the interface that transcription/captions providers must implement
 type Provider interface {
-	DispatchJob(*database.Job) error
-	Download(*database.Job, string) ([]byte, error)
-	GetProviderJob(*database.Job) (*database.ProviderJob, error)
+	DispatchJob(context.Context, *database.Job) error
+	Download(context.Context, *database.Job, string) ([]byte, error)
+	GetProviderJob(context.Context, *database.Job) (*database.ProviderJob, error)
 	GetName() string
-	CancelJob(*database.Job) (bool, error)
+	CancelJob(context.Context, *database.Job) (bool, error)
+	GetCapabilities() Capabilities
+}
+
+// CallbackHandler is implemented by providers that push status updates to
+// /callback/{provider}
+type CallbackHandler interface {
+	ParseCallback(query url.Values, body []byte) (*Callback, error)
+}
+
+// Callback is a provider status notification parsed by a CallbackHandler.
+// JobID is only set when the provider knows which job the callback refers to,
+// otherwise the job is resolved by ProviderID.
+type Callback struct {
+	JobID      string
+	ProviderID string
+	Status     string
+}
+
+// CaptionUpdater is implemented by providers whose jobs deliver a caption file
+// that can be replaced by a new version after the job is done
+type CaptionUpdater interface {
+	UpdateCaption(context.Context, *database.Job, database.UploadedFile) error
+}
+
+// Estimator is implemented by providers that can price a job before it is ordered
+type Estimator interface {
+	Estimate(EstimateRequest) (*Estimate, error)
+}
+
+// TurnaroundEstimator is implemented by providers that know how long a job takes
+// to be delivered, jobs get their deadline from it when they are dispatched
+type TurnaroundEstimator interface {
+	Turnaround(database.ProviderParams) (time.Duration, error)
+}
+
+// EstimateRequest describes the job to estimate
+type EstimateRequest struct {
+	// Duration is the media duration
+	Duration time.Duration
+	JobType  string
+	Language string
+	Params   database.ProviderParams
+}
+
+// Estimate is the expected cost of a job and the time it takes to be delivered
+type Estimate struct {
+	Cost       float64
+	Currency   string
+	Turnaround time.Duration
+	Details    string
+}
+
+// Capabilities describes what a Provider supports, so jobs can be validated
+// before they are dispatched
+type Capabilities struct {
+	Name        string   `json:"name"`
+	OutputTypes []string `json:"output_types"`
+	// Languages lists the supported language codes, an empty list means any language
+	Languages   []string    `json:"languages"`
+	Cancellable bool        `json:"cancellable"`
+	Params      []ParamSpec `json:"params"`
+	// AdditionalParams is true when provider_params not listed in Params are
+	// passed through to the vendor as-is
+	AdditionalParams bool `json:"additional_params"`
+	// Importable is true when jobs ordered outside the API can be adopted by
+	// their provider ID
+	Importable bool `json:"importable"`
+}
+
+// ParamSpec describes a provider_params entry accepted by a Provider
+type ParamSpec struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Required    bool   `json:"required"`
+}
+
+// ValidationError is returned when a job doesn't match a Provider's Capabilities
+type ValidationError struct {
+	Provider string
+	Message  string
+}
+
+// Error implements the error interface
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Provider, e.Message)
+}
+
+// amara reports "status 404: ..." and 3play "404: not_found-..."
+var responseStatusPattern = regexp.MustCompile(`(?:^|: |status )([1-5][0-9][0-9])(?::|$)`)
+
+// ResponseStatus returns the HTTP status of the vendor response an error was
+// built from, if any. The amara and 3play clients only report it in their
+// error messages.
+func ResponseStatus(err error) (int, bool) {
+	var statusErr interface{ HTTPStatus() int }
+	if errors.As(err, &statusErr) {
+		return statusErr.HTTPStatus(), true
+	}
+	match := responseStatusPattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return 0, false
+	}
+	status, _ := strconv.Atoi(match[1])
+	return status, true
+}
+
+// Validate checks output types, language and provider params against the Capabilities
+func (c Capabilities) Validate(outputTypes []string, language string, params database.ProviderParams) error {
+	if len(c.OutputTypes) > 0 {
+		for _, outputType := range outputTypes {
+			if !contains(c.OutputTypes, outputType) {
+				return ValidationError{c.Name, fmt.Sprintf("unsupported output type %q, supported types are: %s", outputType, strings.Join(c.OutputTypes, ", "))}
+			}
+		}
+	}
+
+	if language != "" && len(c.Languages) > 0 && !contains(c.Languages, language) {
+		return ValidationError{c.Name, fmt.Sprintf("unsupported language %q, supported languages are: %s", language, strings.Join(c.Languages, ", "))}
+	}
+
+	known := make(map[string]bool, len(c.Params))
+	for _, spec := range c.Params {
+		known[spec.Name] = true
+		if _, ok := params[spec.Name]; spec.Required && !ok {
+			return ValidationError{c.Name, fmt.Sprintf("missing required provider param %q", spec.Name)}
+		}
+	}
+	if !c.AdditionalParams {
+		for name := range params {
+			if !known[name] {
+				return ValidationError{c.Name, fmt.Sprintf("unknown provider param %q", name)}
+			}
+		}
+	}
+	return nil
+}
+
+// withContext runs fn and returns as soon as it completes or ctx is done,
+// whichever happens first. The vendor clients don't accept a context, so an
+// abandoned call keeps running in the background until their own HTTP timeout.
+func withContext(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// lookupCredential returns the credential of the job. The store credentials of
+// its tenant and job type come first, then jobTypeFallback, the credential the
+// provider config holds for the job type, then the store credential of the
+// provider and finally fallback.
+func lookupCredential(ctx context.Context, store credentials.Store, provider string, job *database.Job, jobTypeFallback, fallback string) (string, error) {
+	key := credentials.Key{Provider: provider, JobType: job.JobType, Tenant: job.Tenant}
+	if store != nil {
+		secret, err := credentials.LookupSpecific(ctx, store, key)
+		if err != credentials.ErrNotFound {
+			return wrapCredentialError(provider, secret, err)
+		}
+	}
+	if jobTypeFallback != "" {
+		return jobTypeFallback, nil
+	}
+	if store != nil {
+		secret, err := store.Get(ctx, credentials.Key{Provider: provider})
+		if err != credentials.ErrNotFound {
+			return wrapCredentialError(provider, secret, err)
+		}
+	}
+	return fallback, nil
+}
+
+func wrapCredentialError(provider, secret string, err error) (string, error) {
+	if err != nil {
+		return "", fmt.Errorf("could not look up %s credentials: %v", provider, err)
+	}
+	return secret, nil
+}
+
+// perMinuteCost prices a media duration at a rate per minute, rounded to the cent
+func perMinuteCost(duration time.Duration, rate float64) float64 {
+	return math.Round(duration.Minutes()*rate*100) / 100
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,284 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/nytimes/video-captions-api/config"
+	"github.com/nytimes/video-captions-api/credentials"
+	"github.com/nytimes/video-captions-api/database"
+	log "github.com/sirupsen/logrus"
+)
+
+const revProviderName string = "rev"
+
+// revCaptionTypes maps output types to the Accept header of the captions endpoint
+var revCaptionTypes = map[string]string{
+	"vtt": "text/vtt",
+	"srt": "application/x-subrip",
+}
+
+// revStatuses maps Rev job statuses to captions job statuses
+var revStatuses = map[string]database.JobStatus{
+	"in_progress": database.StatusProcessing,
+	"transcribed": database.StatusComplete,
+	"failed":      database.StatusError,
+}
+
+// RevProvider is a client for Rev-style speech to text APIs that implements the Provider interface
+type RevProvider struct {
+	httpClient  *http.Client
+	logger      *log.Logger
+	config      RevConfig
+	credentials credentials.Store
+}
+
+// RevConfig holds Rev related config
+type RevConfig struct {
+	Token   string `envconfig:"REV_ACCESS_TOKEN"`
+	BaseURL string `envconfig:"REV_BASE_URL" default:"https://api.rev.ai/speechtotext/v1"`
+	// CallbackURL is the default notification URL, usually this API's /callback/rev
+	CallbackURL string `envconfig:"REV_CALLBACK_URL"`
+}
+
+// RevJob is the job representation returned by the Rev API
+type RevJob struct {
+	ID            string  `json:"id"`
+	Status        string  `json:"status"`
+	Failure       string  `json:"failure,omitempty"`
+	FailureDetail string  `json:"failure_detail,omitempty"`
+	Duration      float64 `json:"duration_seconds,omitempty"`
+	Metadata      string  `json:"metadata,omitempty"`
+}
+
+// RevCallback is the payload Rev posts to the notification URL
+type RevCallback struct {
+	Job RevJob `json:"job"`
+}
+
+// revError is returned for non 2xx responses from the Rev API
+type revError struct {
+	StatusCode int
+	Body       string
+}
+
+// Error implements the error interface
+func (e revError) Error() string {
+	return fmt.Sprintf("rev: status %d: %s", e.StatusCode, e.Body)
+}
+
+// HTTPStatus returns the status of the response
+func (e revError) HTTPStatus() int {
+	return e.StatusCode
+}
+
+// NewRevProvider creates a RevProvider
+func NewRevProvider(cfg *RevConfig, svcCfg *config.CaptionsServiceConfig) Provider {
+	return &RevProvider{
+		&http.Client{},
+		svcCfg.Logger,
+		*cfg,
+		svcCfg.Credentials,
+	}
+}
+
+// LoadRevConfigFromEnv loads Rev token and base URL from environment
+func LoadRevConfigFromEnv() RevConfig {
+	var providerConfig RevConfig
+	envconfig.Process("", &providerConfig)
+	return providerConfig
+}
+
+// GetName returns provider name
+func (c *RevProvider) GetName() string {
+	return revProviderName
+}
+
+// GetCapabilities returns the output formats, languages and params supported by Rev
+func (c *RevProvider) GetCapabilities() Capabilities {
+	return Capabilities{
+		Name:        revProviderName,
+		OutputTypes: []string{"vtt", "srt"},
+		Languages:   []string{"en", "es", "fr", "de", "it", "pt", "nl", "ja", "zh"},
+		// Rev can't stop a job in progress, only delete finished ones
+		Cancellable: false,
+		Params: []ParamSpec{
+			{Name: "callback", Description: "URL Rev notifies when the job completes, defaults to REV_CALLBACK_URL"},
+			{Name: "skip_diarization", Description: "true to skip speaker identification"},
+			{Name: "skip_punctuation", Description: "true to skip punctuation"},
+			{Name: "custom_vocabulary_id", Description: "custom vocabulary to transcribe with"},
+		},
+	}
+}
+
+// DispatchJob submits the job media URL to Rev for transcription
+func (c *RevProvider) DispatchJob(ctx context.Context, job *database.Job) error {
+	jobLogger := c.logger.WithFields(log.Fields{"JobID": job.ID, "Provider": job.Provider})
+	body := map[string]interface{}{
+		"media_url": job.MediaURL,
+		"metadata":  job.ID,
+		"language":  job.Language,
+	}
+
+	callbackURL := c.config.CallbackURL
+	for k, v := range job.ProviderParams {
+		switch k {
+		case "callback":
+			callbackURL = v
+		case "skip_diarization", "skip_punctuation":
+			skip, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %v", k, err)
+			}
+			body[k] = skip
+		default:
+			body[k] = v
+		}
+	}
+	if callbackURL != "" {
+		u, err := url.Parse(callbackURL)
+		if err != nil {
+			return fmt.Errorf("invalid callback url: %v", err)
+		}
+		query := u.Query()
+		query.Set("job_id", job.ID)
+		u.RawQuery = query.Encode()
+		body["callback_url"] = u.String()
+	}
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	var revJob RevJob
+	err = c.doJSON(ctx, job, http.MethodPost, "/jobs", bytes.NewReader(data), &revJob)
+	if err != nil {
+		jobLogger.WithError(err).Error("Failed to submit job to Rev")
+		return err
+	}
+	if revJob.ID == "" {
+		return fmt.Errorf("received invalid job: %v", revJob)
+	}
+
+	job.ProviderParams["ProviderID"] = revJob.ID
+	return nil
+}
+
+// GetProviderJob returns the current job status from Rev
+func (c *RevProvider) GetProviderJob(ctx context.Context, job *database.Job) (*database.ProviderJob, error) {
+	var payload json.RawMessage
+	err := c.doJSON(ctx, job, http.MethodGet, "/jobs/"+url.PathEscape(job.GetProviderID()), nil, &payload)
+	if err != nil {
+		return nil, err
+	}
+	var revJob RevJob
+	if err := json.Unmarshal(payload, &revJob); err != nil {
+		return nil, err
+	}
+
+	return &database.ProviderJob{
+		ID:          revJob.ID,
+		Status:      revStatuses[revJob.Status],
+		RawStatus:   revJob.Status,
+		Payload:     payload,
+		Details:     revJob.FailureDetail,
+		Cancellable: revJob.Status != "in_progress",
+	}, nil
+}
+
+// Download downloads the job captions in the given format
+func (c *RevProvider) Download(ctx context.Context, job *database.Job, captionsType string) ([]byte, error) {
+	accept, ok := revCaptionTypes[captionsType]
+	if !ok {
+		return nil, fmt.Errorf("unsupported caption format: %s", captionsType)
+	}
+	res, err := c.do(ctx, job, http.MethodGet, "/jobs/"+url.PathEscape(job.GetProviderID())+"/captions", nil, accept)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return ioutil.ReadAll(res.Body)
+}
+
+// CancelJob deletes the job on Rev, which refuses to delete jobs in progress
+func (c *RevProvider) CancelJob(ctx context.Context, job *database.Job) (bool, error) {
+	res, err := c.do(ctx, job, http.MethodDelete, "/jobs/"+url.PathEscape(job.GetProviderID()), nil, "")
+	if err != nil {
+		var revErr revError
+		if errors.As(err, &revErr) && revErr.StatusCode == http.StatusConflict {
+			return false, errors.New("job is not cancellable")
+		}
+		return false, err
+	}
+	res.Body.Close()
+	return true, nil
+}
+
+// ParseCallback parses a Rev job notification. DispatchJob adds the job_id query
+// param to the notification URL, the job metadata holds it as well.
+func (c *RevProvider) ParseCallback(query url.Values, body []byte) (*Callback, error) {
+	var callback RevCallback
+	if err := json.Unmarshal(body, &callback); err != nil {
+		return nil, err
+	}
+	if callback.Job.ID == "" {
+		return nil, ErrInvalidProviderID
+	}
+	jobID := query.Get("job_id")
+	if jobID == "" {
+		jobID = callback.Job.Metadata
+	}
+	return &Callback{
+		JobID:      jobID,
+		ProviderID: callback.Job.ID,
+		Status:     callback.Job.Status,
+	}, nil
+}
+
+func (c *RevProvider) doJSON(ctx context.Context, job *database.Job, method, path string, body io.Reader, dst interface{}) error {
+	res, err := c.do(ctx, job, method, path, body, "application/json")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return json.NewDecoder(res.Body).Decode(dst)
+}
+
+// do calls the Rev API with the access token of the job tenant and job type,
+// falling back to REV_ACCESS_TOKEN
+func (c *RevProvider) do(ctx context.Context, job *database.Job, method, path string, body io.Reader, accept string) (*http.Response, error) {
+	token, err := lookupCredential(ctx, c.credentials, c.GetName(), job, "", c.config.Token)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.config.BaseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		defer res.Body.Close()
+		data, _ := ioutil.ReadAll(res.Body)
+		return nil, revError{res.StatusCode, string(data)}
+	}
+	return res, nil
+}
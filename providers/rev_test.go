@@ -0,0 +1,238 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nytimes/video-captions-api/config"
+	"github.com/nytimes/video-captions-api/credentials"
+	"github.com/nytimes/video-captions-api/database"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRevAPI is an in-memory stand-in for the Rev speech to text API
+type fakeRevAPI struct {
+	mtx      sync.Mutex
+	token    string
+	jobs     map[string]*RevJob
+	requests []map[string]interface{}
+}
+
+func (f *fakeRevAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer "+f.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/speechtotext/v1/jobs")
+	switch {
+	case r.Method == http.MethodPost && path == "":
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.requests = append(f.requests, body)
+		job := &RevJob{ID: "rev-1", Status: "in_progress", Metadata: body["metadata"].(string)}
+		f.jobs[job.ID] = job
+		json.NewEncoder(w).Encode(job)
+	case r.Method == http.MethodGet && strings.HasSuffix(path, "/captions"):
+		job, ok := f.jobs[strings.TrimSuffix(strings.TrimPrefix(path, "/"), "/captions")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if job.Status != "transcribed" {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		switch r.Header.Get("Accept") {
+		case "text/vtt":
+			w.Write([]byte("WEBVTT\n\n00:00:00.000 --> 00:00:01.000\nHello"))
+		case "application/x-subrip":
+			w.Write([]byte("1\n00:00:00,000 --> 00:00:01,000\nHello"))
+		default:
+			w.WriteHeader(http.StatusNotAcceptable)
+		}
+	case r.Method == http.MethodGet:
+		job, ok := f.jobs[strings.TrimPrefix(path, "/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"title": "could not find job"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(job)
+	case r.Method == http.MethodDelete:
+		id := strings.TrimPrefix(path, "/")
+		job, ok := f.jobs[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if job.Status == "in_progress" {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		delete(f.jobs, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeRevAPI) setStatus(id, status, detail string) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	f.jobs[id].Status = status
+	f.jobs[id].FailureDetail = detail
+}
+
+func newTestRevProvider() (*RevProvider, *fakeRevAPI, *httptest.Server) {
+	api := &fakeRevAPI{token: "rev-token", jobs: make(map[string]*RevJob)}
+	server := httptest.NewServer(api)
+	provider := NewRevProvider(&RevConfig{
+		Token:       "rev-token",
+		BaseURL:     server.URL + "/speechtotext/v1",
+		CallbackURL: "https://captions.nyt.net/callback/rev?token=secret",
+	}, &config.CaptionsServiceConfig{Logger: log.New()})
+	return provider.(*RevProvider), api, server
+}
+
+func newRevJob() *database.Job {
+	return &database.Job{
+		ID:             "123",
+		MediaURL:       "http://vp.nyt.com/video.mp4",
+		Provider:       "rev",
+		Language:       "en",
+		ProviderParams: map[string]string{"skip_diarization": "true"},
+	}
+}
+
+func TestRevDispatchJob(t *testing.T) {
+	assert := assert.New(t)
+	provider, api, server := newTestRevProvider()
+	defer server.Close()
+	job := newRevJob()
+
+	err := provider.DispatchJob(context.Background(), job)
+	assert.Nil(err)
+	assert.Equal("rev-1", job.GetProviderID())
+	assert.Len(api.requests, 1)
+	assert.Equal("http://vp.nyt.com/video.mp4", api.requests[0]["media_url"])
+	assert.Equal("123", api.requests[0]["metadata"])
+	assert.Equal(true, api.requests[0]["skip_diarization"])
+
+	callbackURL, _ := url.Parse(api.requests[0]["callback_url"].(string))
+	assert.Equal("123", callbackURL.Query().Get("job_id"))
+	assert.Equal("secret", callbackURL.Query().Get("token"))
+}
+
+func TestRevTenantCredentials(t *testing.T) {
+	assert := assert.New(t)
+	provider, api, server := newTestRevProvider()
+	defer server.Close()
+	provider.credentials = credentials.NewFileStore("testdata/credentials.yaml")
+	api.token = "opinion-token"
+
+	job := newRevJob()
+	job.Tenant = "opinion"
+	assert.Nil(provider.DispatchJob(context.Background(), job))
+	assert.Equal("rev-1", job.GetProviderID())
+
+	err := provider.DispatchJob(context.Background(), newRevJob())
+	assert.Equal(revError{http.StatusUnauthorized, ""}, err)
+}
+
+func TestRevDispatchJobError(t *testing.T) {
+	provider, _, server := newTestRevProvider()
+	defer server.Close()
+	provider.config.Token = "wrong"
+	err := provider.DispatchJob(context.Background(), newRevJob())
+	assert.EqualError(t, err, "rev: status 401: ")
+}
+
+func TestRevGetProviderJob(t *testing.T) {
+	assert := assert.New(t)
+	provider, api, server := newTestRevProvider()
+	defer server.Close()
+	job := newRevJob()
+	provider.DispatchJob(context.Background(), job)
+
+	providerJob, err := provider.GetProviderJob(context.Background(), job)
+	assert.Nil(err)
+	assert.Equal(database.StatusProcessing, providerJob.Status)
+	assert.False(providerJob.Cancellable)
+
+	api.setStatus("rev-1", "failed", "media could not be downloaded")
+	providerJob, err = provider.GetProviderJob(context.Background(), job)
+	assert.Nil(err)
+	assert.Equal(database.StatusError, providerJob.Status)
+	assert.Equal("media could not be downloaded", providerJob.Details)
+	assert.True(providerJob.Cancellable)
+	assert.Contains(string(providerJob.Payload), `"failure_detail"`)
+
+	job.ProviderParams["ProviderID"] = "404"
+	_, err = provider.GetProviderJob(context.Background(), job)
+	assert.EqualError(err, `rev: status 404: {"title": "could not find job"}`)
+}
+
+func TestRevDownload(t *testing.T) {
+	assert := assert.New(t)
+	provider, api, server := newTestRevProvider()
+	defer server.Close()
+	job := newRevJob()
+	provider.DispatchJob(context.Background(), job)
+	api.setStatus("rev-1", "transcribed", "")
+
+	vtt, err := provider.Download(context.Background(), job, "vtt")
+	assert.Nil(err)
+	assert.Equal("WEBVTT\n\n00:00:00.000 --> 00:00:01.000\nHello", string(vtt))
+
+	srt, err := provider.Download(context.Background(), job, "srt")
+	assert.Nil(err)
+	assert.Equal("1\n00:00:00,000 --> 00:00:01,000\nHello", string(srt))
+
+	_, err = provider.Download(context.Background(), job, "dfxp")
+	assert.EqualError(err, "unsupported caption format: dfxp")
+}
+
+func TestRevCancelJob(t *testing.T) {
+	assert := assert.New(t)
+	provider, api, server := newTestRevProvider()
+	defer server.Close()
+	job := newRevJob()
+	provider.DispatchJob(context.Background(), job)
+
+	cancelled, err := provider.CancelJob(context.Background(), job)
+	assert.False(cancelled)
+	assert.EqualError(err, "job is not cancellable")
+
+	api.setStatus("rev-1", "transcribed", "")
+	providerJob, err := provider.GetProviderJob(context.Background(), job)
+	assert.Nil(err)
+	assert.True(providerJob.Cancellable)
+	cancelled, err = provider.CancelJob(context.Background(), job)
+	assert.True(cancelled)
+	assert.Nil(err)
+	assert.Empty(api.jobs)
+}
+
+func TestRevParseCallback(t *testing.T) {
+	assert := assert.New(t)
+	provider, _, server := newTestRevProvider()
+	defer server.Close()
+
+	callback, err := provider.ParseCallback(url.Values{}, []byte(`{"job": {"id": "rev-1", "status": "transcribed", "metadata": "123"}}`))
+	assert.Nil(err)
+	assert.Equal(&Callback{JobID: "123", ProviderID: "rev-1", Status: "transcribed"}, callback)
+
+	_, err = provider.ParseCallback(url.Values{}, []byte(`{"job": {}}`))
+	assert.Equal(ErrInvalidProviderID, err)
+}
@@ -0,0 +1,340 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/nytimes/video-captions-api/config"
+	"github.com/nytimes/video-captions-api/database"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	sandboxProviderName string = "sandbox"
+	sandboxDispatchedAt string = "SandboxDispatchedAt"
+)
+
+// SandboxProvider simulates a vendor without calling one. Jobs move through the
+// configured statuses, one every step, based on the time elapsed since they were
+// dispatched. These provider params change its behavior:
+//
+//	fail          "true" ends the job in error instead of the last status
+//	fail_dispatch "true" rejects the job on dispatch
+//	stall         "true" keeps the job in the first status
+//	cancellable   "false" makes the job impossible to cancel
+//	step_duration overrides the configured step duration, e.g. "5s"
+type SandboxProvider struct {
+	httpClient *http.Client
+	logger     *log.Logger
+	config     SandboxConfig
+	now        func() time.Time
+
+	mtx    sync.Mutex
+	timers map[string][]*time.Timer
+}
+
+// SandboxConfig holds the sandbox provider config
+type SandboxConfig struct {
+	Statuses     []string      `envconfig:"SANDBOX_STATUSES" default:"processing,in review,complete"`
+	StepDuration time.Duration `envconfig:"SANDBOX_STEP_DURATION" default:"30s"`
+	// CallbackURL is notified on every status change, usually this API's /callback/sandbox
+	CallbackURL string `envconfig:"SANDBOX_CALLBACK_URL"`
+	Enabled     bool   `envconfig:"SANDBOX_ENABLED"`
+}
+
+// Configured tells whether the sandbox should be offered, it's off unless enabled or given a callback URL
+func (c SandboxConfig) Configured() bool {
+	return c.Enabled || c.CallbackURL != ""
+}
+
+// SandboxCallback is the payload the sandbox posts to the callback URL
+type SandboxCallback struct {
+	JobID      string `json:"job_id"`
+	ProviderID string `json:"provider_id"`
+	Status     string `json:"status"`
+}
+
+// NewSandboxProvider creates a SandboxProvider
+func NewSandboxProvider(cfg *SandboxConfig, svcCfg *config.CaptionsServiceConfig) Provider {
+	return &SandboxProvider{
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		logger:     svcCfg.Logger,
+		config:     *cfg,
+		now:        time.Now,
+		timers:     make(map[string][]*time.Timer),
+	}
+}
+
+// LoadSandboxConfigFromEnv loads the sandbox statuses and timing from environment
+func LoadSandboxConfigFromEnv() SandboxConfig {
+	var providerConfig SandboxConfig
+	envconfig.Process("", &providerConfig)
+	return providerConfig
+}
+
+// GetName returns provider name
+func (c *SandboxProvider) GetName() string {
+	return sandboxProviderName
+}
+
+// GetCapabilities returns the sandbox capabilities, any output type and language is accepted
+func (c *SandboxProvider) GetCapabilities() Capabilities {
+	return Capabilities{
+		Name:        sandboxProviderName,
+		Cancellable: true,
+		Params: []ParamSpec{
+			{Name: "fail", Description: `"true" ends the job in error`},
+			{Name: "fail_dispatch", Description: `"true" rejects the job on dispatch`},
+			{Name: "stall", Description: `"true" keeps the job in its first status`},
+			{Name: "cancellable", Description: `"false" makes the job impossible to cancel`},
+			{Name: "step_duration", Description: "time between status changes, e.g. 5s"},
+		},
+	}
+}
+
+// DispatchJob records the dispatch time and schedules a callback for every status change
+func (c *SandboxProvider) DispatchJob(_ context.Context, job *database.Job) error {
+	if job.ProviderParams["fail_dispatch"] == "true" {
+		return errors.New("sandbox: dispatch failed as requested")
+	}
+	step, err := c.stepDuration(job)
+	if err != nil {
+		return err
+	}
+
+	job.ProviderParams["ProviderID"] = "sandbox-" + job.ID
+	job.ProviderParams[sandboxDispatchedAt] = c.now().UTC().Format(time.RFC3339Nano)
+
+	statuses := c.statuses(job)
+	if c.config.CallbackURL == "" || len(statuses) < 2 {
+		return nil
+	}
+	timers := make([]*time.Timer, len(statuses)-1)
+	// the lock is held until the timers are stored, so the last one can't forget them before
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for i := 1; i < len(statuses); i++ {
+		callback := SandboxCallback{JobID: job.ID, ProviderID: job.GetProviderID(), Status: statuses[i]}
+		last := i == len(statuses)-1
+		timers[i-1] = time.AfterFunc(time.Duration(i)*step, func() {
+			if last {
+				c.forgetTimers(callback.JobID, timers)
+			}
+			c.sendCallback(callback)
+		})
+	}
+	c.timers[job.ID] = timers
+	return nil
+}
+
+// forgetTimers removes the job timers once they all fired, unless the job was dispatched again
+func (c *SandboxProvider) forgetTimers(jobID string, timers []*time.Timer) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if current := c.timers[jobID]; len(current) > 0 && current[0] == timers[0] {
+		delete(c.timers, jobID)
+	}
+}
+
+// GetProviderJob returns the status the job reached given the time since dispatch
+func (c *SandboxProvider) GetProviderJob(_ context.Context, job *database.Job) (*database.ProviderJob, error) {
+	step, index, err := c.progress(job)
+	if err != nil {
+		return nil, err
+	}
+	statuses := c.statuses(job)
+	// the configured statuses play the vendor statuses, unknown ones translate to no status
+	providerJob := &database.ProviderJob{
+		ID:          job.GetProviderID(),
+		RawStatus:   statuses[index],
+		Cancellable: job.ProviderParams["cancellable"] != "false" && !c.finished(job, index),
+		Params:      map[string]string{"SandboxStep": strconv.Itoa(index + 1)},
+	}
+	if status := database.JobStatus(statuses[index]); status.Known() {
+		providerJob.Status = status
+	}
+	if providerJob.Status == database.StatusError {
+		providerJob.Details = fmt.Sprintf("sandbox job failed after %s as requested", time.Duration(index)*step)
+	}
+	return providerJob, nil
+}
+
+// Download returns captions generated from the job ID, identical for every call
+func (c *SandboxProvider) Download(_ context.Context, job *database.Job, captionsType string) ([]byte, error) {
+	_, index, err := c.progress(job)
+	if err != nil {
+		return nil, err
+	}
+	if !c.finished(job, index) || c.statuses(job)[index] == "error" {
+		return nil, errors.New("sandbox: captions are not ready")
+	}
+	return sandboxCaptions(job.ID, captionsType), nil
+}
+
+// CancelJob stops the job callbacks unless the job was made non-cancellable or is finished
+func (c *SandboxProvider) CancelJob(_ context.Context, job *database.Job) (bool, error) {
+	_, index, err := c.progress(job)
+	if err != nil {
+		return false, err
+	}
+	if job.ProviderParams["cancellable"] == "false" || c.finished(job, index) {
+		return false, errors.New("job is not cancellable")
+	}
+	c.mtx.Lock()
+	for _, timer := range c.timers[job.ID] {
+		timer.Stop()
+	}
+	delete(c.timers, job.ID)
+	c.mtx.Unlock()
+	return true, nil
+}
+
+// ParseCallback parses the notifications sent by DispatchJob
+func (c *SandboxProvider) ParseCallback(query url.Values, body []byte) (*Callback, error) {
+	var callback SandboxCallback
+	if err := json.Unmarshal(body, &callback); err != nil {
+		return nil, err
+	}
+	if callback.ProviderID == "" {
+		return nil, ErrInvalidProviderID
+	}
+	jobID := query.Get("job_id")
+	if jobID == "" {
+		jobID = callback.JobID
+	}
+	return &Callback{
+		JobID:      jobID,
+		ProviderID: callback.ProviderID,
+		Status:     callback.Status,
+	}, nil
+}
+
+// statuses returns the statuses the job goes through
+func (c *SandboxProvider) statuses(job *database.Job) []string {
+	statuses := c.config.Statuses
+	if len(statuses) == 0 {
+		statuses = []string{"complete"}
+	}
+	if job.ProviderParams["stall"] == "true" {
+		return statuses[:1]
+	}
+	if job.ProviderParams["fail"] == "true" {
+		failed := append([]string{}, statuses[:len(statuses)-1]...)
+		return append(failed, "error")
+	}
+	return statuses
+}
+
+// finished tells whether the job reached its last status, stalled jobs never finish
+func (c *SandboxProvider) finished(job *database.Job, index int) bool {
+	return job.ProviderParams["stall"] != "true" && index == len(c.statuses(job))-1
+}
+
+// progress returns the job step duration and the index of its current status
+func (c *SandboxProvider) progress(job *database.Job) (time.Duration, int, error) {
+	step, err := c.stepDuration(job)
+	if err != nil {
+		return 0, 0, err
+	}
+	dispatchedAt, err := time.Parse(time.RFC3339Nano, job.ProviderParams[sandboxDispatchedAt])
+	if err != nil {
+		return 0, 0, errors.New("sandbox: job was not dispatched to the sandbox")
+	}
+	last := len(c.statuses(job)) - 1
+	if step <= 0 {
+		return step, last, nil
+	}
+	index := int(c.now().Sub(dispatchedAt) / step)
+	if index > last {
+		index = last
+	}
+	if index < 0 {
+		index = 0
+	}
+	return step, index, nil
+}
+
+func (c *SandboxProvider) stepDuration(job *database.Job) (time.Duration, error) {
+	value, ok := job.ProviderParams["step_duration"]
+	if !ok {
+		return c.config.StepDuration, nil
+	}
+	step, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid step_duration: %v", err)
+	}
+	return step, nil
+}
+
+func (c *SandboxProvider) sendCallback(callback SandboxCallback) {
+	jobLogger := c.logger.WithFields(log.Fields{"JobID": callback.JobID, "Provider": sandboxProviderName, "Status": callback.Status})
+	u, err := url.Parse(c.config.CallbackURL)
+	if err != nil {
+		jobLogger.WithError(err).Error("Invalid sandbox callback URL")
+		return
+	}
+	query := u.Query()
+	query.Set("job_id", callback.JobID)
+	u.RawQuery = query.Encode()
+
+	data, _ := json.Marshal(callback)
+	res, err := c.httpClient.Post(u.String(), "application/json", bytes.NewReader(data))
+	if err != nil {
+		jobLogger.WithError(err).Error("Failed to send sandbox callback")
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		jobLogger.Errorf("Sandbox callback returned status %d", res.StatusCode)
+	}
+}
+
+// sandboxCaptions generates three cues in the given format, any unknown format gets plain text
+func sandboxCaptions(jobID, captionsType string) []byte {
+	lines := []string{
+		"This is a sandbox caption.",
+		"It was generated for job " + jobID + ".",
+		"No vendor was involved.",
+	}
+	var b strings.Builder
+	switch captionsType {
+	case "vtt":
+		b.WriteString("WEBVTT\n")
+		for i, line := range lines {
+			fmt.Fprintf(&b, "\n00:00:%02d.000 --> 00:00:%02d.000\n%s\n", i*2, i*2+2, line)
+		}
+	case "srt":
+		for i, line := range lines {
+			fmt.Fprintf(&b, "%d\r\n00:00:%02d,000 --> 00:00:%02d,000\r\n%s\r\n\r\n", i+1, i*2, i*2+2, line)
+		}
+	case "sbv":
+		for i, line := range lines {
+			fmt.Fprintf(&b, "0:00:%02d.000,0:00:%02d.000\n%s\r\n\r\n", i*2, i*2+2, line)
+		}
+	case "ssa":
+		b.WriteString("[Script Info]\nTitle: Sandbox\n[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n")
+		for i, line := range lines {
+			fmt.Fprintf(&b, "Dialogue: 0,0:00:%02d.00,0:00:%02d.00,Default,,0000,0000,0000,,%s\n", i*2, i*2+2, line)
+		}
+	case "dfxp":
+		b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+		b.WriteString(`<tt xmlns="http://www.w3.org/ns/ttml"><body><div>` + "\n")
+		for i, line := range lines {
+			fmt.Fprintf(&b, "<p begin=\"00:00:%02d.000\" end=\"00:00:%02d.000\">%s</p>\n", i*2, i*2+2, line)
+		}
+		b.WriteString("</div></body></tt>\n")
+	default:
+		b.WriteString(strings.Join(lines, " ") + "\n")
+	}
+	return []byte(b.String())
+}
@@ -0,0 +1,200 @@
+package providers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/nytimes/video-captions-api/config"
+	"github.com/nytimes/video-captions-api/database"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestSandboxProvider(callbackURL string) (*SandboxProvider, *time.Time) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	provider := NewSandboxProvider(&SandboxConfig{
+		Statuses:     []string{"processing", "in review", "complete"},
+		StepDuration: time.Minute,
+		CallbackURL:  callbackURL,
+	}, &config.CaptionsServiceConfig{Logger: log.New()}).(*SandboxProvider)
+	provider.now = func() time.Time { return now }
+	return provider, &now
+}
+
+func newSandboxJob(params map[string]string) *database.Job {
+	if params == nil {
+		params = map[string]string{}
+	}
+	return &database.Job{
+		ID:             "123",
+		MediaURL:       "http://vp.nyt.com/video.mp4",
+		Provider:       "sandbox",
+		ProviderParams: params,
+	}
+}
+
+func TestSandboxLifecycle(t *testing.T) {
+	assert := assert.New(t)
+	provider, now := newTestSandboxProvider("")
+	job := newSandboxJob(nil)
+
+	assert.Nil(provider.DispatchJob(context.Background(), job))
+	assert.Equal("sandbox-123", job.GetProviderID())
+
+	steps := []struct {
+		elapsed     time.Duration
+		status      database.JobStatus
+		cancellable bool
+	}{
+		{0, "processing", true},
+		{90 * time.Second, "in review", true},
+		{2 * time.Minute, "complete", false},
+		{time.Hour, "complete", false},
+	}
+	start := *now
+	for _, step := range steps {
+		*now = start.Add(step.elapsed)
+		providerJob, err := provider.GetProviderJob(context.Background(), job)
+		assert.Nil(err)
+		assert.Equal(step.status, providerJob.Status, step.elapsed)
+		assert.Equal(step.cancellable, providerJob.Cancellable, step.elapsed)
+	}
+
+	vtt, err := provider.Download(context.Background(), job, "vtt")
+	assert.Nil(err)
+	assert.Equal("WEBVTT\n\n00:00:00.000 --> 00:00:02.000\nThis is a sandbox caption.\n\n"+
+		"00:00:02.000 --> 00:00:04.000\nIt was generated for job 123.\n\n"+
+		"00:00:04.000 --> 00:00:06.000\nNo vendor was involved.\n", string(vtt))
+
+	again, _ := provider.Download(context.Background(), job, "vtt")
+	assert.Equal(vtt, again)
+	txt, err := provider.Download(context.Background(), job, "unknown")
+	assert.Nil(err)
+	assert.Equal("This is a sandbox caption. It was generated for job 123. No vendor was involved.\n", string(txt))
+}
+
+func TestSandboxBehaviors(t *testing.T) {
+	tests := []struct {
+		name        string
+		params      map[string]string
+		status      database.JobStatus
+		details     string
+		cancellable bool
+	}{
+		{"Fail", map[string]string{"fail": "true"}, "error", "sandbox job failed after 2m0s as requested", false},
+		{"Stall", map[string]string{"stall": "true"}, "processing", "", true},
+		{"Non cancellable", map[string]string{"cancellable": "false"}, "complete", "", false},
+		{"Step duration", map[string]string{"step_duration": "1h"}, "processing", "", true},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			provider, now := newTestSandboxProvider("")
+			job := newSandboxJob(test.params)
+			assert.Nil(provider.DispatchJob(context.Background(), job))
+
+			*now = now.Add(time.Hour - time.Second)
+			providerJob, err := provider.GetProviderJob(context.Background(), job)
+			assert.Nil(err)
+			assert.Equal(test.status, providerJob.Status)
+			assert.Equal(test.details, providerJob.Details)
+			assert.Equal(test.cancellable, providerJob.Cancellable)
+
+			_, err = provider.Download(context.Background(), job, "srt")
+			if test.status == "complete" {
+				assert.Nil(err)
+			} else {
+				assert.EqualError(err, "sandbox: captions are not ready")
+			}
+		})
+	}
+}
+
+func TestSandboxDispatchFailure(t *testing.T) {
+	provider, _ := newTestSandboxProvider("")
+	err := provider.DispatchJob(context.Background(), newSandboxJob(map[string]string{"fail_dispatch": "true"}))
+	assert.EqualError(t, err, "sandbox: dispatch failed as requested")
+
+	err = provider.DispatchJob(context.Background(), newSandboxJob(map[string]string{"step_duration": "soon"}))
+	assert.EqualError(t, err, `invalid step_duration: time: invalid duration "soon"`)
+}
+
+func TestSandboxCancelJob(t *testing.T) {
+	assert := assert.New(t)
+	provider, now := newTestSandboxProvider("")
+	job := newSandboxJob(nil)
+	provider.DispatchJob(context.Background(), job)
+
+	cancelled, err := provider.CancelJob(context.Background(), job)
+	assert.True(cancelled)
+	assert.Nil(err)
+
+	*now = now.Add(time.Hour)
+	cancelled, err = provider.CancelJob(context.Background(), job)
+	assert.False(cancelled)
+	assert.EqualError(err, "job is not cancellable")
+
+	job = newSandboxJob(map[string]string{"cancellable": "false"})
+	provider.DispatchJob(context.Background(), job)
+	cancelled, err = provider.CancelJob(context.Background(), job)
+	assert.False(cancelled)
+	assert.EqualError(err, "job is not cancellable")
+}
+
+func TestSandboxCallbacks(t *testing.T) {
+	assert := assert.New(t)
+	callbacks := make(chan *Callback, 2)
+	var provider *SandboxProvider
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body json.RawMessage
+		json.NewDecoder(r.Body).Decode(&body)
+		callback, err := provider.ParseCallback(r.URL.Query(), body)
+		assert.Nil(err)
+		assert.Equal("secret", r.URL.Query().Get("token"))
+		callbacks <- callback
+	}))
+	defer server.Close()
+	provider, _ = newTestSandboxProvider(server.URL + "/callback/sandbox?token=secret")
+
+	job := newSandboxJob(map[string]string{"step_duration": "10ms"})
+	assert.Nil(provider.DispatchJob(context.Background(), job))
+	var statuses []string
+	for len(statuses) < 2 {
+		select {
+		case callback := <-callbacks:
+			assert.Equal("123", callback.JobID)
+			assert.Equal("sandbox-123", callback.ProviderID)
+			statuses = append(statuses, callback.Status)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d callbacks, expected 2", len(statuses))
+		}
+	}
+	assert.ElementsMatch([]string{"in review", "complete"}, statuses)
+	// the last callback is sent after the job timers are forgotten
+	provider.mtx.Lock()
+	assert.Empty(provider.timers)
+	provider.mtx.Unlock()
+}
+
+func TestSandboxConfigured(t *testing.T) {
+	assert := assert.New(t)
+	assert.False(SandboxConfig{}.Configured())
+	assert.True(SandboxConfig{Enabled: true}.Configured())
+	assert.True(SandboxConfig{CallbackURL: "http://captions/callback/sandbox"}.Configured())
+}
+
+func TestSandboxParseCallback(t *testing.T) {
+	provider, _ := newTestSandboxProvider("")
+	callback, err := provider.ParseCallback(url.Values{}, []byte(`{"job_id": "123", "provider_id": "sandbox-123", "status": "complete"}`))
+	assert.Nil(t, err)
+	assert.Equal(t, &Callback{JobID: "123", ProviderID: "sandbox-123", Status: "complete"}, callback)
+
+	_, err = provider.ParseCallback(url.Values{}, []byte(`{}`))
+	assert.Equal(t, ErrInvalidProviderID, err)
+}
@@ -0,0 +1,30 @@
+#!/bin/sh
+# Fake local ASR command used by the command provider tests. The media name
+# selects the behavior: "fail" exits 2, "review" exits 3, "unmapped" exits 4, "slow" runs until killed
+# and "wrapper" runs until killed in a child process, like wrapper scripts do.
+media="$1"
+language="$2"
+out="$3"
+
+case "$media" in
+*fail*)
+	echo "could not decode $media" >&2
+	exit 2
+	;;
+*review*)
+	exit 3
+	;;
+*unmapped*)
+	exit 4
+	;;
+*slow*)
+	exec sleep 30
+	;;
+*wrapper*)
+	sleep 30
+	exit 0
+	;;
+esac
+
+printf 'WEBVTT\n\n00:00:00.000 --> 00:00:01.000\nHello (%s)\n' "$language" >"$out/captions.vtt"
+printf '1\n00:00:00,000 --> 00:00:01,000\nHello (%s)\n' "$language" >"$out/captions.srt"
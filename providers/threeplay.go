@@ -1,15 +1,21 @@
 package providers
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/url"
+	"sort"
 	"strconv"
+	"time"
 
-	"github.com/nytimes/video-captions-api/config"
-	"github.com/nytimes/video-captions-api/database"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/nytimes/threeplay/types"
 	threeplay "github.com/nytimes/threeplay/v3api"
+	"github.com/nytimes/video-captions-api/config"
+	"github.com/nytimes/video-captions-api/credentials"
+	"github.com/nytimes/video-captions-api/database"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -18,8 +24,10 @@ const providerName string = "3play"
 // ThreePlayProvider is a 3play client that implements the Provider interface
 type ThreePlayProvider struct {
 	*threeplay.Client
-	logger *log.Logger
-	config ThreePlayConfig
+	logger      *log.Logger
+	config      ThreePlayConfig
+	db          database.DB
+	credentials credentials.Store
 }
 
 // ThreePlayConfig holds config necessary to create a ThreePlayProvider
@@ -27,15 +35,93 @@ type ThreePlayConfig struct {
 	APIKeyByJobType map[string]string `envconfig:"THREE_PLAY_API_KEY"`
 }
 
-// New3PlayProvider creates a ThreePlayProvider instance
-func New3PlayProvider(cfg *ThreePlayConfig, svcCfg *config.CaptionsServiceConfig) Provider {
+// threePlayTurnaround is the fidelity, surcharge per minute and delivery time
+// of a 3Play turnaround level
+type threePlayTurnaround struct {
+	fidelity   string
+	surcharge  float64
+	turnaround time.Duration
+}
+
+// threePlayFidelityRates are the 3Play prices per media minute by fidelity
+var threePlayFidelityRates = map[string]float64{
+	"MECHANICAL":   0.25,
+	"PROFESSIONAL": 2.50,
+}
+
+// threePlayTurnarounds maps turnaround_level_id to its price and delivery time,
+// "asr" orders a mechanical transcript
+var threePlayTurnarounds = map[string]threePlayTurnaround{
+	"asr": {"MECHANICAL", 0, time.Hour},
+	"1":   {"PROFESSIONAL", 0, 4 * 24 * time.Hour},
+	"2":   {"PROFESSIONAL", 1.00, 2 * 24 * time.Hour},
+	"3":   {"PROFESSIONAL", 1.50, 24 * time.Hour},
+	"4":   {"PROFESSIONAL", 2.50, 8 * time.Hour},
+	"5":   {"PROFESSIONAL", 5.00, 2 * time.Hour},
+}
+
+// threePlayStatuses maps 3Play transcript statuses to captions job statuses
+var threePlayStatuses = map[string]database.JobStatus{
+	"authorizing": database.StatusProcessing,
+	"pending":     database.StatusProcessing,
+	"in_progress": database.StatusProcessing,
+	"replacing":   database.StatusProcessing,
+	"reviewing":   database.StatusInReview,
+	"editing":     database.StatusInReview,
+	"complete":    database.StatusComplete,
+	"cancelled":   database.StatusCancelled,
+	"failed":      database.StatusError,
+}
+
+// threePlayStatus translates a 3Play transcript status, unknown statuses
+// translate to no status
+func threePlayStatus(status string) database.JobStatus {
+	return threePlayStatuses[status]
+}
+
+// ThreePlayCallback is the payload 3play posts when a transcript status changes
+type ThreePlayCallback struct {
+	Code int                   `json:"code"`
+	Data ThreePlayCallbackData `json:"data"`
+}
+
+// ThreePlayCallbackData holds the transcript details of a ThreePlayCallback
+type ThreePlayCallbackData struct {
+	Cancellable         bool    `json:"cancellable"`
+	Default             bool    `json:"default"`
+	ID                  int     `json:"id"`
+	BatchID             int     `json:"batch_id"`
+	LanguageID          int     `json:"language_id"`
+	MediaFileID         int     `json:"media_file_id"`
+	LanguageIDs         []int   `json:"language_ids"`
+	CancellationDetails string  `json:"cancellation_details"`
+	CancellationReason  string  `json:"cancellation_reason"`
+	ReferenceID         string  `json:"reference_id"`
+	Status              string  `json:"status"`
+	Type                string  `json:"type"`
+	Duration            float64 `json:"duration"`
+}
+
+// New3PlayProvider creates a ThreePlayProvider instance, db is used to find
+// media files uploaded for earlier jobs
+func New3PlayProvider(cfg *ThreePlayConfig, svcCfg *config.CaptionsServiceConfig, db database.DB) Provider {
 	return &ThreePlayProvider{
 		threeplay.NewClient(cfg.APIKeyByJobType["captions"]),
 		svcCfg.Logger,
 		*cfg,
+		db,
+		svcCfg.Credentials,
 	}
 }
 
+// callParams authenticates calls made for a job with the credential of its
+// tenant and job type, THREE_PLAY_API_KEY comes before the 3play wide credential
+// of the store
+func (c *ThreePlayProvider) callParams(ctx context.Context, job *database.Job) (threeplay.CallParams, error) {
+	apiKey, err := lookupCredential(ctx, c.credentials, providerName, job, c.config.APIKeyByJobType[job.JobType], "")
+	return threeplay.CallParams{APIKey: apiKey}, err
+}
+
 // Load3PlayConfigFromEnv loads 3play API Key/Secret from environment
 func Load3PlayConfigFromEnv() ThreePlayConfig {
 	var providerConfig ThreePlayConfig
@@ -48,10 +134,84 @@ func (c *ThreePlayProvider) GetName() string {
 	return providerName
 }
 
+// GetCapabilities returns the output formats, languages and params supported by 3play
+func (c *ThreePlayProvider) GetCapabilities() Capabilities {
+	return Capabilities{
+		Name: providerName,
+		OutputTypes: []string{
+			string(types.WebVTT),
+			string(types.SRT),
+			string(types.DFX),
+			string(types.SMI),
+			string(types.STL),
+			string(types.QT),
+			string(types.QTXML),
+			string(types.CPTXML),
+			string(types.ADBE),
+		},
+		Languages:   []string{"en"},
+		Cancellable: true,
+		Params: []ParamSpec{
+			{Name: "turnaround_level_id", Description: "3Play turnaround level, defaults to asr"},
+			{Name: "callback", Description: "URL 3Play notifies when the transcript status changes"},
+			{Name: "transcript_id", Description: "existing transcript to generate an editing link for"},
+			{Name: "hours_until_expiration", Description: "editing link expiration in hours, defaults to 2"},
+			{Name: "media_file_id", Description: "existing 3Play media file to order the transcript for"},
+			{Name: "reuse_media_file", Description: `"false" to upload the media even if an earlier job uploaded it`},
+		},
+		AdditionalParams: true,
+		Importable:       true,
+	}
+}
+
+// threePlayTurnaroundLevel returns the turnaround level a job is ordered with
+func threePlayTurnaroundLevel(params database.ProviderParams) (string, threePlayTurnaround, error) {
+	level := params["turnaround_level_id"]
+	if level == "" {
+		level = "asr"
+	}
+	turnaround, ok := threePlayTurnarounds[level]
+	if !ok {
+		return level, turnaround, fmt.Errorf("unknown turnaround level %q", level)
+	}
+	return level, turnaround, nil
+}
+
+// Turnaround returns the delivery time of the job turnaround level
+func (c *ThreePlayProvider) Turnaround(params database.ProviderParams) (time.Duration, error) {
+	_, turnaround, err := threePlayTurnaroundLevel(params)
+	if err != nil {
+		return 0, err
+	}
+	return turnaround.turnaround, nil
+}
+
+// Estimate prices a transcript from the fidelity and surcharge of its turnaround level
+func (c *ThreePlayProvider) Estimate(req EstimateRequest) (*Estimate, error) {
+	level, turnaround, err := threePlayTurnaroundLevel(req.Params)
+	if err != nil {
+		return nil, err
+	}
+	rate := threePlayFidelityRates[turnaround.fidelity] + turnaround.surcharge
+	return &Estimate{
+		Cost:       perMinuteCost(req.Duration, rate),
+		Currency:   "USD",
+		Turnaround: turnaround.turnaround,
+		Details:    fmt.Sprintf("%s fidelity, turnaround level %s, %.2f USD per minute", turnaround.fidelity, level, rate),
+	}, nil
+}
+
 // Download downloads captions file from specified type
-func (c *ThreePlayProvider) Download(job *database.Job, captionsType string) ([]byte, error) {
-	callParams := threeplay.CallParams{APIKey: c.config.APIKeyByJobType[job.JobType]}
-	transcript, err := c.GetTranscriptText(job.GetProviderID(), "", types.CaptionsFormat(captionsType), callParams)
+func (c *ThreePlayProvider) Download(ctx context.Context, job *database.Job, captionsType string) ([]byte, error) {
+	callParams, err := c.callParams(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	var transcript string
+	err = withContext(ctx, func() (err error) {
+		transcript, err = c.GetTranscriptText(job.GetProviderID(), "", types.CaptionsFormat(captionsType), callParams)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -59,39 +219,57 @@ func (c *ThreePlayProvider) Download(job *database.Job, captionsType string) ([]
 }
 
 // GetProviderJob returns a 3play file
-func (c *ThreePlayProvider) GetProviderJob(job *database.Job) (*database.ProviderJob, error) {
-	callParams := threeplay.CallParams{APIKey: c.config.APIKeyByJobType[job.JobType]}
-	file, err := c.GetTranscriptInfo(job.GetProviderID(), callParams)
+func (c *ThreePlayProvider) GetProviderJob(ctx context.Context, job *database.Job) (*database.ProviderJob, error) {
+	callParams, err := c.callParams(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	var file *threeplay.TranscriptObjectRepresentation
+	err = withContext(ctx, func() (err error) {
+		file, err = c.GetTranscriptInfo(job.GetProviderID(), callParams)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
 	providerJob := &database.ProviderJob{
 		ID:          strconv.Itoa(file.ID),
-		Status:      file.Status,
+		Status:      threePlayStatus(file.Status),
+		RawStatus:   file.Status,
 		Details:     file.Type,
 		Cancellable: file.Cancellable,
 	}
+	providerJob.Payload, _ = json.Marshal(file)
+	if file.MediaFileID != 0 {
+		providerJob.Params = map[string]string{"MediaFileID": strconv.Itoa(file.MediaFileID)}
+	}
 	return providerJob, nil
 }
 
 // DispatchJob sends a video file to 3play for transcription and captions generation or generates a expiring editing link
 // when the media_file_url param is provided
-func (c *ThreePlayProvider) DispatchJob(job *database.Job) error {
+func (c *ThreePlayProvider) DispatchJob(ctx context.Context, job *database.Job) error {
 	jobLogger := c.logger.WithFields(log.Fields{"JobID": job.ID, "Provider": job.Provider})
-	callParams := threeplay.CallParams{APIKey: c.config.APIKeyByJobType[job.JobType]}
+	callParams, err := c.callParams(ctx, job)
+	if err != nil {
+		return err
+	}
 
 	// Review job route
 	if transcriptID, ok := job.ProviderParams["transcript_id"]; ok {
 		hoursInt := 2
-		var err error
 		if hoursUntilExpiration, ok := job.ProviderParams["hours_until_expiration"]; ok {
 			hoursInt, err = strconv.Atoi(hoursUntilExpiration)
 			if err != nil {
 				jobLogger.WithError(err).Error("Could not convert hours until expiration")
 			}
 		}
-		reviewURL, err := c.GetEditingLink(transcriptID, hoursInt, callParams)
+		var reviewURL string
+		err = withContext(ctx, func() (err error) {
+			reviewURL, err = c.GetEditingLink(transcriptID, hoursInt, callParams)
+			return err
+		})
 		if err != nil {
 			jobLogger.WithError(err).Error("Could not generate review url")
 			return err
@@ -110,6 +288,7 @@ func (c *ThreePlayProvider) DispatchJob(job *database.Job) error {
 		switch k {
 		case "turnaround_level_id":
 			turnaroundLevel = v
+		case "media_file_id", "reuse_media_file":
 		case "callback":
 			url, err := url.Parse(v)
 			if err != nil {
@@ -123,32 +302,122 @@ func (c *ThreePlayProvider) DispatchJob(job *database.Job) error {
 			query.Add(k, v)
 		}
 	}
-	fileID, err := c.UploadFileFromURL(query, callParams)
+	mediaFileID := job.ProviderParams["media_file_id"]
+	if mediaFileID == "" && job.ProviderParams["reuse_media_file"] != "false" {
+		mediaFileID = c.findMediaFile(ctx, job)
+	}
+	reused := mediaFileID != ""
+	if reused {
+		jobLogger.WithField("MediaFileID", mediaFileID).Info("Reusing 3Play media file")
+	} else {
+		var fileID int
+		err := withContext(ctx, func() (err error) {
+			fileID, err = c.UploadFileFromURL(query, callParams)
+			return err
+		})
 
-	if err != nil {
-		jobLogger.Error("Failed to upload file to 3Play: ", err)
-		return err
+		if err != nil {
+			jobLogger.Error("Failed to upload file to 3Play: ", err)
+			return err
+		}
+		mediaFileID = strconv.Itoa(fileID)
 	}
 
-	transcriptResponse, err := c.OrderTranscript(strconv.Itoa(fileID), callbackURL, turnaroundLevel, callParams)
+	var transcriptResponse *threeplay.TranscriptObjectRepresentation
+	err = withContext(ctx, func() (err error) {
+		transcriptResponse, err = c.OrderTranscript(mediaFileID, callbackURL, turnaroundLevel, callParams)
+		return err
+	})
 	if err != nil {
 		jobLogger.Error("Failed to order caption: ", err)
 		return err
 	}
 
 	job.ProviderParams["ProviderID"] = strconv.Itoa(transcriptResponse.ID)
+	job.ProviderParams["MediaFileID"] = mediaFileID
+	job.ProviderParams["MediaFileReused"] = strconv.FormatBool(reused)
 	return nil
 }
 
+// findMediaFile returns the most recent media file uploaded to 3Play for the
+// job media by an earlier job of the same type and tenant. Jobs of the same parent are
+// matched ignoring the media URL query string, which changes for signed URLs.
+func (c *ThreePlayProvider) findMediaFile(ctx context.Context, job *database.Job) string {
+	if c.db == nil {
+		return ""
+	}
+	jobLogger := c.logger.WithFields(log.Fields{"JobID": job.ID, "Provider": job.Provider})
+	jobs, err := c.db.GetJobsByMediaURL(ctx, job.MediaURL)
+	if err != nil && err != database.ErrNoJobs {
+		jobLogger.WithError(err).Warn("Could not look up jobs with the same media")
+	}
+	if len(jobs) == 0 && job.ParentID != "" {
+		siblings, err := c.db.GetJobs(ctx, job.ParentID)
+		if err != nil && err != database.ErrNoJobs {
+			jobLogger.WithError(err).Warn("Could not look up jobs with the same parent")
+		}
+		for _, sibling := range siblings {
+			if mediaLocation(sibling.MediaURL) == mediaLocation(job.MediaURL) {
+				jobs = append(jobs, sibling)
+			}
+		}
+	}
+
+	sort.Sort(sort.Reverse(database.ByCreatedAt(jobs)))
+	for _, previous := range jobs {
+		// media files belong to the 3Play account of the tenant that uploaded them
+		if previous.ID == job.ID || previous.Provider != providerName || previous.JobType != job.JobType || previous.Tenant != job.Tenant {
+			continue
+		}
+		if mediaFileID := previous.ProviderParams["MediaFileID"]; mediaFileID != "" {
+			return mediaFileID
+		}
+	}
+	return ""
+}
+
+// mediaLocation returns a media URL without its query string
+func mediaLocation(mediaURL string) string {
+	u, err := url.Parse(mediaURL)
+	if err != nil {
+		return mediaURL
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+	return u.String()
+}
+
+// ParseCallback parses a 3play transcript callback. DispatchJob adds the job_id
+// query param to the callback URL, older jobs are resolved by transcript ID.
+func (c *ThreePlayProvider) ParseCallback(query url.Values, body []byte) (*Callback, error) {
+	var callback ThreePlayCallback
+	if err := json.Unmarshal(body, &callback); err != nil {
+		return nil, err
+	}
+	if callback.Data.ID == 0 {
+		return nil, ErrInvalidProviderID
+	}
+	return &Callback{
+		JobID:      query.Get("job_id"),
+		ProviderID: strconv.Itoa(callback.Data.ID),
+		Status:     callback.Data.Status,
+	}, nil
+}
+
 // CancelJob cancels a job if it is in a cancellable state
-func (c *ThreePlayProvider) CancelJob(job *database.Job) (bool, error) {
-	callParams := threeplay.CallParams{APIKey: c.config.APIKeyByJobType[job.JobType]}
-	providerJob, err := c.GetProviderJob(job)
+func (c *ThreePlayProvider) CancelJob(ctx context.Context, job *database.Job) (bool, error) {
+	callParams, err := c.callParams(ctx, job)
+	if err != nil {
+		return false, err
+	}
+	providerJob, err := c.GetProviderJob(ctx, job)
 	if err != nil {
 		return false, err
 	}
 	if providerJob.Cancellable {
-		err = c.CancelTranscript(providerJob.ID, callParams)
+		err = withContext(ctx, func() error {
+			return c.CancelTranscript(providerJob.ID, callParams)
+		})
 		if err != nil {
 			return providerJob.Cancellable, err
 		}
@@ -0,0 +1,219 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nytimes/threeplay/v3api"
+	"github.com/nytimes/video-captions-api/config"
+	"github.com/nytimes/video-captions-api/credentials"
+	"github.com/nytimes/video-captions-api/database"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeThreePlayAPI is an in-memory stand-in for the 3Play v3 API
+type fakeThreePlayAPI struct {
+	mtx     sync.Mutex
+	uploads int
+	orders  []url.Values
+}
+
+func (f *fakeThreePlayAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	r.ParseForm()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v3/files":
+		f.uploads++
+		fmt.Fprintf(w, `{"code": 200, "data": {"id": %d}}`, 500+f.uploads)
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/v3/transcripts/order/"):
+		f.orders = append(f.orders, r.PostForm)
+		fmt.Fprintf(w, `{"code": 200, "data": {"id": %d, "media_file_id": %s}}`, 700+len(f.orders), r.PostForm.Get("media_file_id"))
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/v3/transcripts/"):
+		fmt.Fprint(w, `{"code": 200, "data": {"id": 701, "media_file_id": 501, "status": "complete"}}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// rewriteTransport sends every request to the test server
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (t rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r.URL.Scheme = t.target.Scheme
+	r.URL.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func newTestThreePlayProvider(db database.DB) (*ThreePlayProvider, *fakeThreePlayAPI, *httptest.Server) {
+	api := &fakeThreePlayAPI{}
+	server := httptest.NewServer(api)
+	target, _ := url.Parse(server.URL)
+	cfg := &ThreePlayConfig{APIKeyByJobType: map[string]string{"captions": "key"}}
+	provider := New3PlayProvider(cfg, &config.CaptionsServiceConfig{Logger: log.New()}, db).(*ThreePlayProvider)
+	provider.Client = v3api.NewClientWithHTTPClient("key", &http.Client{Transport: rewriteTransport{target}})
+	return provider, api, server
+}
+
+func newThreePlayJob(id, mediaURL string, params map[string]string) *database.Job {
+	if params == nil {
+		params = map[string]string{}
+	}
+	return &database.Job{
+		ID:             id,
+		ParentID:       "parent",
+		MediaURL:       mediaURL,
+		Provider:       "3play",
+		JobType:        "captions",
+		ProviderParams: params,
+		CreatedAt:      time.Now(),
+	}
+}
+
+func TestThreePlayDispatchReusesMediaFile(t *testing.T) {
+	assert := assert.New(t)
+	db := database.NewMemoryDatabase()
+	provider, api, server := newTestThreePlayProvider(db)
+	defer server.Close()
+	ctx := context.Background()
+
+	first := newThreePlayJob("1", "http://vp.nyt.com/video.mp4?Signature=a", nil)
+	assert.Nil(provider.DispatchJob(ctx, first))
+	assert.Equal("501", first.ProviderParams["MediaFileID"])
+	assert.Equal("false", first.ProviderParams["MediaFileReused"])
+	db.StoreJob(ctx, first)
+
+	second := newThreePlayJob("2", "http://vp.nyt.com/video.mp4?Signature=b", map[string]string{"turnaround_level_id": "2"})
+	assert.Nil(provider.DispatchJob(ctx, second))
+	assert.Equal("501", second.ProviderParams["MediaFileID"])
+	assert.Equal("true", second.ProviderParams["MediaFileReused"])
+	assert.Equal("702", second.GetProviderID())
+
+	assert.Equal(1, api.uploads)
+	assert.Len(api.orders, 2)
+	assert.Equal("501", api.orders[1].Get("media_file_id"))
+	assert.Equal("2", api.orders[1].Get("turnaround_level_id"))
+}
+
+func TestThreePlayDispatchMediaFileParams(t *testing.T) {
+	assert := assert.New(t)
+	db := database.NewMemoryDatabase()
+	provider, api, server := newTestThreePlayProvider(db)
+	defer server.Close()
+	ctx := context.Background()
+	db.StoreJob(ctx, newThreePlayJob("1", "http://vp.nyt.com/video.mp4", map[string]string{"MediaFileID": "400"}))
+
+	explicit := newThreePlayJob("2", "http://vp.nyt.com/other.mp4", map[string]string{"media_file_id": "300"})
+	assert.Nil(provider.DispatchJob(ctx, explicit))
+	assert.Equal("300", explicit.ProviderParams["MediaFileID"])
+	assert.Equal("true", explicit.ProviderParams["MediaFileReused"])
+
+	forced := newThreePlayJob("3", "http://vp.nyt.com/video.mp4", map[string]string{"reuse_media_file": "false"})
+	assert.Nil(provider.DispatchJob(ctx, forced))
+	assert.Equal("501", forced.ProviderParams["MediaFileID"])
+	assert.Equal("false", forced.ProviderParams["MediaFileReused"])
+
+	otherType := newThreePlayJob("4", "http://vp.nyt.com/video.mp4", nil)
+	otherType.JobType = "transcript"
+	assert.Nil(provider.DispatchJob(ctx, otherType))
+	assert.Equal("502", otherType.ProviderParams["MediaFileID"])
+
+	provider.credentials = credentials.NewFileStore("testdata/credentials.yaml")
+	otherTenant := newThreePlayJob("5", "http://vp.nyt.com/video.mp4", nil)
+	otherTenant.Tenant = "opinion"
+	assert.Nil(provider.DispatchJob(ctx, otherTenant))
+	assert.Equal("503", otherTenant.ProviderParams["MediaFileID"])
+	assert.Equal("key", api.orders[0].Get("api_key"))
+	assert.Equal("opinion-key", api.orders[3].Get("api_key"))
+
+	assert.Equal(3, api.uploads)
+}
+
+func TestThreePlayCredentialOrder(t *testing.T) {
+	assert := assert.New(t)
+	provider, api, server := newTestThreePlayProvider(nil)
+	defer server.Close()
+	provider.credentials = credentials.NewFileStore("testdata/credentials.yaml")
+	ctx := context.Background()
+
+	// THREE_PLAY_API_KEY of the job type comes before the 3play credential of the store
+	assert.Nil(provider.DispatchJob(ctx, newThreePlayJob("1", "http://vp.nyt.com/1.mp4", nil)))
+	transcript := newThreePlayJob("2", "http://vp.nyt.com/2.mp4", nil)
+	transcript.JobType = "transcript"
+	assert.Nil(provider.DispatchJob(ctx, transcript))
+	opinion := newThreePlayJob("3", "http://vp.nyt.com/3.mp4", nil)
+	opinion.Tenant = "opinion"
+	assert.Nil(provider.DispatchJob(ctx, opinion))
+
+	assert.Equal("key", api.orders[0].Get("api_key"))
+	assert.Equal("store-key", api.orders[1].Get("api_key"))
+	assert.Equal("opinion-key", api.orders[2].Get("api_key"))
+}
+
+func TestThreePlayGetProviderJobMediaFile(t *testing.T) {
+	provider, _, server := newTestThreePlayProvider(nil)
+	defer server.Close()
+	job := newThreePlayJob("1", "http://vp.nyt.com/video.mp4", map[string]string{"ProviderID": "701"})
+	providerJob, err := provider.GetProviderJob(context.Background(), job)
+	assert.Nil(t, err)
+	assert.Equal(t, database.StatusComplete, providerJob.Status)
+	assert.Equal(t, "complete", providerJob.RawStatus)
+	assert.Equal(t, map[string]string{"MediaFileID": "501"}, providerJob.Params)
+}
+
+func TestThreePlayEstimate(t *testing.T) {
+	assert := assert.New(t)
+	provider := &ThreePlayProvider{}
+
+	estimate, err := provider.Estimate(EstimateRequest{Duration: 10 * time.Minute, Params: map[string]string{}})
+	assert.Nil(err)
+	assert.Equal(&Estimate{
+		Cost:       2.5,
+		Currency:   "USD",
+		Turnaround: time.Hour,
+		Details:    "MECHANICAL fidelity, turnaround level asr, 0.25 USD per minute",
+	}, estimate)
+
+	estimate, err = provider.Estimate(EstimateRequest{Duration: 90 * time.Second, Params: map[string]string{"turnaround_level_id": "3"}})
+	assert.Nil(err)
+	assert.Equal(6.0, estimate.Cost)
+	assert.Equal(24*time.Hour, estimate.Turnaround)
+
+	_, err = provider.Estimate(EstimateRequest{Duration: time.Minute, Params: map[string]string{"turnaround_level_id": "42"}})
+	assert.EqualError(err, `unknown turnaround level "42"`)
+}
+
+func TestThreePlayTurnaround(t *testing.T) {
+	assert := assert.New(t)
+	provider := &ThreePlayProvider{}
+
+	turnaround, err := provider.Turnaround(database.ProviderParams{})
+	assert.Nil(err)
+	assert.Equal(time.Hour, turnaround)
+
+	turnaround, err = provider.Turnaround(database.ProviderParams{"turnaround_level_id": "5"})
+	assert.Nil(err)
+	assert.Equal(2*time.Hour, turnaround)
+
+	_, err = provider.Turnaround(database.ProviderParams{"turnaround_level_id": "42"})
+	assert.EqualError(err, `unknown turnaround level "42"`)
+}
+
+func TestThreePlayStatus(t *testing.T) {
+	assert.Equal(t, database.StatusProcessing, threePlayStatus("in_progress"))
+	assert.Equal(t, database.StatusInReview, threePlayStatus("reviewing"))
+	assert.Equal(t, database.StatusCancelled, threePlayStatus("cancelled"))
+	assert.Equal(t, database.StatusError, threePlayStatus("failed"))
+	assert.Equal(t, database.JobStatus(""), threePlayStatus("on_hold"))
+}
@@ -2,8 +2,14 @@ package providers
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
 	"path/filepath"
+	"strconv"
+	"time"
 
 	captionsConfig "github.com/nytimes/video-captions-api/config"
 	"github.com/nytimes/video-captions-api/database"
@@ -30,9 +36,18 @@ func (c *UploadProvider) GetName() string {
 	return "upload"
 }
 
+// GetCapabilities returns the upload provider capabilities. Any output type is
+// accepted as the uploaded file is returned as-is.
+func (c *UploadProvider) GetCapabilities() Capabilities {
+	return Capabilities{
+		Name:        c.GetName(),
+		Cancellable: false,
+	}
+}
+
 // Download returns the uploaded caption file
-func (c *UploadProvider) Download(job *database.Job, captionsType string) ([]byte, error) {
-	job, err := c.DB.GetJob(job.GetProviderID())
+func (c *UploadProvider) Download(ctx context.Context, job *database.Job, captionsType string) ([]byte, error) {
+	job, err := c.DB.GetJob(ctx, job.GetProviderID())
 	if err != nil {
 		return nil, fmt.Errorf("could not find job in DB")
 	}
@@ -40,38 +55,105 @@ func (c *UploadProvider) Download(job *database.Job, captionsType string) ([]byt
 }
 
 // GetProviderJob returns the provider's job parameters.
-func (c *UploadProvider) GetProviderJob(job *database.Job) (*database.ProviderJob, error) {
-	job, err := c.DB.GetJob(job.GetProviderID())
+func (c *UploadProvider) GetProviderJob(ctx context.Context, job *database.Job) (*database.ProviderJob, error) {
+	job, err := c.DB.GetJob(ctx, job.GetProviderID())
 	if err != nil {
 		return nil, fmt.Errorf("could not find job in DB")
 	}
 	providerJob := &database.ProviderJob{
-		ID:      job.GetProviderID(),
-		Status:  job.ProviderParams["status"],
-		Details: job.ProviderParams["details"],
+		ID:        job.GetProviderID(),
+		Status:    database.JobStatus(job.ProviderParams["status"]),
+		RawStatus: job.ProviderParams["status"],
+		Details:   job.ProviderParams["details"],
 	}
 	return providerJob, nil
 }
 
 // DispatchJob sets the status of the upload job as delivered so
 // that a call to check the job status uploads it to the cloud.
-func (c *UploadProvider) DispatchJob(job *database.Job) error {
+func (c *UploadProvider) DispatchJob(_ context.Context, job *database.Job) error {
 	err := c.validateCaptionFile(&job.CaptionFile)
 
 	if err != nil {
 		return err
 	}
 
-	job.Status = "delivered"
+	job.Status = database.StatusDelivered
 	job.ProviderParams = map[string]string{
 		"ProviderID": job.ID,
-		"status":     "delivered",
-		"details":    "Version 1",
+		"status":     string(database.StatusDelivered),
 	}
+	setCaptionVersion(job, 1, time.Now())
 	return nil
 }
 
-func (c *UploadProvider) CancelJob(job *database.Job) (bool, error) {
+// UpdateCaption validates a new caption file and makes it the current version
+// of the job. Keeping the previous file is up to the caller.
+func (c *UploadProvider) UpdateCaption(_ context.Context, job *database.Job, file database.UploadedFile) error {
+	if len(file.File) == 0 {
+		return errors.New("caption file is empty")
+	}
+	if file.Name == "" {
+		file.Name = job.CaptionFile.Name
+	}
+	if err := c.validateCaptionFile(&file); err != nil {
+		return err
+	}
+
+	if job.Status != database.StatusDelivered && !job.UpdateStatus(database.StatusDelivered, "") {
+		return ErrCaptionNotUpdatable
+	}
+	version := CaptionVersion(job)
+	job.CaptionFile = file
+	job.ProviderParams["status"] = string(database.StatusDelivered)
+	setCaptionVersion(job, version+1, time.Now())
+	return nil
+}
+
+// CaptionVersion returns the version of the current caption file of an upload job
+func CaptionVersion(job *database.Job) int {
+	if version, err := strconv.Atoi(job.ProviderParams["Version"]); err == nil {
+		return version
+	}
+	return len(job.CaptionVersions) + 1
+}
+
+// CaptionUploadedAt returns when the current caption file of an upload job was uploaded
+func CaptionUploadedAt(job *database.Job) time.Time {
+	uploadedAt, err := time.Parse(time.RFC3339Nano, job.ProviderParams["UploadedAt"])
+	if err != nil {
+		return job.CreatedAt
+	}
+	return uploadedAt
+}
+
+func setCaptionVersion(job *database.Job, version int, uploadedAt time.Time) {
+	job.ProviderParams["Version"] = strconv.Itoa(version)
+	job.ProviderParams["UploadedAt"] = uploadedAt.UTC().Format(time.RFC3339Nano)
+	job.ProviderParams["details"] = "Version " + strconv.Itoa(version)
+}
+
+// ParseCallback lets upload jobs be refreshed through /callback/upload, the job
+// is identified by the job_id query param or a {"job_id": "..."} body.
+func (c *UploadProvider) ParseCallback(query url.Values, body []byte) (*Callback, error) {
+	jobID := query.Get("job_id")
+	if jobID == "" && len(body) > 0 {
+		var payload struct {
+			JobID string `json:"job_id"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			return nil, err
+		}
+		jobID = payload.JobID
+	}
+	if jobID == "" {
+		return nil, ErrInvalidProviderID
+	}
+	return &Callback{JobID: jobID, ProviderID: jobID}, nil
+}
+
+// CancelJob is a no-op as uploaded captions are delivered as soon as they are dispatched
+func (c *UploadProvider) CancelJob(_ context.Context, job *database.Job) (bool, error) {
 	return false, nil
 }
 
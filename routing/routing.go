@@ -0,0 +1,174 @@
+// Package routing picks the provider a captions job is dispatched to when the
+// request doesn't name one.
+package routing
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rule sends the jobs matching all of its conditions to Provider. Conditions
+// that are left empty match any job, so a rule without conditions acts as a
+// default. Every condition accepts a list of values, any of which can match.
+type Rule struct {
+	Name      string   `yaml:"name" json:"name"`
+	Provider  string   `yaml:"provider" json:"provider"`
+	Providers []string `yaml:"providers,omitempty" json:"providers,omitempty"`
+
+	JobTypes  []string `yaml:"job_type,omitempty" json:"job_type,omitempty"`
+	Languages []string `yaml:"language,omitempty" json:"language,omitempty"`
+	// ParentIDPrefixes matches the start of the job parent ID
+	ParentIDPrefixes []string `yaml:"parent_id_prefix,omitempty" json:"parent_id_prefix,omitempty"`
+	// MediaHosts matches the media URL host, "*.example.com" matches any subdomain
+	MediaHosts  []string `yaml:"media_host,omitempty" json:"media_host,omitempty"`
+	Turnarounds []string `yaml:"turnaround,omitempty" json:"turnaround,omitempty"`
+}
+
+// Input is the part of a job rules are evaluated against
+type Input struct {
+	JobType    string
+	Language   string
+	ParentID   string
+	MediaURL   string
+	Turnaround string
+}
+
+// Decision explains the outcome of routing a job
+type Decision struct {
+	Matched   bool       `json:"matched"`
+	Provider  string     `json:"provider,omitempty"`
+	Providers []string   `json:"providers,omitempty"`
+	Rule      string     `json:"rule,omitempty"`
+	Reasons   []string   `json:"reasons,omitempty"`
+	Skipped   []Mismatch `json:"skipped,omitempty"`
+}
+
+// Mismatch is a rule that was evaluated before the matching one, and why it didn't match
+type Mismatch struct {
+	Rule   string `json:"rule"`
+	Reason string `json:"reason"`
+}
+
+// Router evaluates rules in order, the first matching rule wins
+type Router struct {
+	rules []Rule
+}
+
+type rulesFile struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// NewRouter checks the rules and creates a Router. Unnamed rules are named
+// after their position.
+func NewRouter(rules []Rule) (*Router, error) {
+	checked := make([]Rule, len(rules))
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if rule.Provider == "" {
+			return nil, fmt.Errorf("%s: provider is required", rule.Name)
+		}
+		checked[i] = rule
+	}
+	return &Router{checked}, nil
+}
+
+// LoadRouter reads rules from a YAML file
+func LoadRouter(path string) (*Router, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file rulesFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid routing rules: %v", err)
+	}
+	if len(file.Rules) == 0 {
+		return nil, errors.New("invalid routing rules: no rules defined")
+	}
+	return NewRouter(file.Rules)
+}
+
+// Rules returns the router rules in evaluation order
+func (r *Router) Rules() []Rule {
+	return r.rules
+}
+
+// Route returns the provider of the first rule matching the input
+func (r *Router) Route(in Input) Decision {
+	var decision Decision
+	for _, rule := range r.rules {
+		reasons, mismatch := rule.match(in)
+		if mismatch != "" {
+			decision.Skipped = append(decision.Skipped, Mismatch{rule.Name, mismatch})
+			continue
+		}
+		decision.Matched = true
+		decision.Provider = rule.Provider
+		decision.Providers = rule.Providers
+		decision.Rule = rule.Name
+		decision.Reasons = reasons
+		break
+	}
+	return decision
+}
+
+// match returns why the rule matches the input, or the first condition it fails
+func (rule Rule) match(in Input) ([]string, string) {
+	conditions := []struct {
+		name    string
+		value   string
+		allowed []string
+		matches func(value, allowed string) bool
+	}{
+		{"job_type", in.JobType, rule.JobTypes, strings.EqualFold},
+		{"language", in.Language, rule.Languages, strings.EqualFold},
+		{"parent_id", in.ParentID, rule.ParentIDPrefixes, strings.HasPrefix},
+		{"media_host", mediaHost(in.MediaURL), rule.MediaHosts, matchHost},
+		{"turnaround", in.Turnaround, rule.Turnarounds, func(value, allowed string) bool { return value == allowed }},
+	}
+
+	var reasons []string
+	for _, condition := range conditions {
+		if len(condition.allowed) == 0 {
+			continue
+		}
+		matched := false
+		for _, allowed := range condition.allowed {
+			if condition.matches(condition.value, allowed) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, fmt.Sprintf("%s %q does not match %s", condition.name, condition.value, strings.Join(condition.allowed, ", "))
+		}
+		reasons = append(reasons, fmt.Sprintf("%s %q matches %s", condition.name, condition.value, strings.Join(condition.allowed, ", ")))
+	}
+	if len(reasons) == 0 {
+		reasons = []string{"rule has no conditions"}
+	}
+	return reasons, ""
+}
+
+func mediaHost(mediaURL string) string {
+	u, err := url.Parse(mediaURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+func matchHost(host, pattern string) bool {
+	pattern = strings.ToLower(pattern)
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
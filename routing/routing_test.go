@@ -0,0 +1,78 @@
+package routing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoute(t *testing.T) {
+	router, err := LoadRouter("testdata/rules.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		input    Input
+		provider string
+		rule     string
+		skipped  int
+	}{
+		{"Language", Input{Language: "ES", JobType: "asr"}, "amara", "spanish", 0},
+		{"Job type", Input{Language: "en", JobType: "asr"}, "3play", "asr", 1},
+		{"Turnaround", Input{Language: "en", Turnaround: "6"}, "rev", "rush", 2},
+		{"Parent ID and media host", Input{ParentID: "cooking-123", MediaURL: "https://vp.nyt.com/video.mp4"}, "command", "cooking", 3},
+		{"Media host mismatch", Input{ParentID: "cooking-123", MediaURL: "https://example.com/video.mp4"}, "3play", "default", 4},
+		{"Default", Input{Language: "en"}, "3play", "default", 4},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			decision := router.Route(test.input)
+			assert.True(t, decision.Matched)
+			assert.Equal(t, test.provider, decision.Provider)
+			assert.Equal(t, test.rule, decision.Rule)
+			assert.Len(t, decision.Skipped, test.skipped)
+		})
+	}
+}
+
+func TestRouteExplain(t *testing.T) {
+	assert := assert.New(t)
+	router, _ := LoadRouter("testdata/rules.yaml")
+	decision := router.Route(Input{Language: "en", ParentID: "cooking-123", MediaURL: "https://static01.nyt.com/video.mp4"})
+	assert.Equal(Decision{
+		Matched:  true,
+		Provider: "command",
+		Rule:     "cooking",
+		Reasons: []string{
+			`parent_id "cooking-123" matches cooking-`,
+			`media_host "static01.nyt.com" matches *.nyt.com`,
+		},
+		Skipped: []Mismatch{
+			{"spanish", `language "en" does not match es`},
+			{"asr", `job_type "" does not match asr`},
+			{"rush", `turnaround "" does not match 5, 6`},
+		},
+	}, decision)
+
+	decision = router.Route(Input{Turnaround: "5"})
+	assert.Equal("rev", decision.Provider)
+	assert.Equal([]string{"3play"}, decision.Providers)
+}
+
+func TestRouteNoMatch(t *testing.T) {
+	router, err := NewRouter([]Rule{{Provider: "amara", Languages: []string{"es"}}})
+	assert.Nil(t, err)
+	decision := router.Route(Input{Language: "en"})
+	assert.False(t, decision.Matched)
+	assert.Equal(t, []Mismatch{{"rule 1", `language "en" does not match es`}}, decision.Skipped)
+}
+
+func TestNewRouterInvalid(t *testing.T) {
+	_, err := NewRouter([]Rule{{Name: "spanish", Languages: []string{"es"}}})
+	assert.EqualError(t, err, "spanish: provider is required")
+
+	_, err = LoadRouter("testdata/missing.yaml")
+	assert.NotNil(t, err)
+}
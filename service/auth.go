@@ -0,0 +1,111 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// callbackTokenParam is the query param holding a callback shared secret
+	callbackTokenParam = "token"
+
+	// signatureHeader holds a t=<unix timestamp>,v1=<hex HMAC-SHA256> signature of
+	// "<timestamp>.<body>" computed with a shared secret
+	signatureHeader = "X-Captions-Signature"
+)
+
+var (
+	// ErrCallbackUnauthorized indicates that a callback has no valid token or signature
+	ErrCallbackUnauthorized = errors.New("invalid callback credentials")
+
+	// ErrCallbackExpired indicates that a signed callback is outside the accepted time window
+	ErrCallbackExpired = errors.New("callback signature timestamp is outside the tolerance window")
+)
+
+// callbackSecret returns the shared secret for a provider's callbacks, falling
+// back to CallbackAPIKey when no provider specific secret is configured
+func (c Client) callbackSecret(providerName string) string {
+	if secret, ok := c.CallbackSecrets[providerName]; ok {
+		return secret
+	}
+	return c.CallbackAPIKey
+}
+
+// VerifyCallback checks that a callback carries either a valid signature header
+// or a valid token query param. Callbacks are accepted as-is when no secret is
+// configured for the provider.
+func (c Client) VerifyCallback(providerName string, query url.Values, header http.Header, body []byte, now time.Time) error {
+	secret := c.callbackSecret(providerName)
+	if secret == "" {
+		return nil
+	}
+
+	if signature := header.Get(signatureHeader); signature != "" {
+		return verifySignature(secret, signature, body, now, c.CallbackTolerance)
+	}
+
+	token := query.Get(callbackTokenParam)
+	if token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1 {
+		return nil
+	}
+	return ErrCallbackUnauthorized
+}
+
+// signPayload returns the signature header value for body at the given time
+func signPayload(secret string, timestamp time.Time, body []byte) string {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", ts, computeSignature(secret, ts, body))
+}
+
+func computeSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func verifySignature(secret, signature string, body []byte, now time.Time, tolerance time.Duration) error {
+	var timestamp, digest string
+	for _, part := range strings.Split(signature, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			digest = kv[1]
+		}
+	}
+	if timestamp == "" || digest == "" {
+		return ErrCallbackUnauthorized
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrCallbackUnauthorized
+	}
+	if tolerance > 0 {
+		age := now.Sub(time.Unix(unix, 0))
+		if age > tolerance || age < -tolerance {
+			return ErrCallbackExpired
+		}
+	}
+
+	expected := computeSignature(secret, timestamp, body)
+	if !hmac.Equal([]byte(digest), []byte(expected)) {
+		return ErrCallbackUnauthorized
+	}
+	return nil
+}
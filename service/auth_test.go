@@ -0,0 +1,132 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/NYTimes/gizmo/server"
+	"github.com/nytimes/video-captions-api/database"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type verifyCallbackTest struct {
+	name     string
+	provider string
+	query    url.Values
+	header   http.Header
+	body     string
+	error    error
+}
+
+func TestVerifyCallback(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	body := `{"data": {"id": 11214314}}`
+	tests := []verifyCallbackTest{
+		{
+			name:     "No secret configured",
+			provider: "amara",
+			error:    nil,
+		},
+		{
+			name:     "Valid token",
+			provider: "3play",
+			query:    url.Values{"token": {"3play-secret"}},
+			error:    nil,
+		},
+		{
+			name:     "Default secret",
+			provider: "test-provider",
+			query:    url.Values{"token": {"default-secret"}},
+			error:    nil,
+		},
+		{
+			name:     "Wrong token",
+			provider: "3play",
+			query:    url.Values{"token": {"default-secret"}},
+			error:    ErrCallbackUnauthorized,
+		},
+		{
+			name:     "Missing credentials",
+			provider: "3play",
+			error:    ErrCallbackUnauthorized,
+		},
+		{
+			name:     "Valid signature",
+			provider: "3play",
+			header:   http.Header{signatureHeader: {signPayload("3play-secret", now.Add(-time.Minute), []byte(body))}},
+			body:     body,
+			error:    nil,
+		},
+		{
+			name:     "Tampered body",
+			provider: "3play",
+			header:   http.Header{signatureHeader: {signPayload("3play-secret", now, []byte(body))}},
+			body:     `{"data": {"id": 1}}`,
+			error:    ErrCallbackUnauthorized,
+		},
+		{
+			name:     "Replayed signature",
+			provider: "3play",
+			header:   http.Header{signatureHeader: {signPayload("3play-secret", now.Add(-10*time.Minute), []byte(body))}},
+			body:     body,
+			error:    ErrCallbackExpired,
+		},
+		{
+			name:     "Malformed signature",
+			provider: "3play",
+			header:   http.Header{signatureHeader: {"v1=abc"}},
+			body:     body,
+			error:    ErrCallbackUnauthorized,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			_, client := createCaptionsService("")
+			client.CallbackAPIKey = "default-secret"
+			client.CallbackSecrets = map[string]string{"3play": "3play-secret", "amara": ""}
+			client.CallbackTolerance = 5 * time.Minute
+			header := test.header
+			if header == nil {
+				header = http.Header{}
+			}
+			err := client.VerifyCallback(test.provider, test.query, header, []byte(test.body), now)
+			assert.Equal(t, test.error, err)
+		})
+	}
+}
+
+func TestProcessCallbackUnauthorized(t *testing.T) {
+	assert := assert.New(t)
+	service, client := createCaptionsService("")
+	service.client.CallbackAPIKey = "secret"
+	service.AddProvider(fakeProvider{logger: client.Logger})
+	job := &database.Job{
+		ID:             "123",
+		MediaURL:       "http://vp.nyt.com/video.mp4",
+		Provider:       "test-provider",
+		ProviderParams: map[string]string{"ProviderID": "11214314"},
+	}
+	client.DB.StoreJob(context.Background(), job)
+	server := server.NewSimpleServer(&server.Config{})
+	server.Register(service)
+	body := []byte(`{"data": {"id": 11214314, "status": "complete"}}`)
+	failures := testutil.ToFloat64(callbackAuthFailures.WithLabelValues("test-provider"))
+
+	r, _ := http.NewRequest("POST", "/callback/test-provider?token=wrong", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(401, w.Code)
+	assert.Equal(failures+1, testutil.ToFloat64(callbackAuthFailures.WithLabelValues("test-provider")))
+
+	r, _ = http.NewRequest("POST", "/callback/test-provider?token=secret", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(200, w.Code)
+}
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nytimes/video-captions-api/providers"
+)
+
+var (
+	// ErrProviderUnavailable indicates that calls to a provider are suspended by its circuit breaker
+	ErrProviderUnavailable = errors.New("provider is unavailable, circuit breaker is open")
+
+	// ErrProviderRateLimited indicates that a provider call exceeded its rate limit
+	ErrProviderRateLimited = errors.New("provider rate limit exceeded")
+)
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// ProviderGuards protects providers with a circuit breaker and a token bucket
+// rate limiter per provider. A nil *ProviderGuards lets every call through.
+type ProviderGuards struct {
+	// Threshold is the number of consecutive failures opening a breaker, 0 disables breakers
+	Threshold int
+	// Cooldown is how long a breaker stays open before a trial call is let through
+	Cooldown time.Duration
+	// RateLimits is the number of calls per second allowed per provider, up to Burst at once
+	RateLimits map[string]float64
+	Burst      int
+
+	now      func() time.Time
+	mtx      sync.Mutex
+	breakers map[string]*breaker
+	buckets  map[string]*tokenBucket
+}
+
+// ProviderStatus is the breaker and rate limiter state of a provider
+type ProviderStatus struct {
+	Provider  string     `json:"provider"`
+	State     string     `json:"state"`
+	Failures  int        `json:"consecutive_failures"`
+	LastError string     `json:"last_error,omitempty"`
+	OpenedAt  *time.Time `json:"opened_at,omitempty"`
+	RetryAt   *time.Time `json:"retry_at,omitempty"`
+	RateLimit float64    `json:"rate_limit,omitempty"`
+}
+
+type breaker struct {
+	state     string
+	failures  int
+	lastError string
+	openedAt  time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewProviderGuards creates ProviderGuards
+func NewProviderGuards(threshold int, cooldown time.Duration, rateLimits map[string]float64, burst int) *ProviderGuards {
+	if burst < 1 {
+		burst = 1
+	}
+	return &ProviderGuards{
+		Threshold:  threshold,
+		Cooldown:   cooldown,
+		RateLimits: rateLimits,
+		Burst:      burst,
+		now:        time.Now,
+		breakers:   make(map[string]*breaker),
+		buckets:    make(map[string]*tokenBucket),
+	}
+}
+
+// Call runs fn unless the provider breaker is open or its rate limit is
+// exceeded, and records the outcome. Only transport errors, timeouts and 5xx
+// responses count as failures, calls cancelled by the caller and requests the
+// provider rejected don't.
+func (g *ProviderGuards) Call(ctx context.Context, provider string, fn func() error) error {
+	if g == nil {
+		return fn()
+	}
+	if err := g.allow(provider); err != nil {
+		providerCallsRejected.WithLabelValues(provider, rejectReason(err)).Inc()
+		return err
+	}
+	err := fn()
+	if err != nil && ctx.Err() == context.Canceled {
+		g.release(provider)
+		return err
+	}
+	g.record(provider, err)
+	return err
+}
+
+// Status returns the state of the given providers, sorted by name
+func (g *ProviderGuards) Status(providers []string) []ProviderStatus {
+	statuses := make([]ProviderStatus, 0, len(providers))
+	for _, provider := range providers {
+		status := ProviderStatus{Provider: provider, State: breakerClosed}
+		if g != nil {
+			g.mtx.Lock()
+			if b, ok := g.breakers[provider]; ok {
+				status.State = b.state
+				if b.state == breakerOpen && !g.now().Before(b.openedAt.Add(g.Cooldown)) {
+					status.State = breakerHalfOpen
+				}
+				status.Failures = b.failures
+				status.LastError = b.lastError
+				if b.state != breakerClosed {
+					openedAt := b.openedAt
+					retryAt := openedAt.Add(g.Cooldown)
+					status.OpenedAt = &openedAt
+					status.RetryAt = &retryAt
+				}
+			}
+			status.RateLimit = g.RateLimits[provider]
+			g.mtx.Unlock()
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Provider < statuses[j].Provider })
+	return statuses
+}
+
+func (g *ProviderGuards) allow(provider string) error {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	now := g.now()
+
+	b := g.breaker(provider)
+	switch b.state {
+	case breakerOpen:
+		if now.Before(b.openedAt.Add(g.Cooldown)) {
+			return ErrProviderUnavailable
+		}
+		// let a single trial call through, the others wait for its outcome
+		b.state = breakerHalfOpen
+	case breakerHalfOpen:
+		return ErrProviderUnavailable
+	}
+
+	if rate, ok := g.RateLimits[provider]; ok && rate > 0 {
+		bucket, ok := g.buckets[provider]
+		if !ok {
+			bucket = &tokenBucket{tokens: float64(g.Burst), last: now}
+			g.buckets[provider] = bucket
+		}
+		bucket.tokens += now.Sub(bucket.last).Seconds() * rate
+		if bucket.tokens > float64(g.Burst) {
+			bucket.tokens = float64(g.Burst)
+		}
+		bucket.last = now
+		if bucket.tokens < 1 {
+			if b.state == breakerHalfOpen {
+				b.state = breakerOpen
+			}
+			return ErrProviderRateLimited
+		}
+		bucket.tokens--
+	}
+	return nil
+}
+
+// release gives up a half-open trial call without recording an outcome
+func (g *ProviderGuards) release(provider string) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if b := g.breaker(provider); b.state == breakerHalfOpen {
+		b.state = breakerOpen
+	}
+}
+
+func (g *ProviderGuards) record(provider string, err error) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	b := g.breaker(provider)
+	if err == nil || !providerFailure(err) {
+		// the provider answered
+		b.state = breakerClosed
+		b.failures = 0
+		b.lastError = ""
+		return
+	}
+	b.failures++
+	b.lastError = err.Error()
+	if g.Threshold > 0 && (b.state == breakerHalfOpen || b.failures >= g.Threshold) {
+		b.state = breakerOpen
+		b.openedAt = g.now()
+	}
+}
+
+func (g *ProviderGuards) breaker(provider string) *breaker {
+	b, ok := g.breakers[provider]
+	if !ok {
+		b = &breaker{state: breakerClosed}
+		g.breakers[provider] = b
+	}
+	return b
+}
+
+// providerFailure tells whether an error means the provider is unhealthy rather
+// than the request being wrong, e.g. a 4xx response or an unknown job
+func providerFailure(err error) bool {
+	if status, ok := providers.ResponseStatus(err); ok {
+		return status >= 500
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+func rejectReason(err error) string {
+	if err == ErrProviderRateLimited {
+		return "rate_limited"
+	}
+	return "breaker_open"
+}
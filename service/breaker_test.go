@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/nytimes/video-captions-api/providers"
+	"github.com/stretchr/testify/assert"
+)
+
+// statusError is a vendor response error
+type statusError struct {
+	status int
+	msg    string
+}
+
+func (e statusError) Error() string   { return e.msg }
+func (e statusError) HTTPStatus() int { return e.status }
+
+func newTestGuards(threshold int, rateLimits map[string]float64, burst int) (*ProviderGuards, *time.Time) {
+	now := time.Unix(1600000000, 0)
+	guards := NewProviderGuards(threshold, time.Minute, rateLimits, burst)
+	guards.now = func() time.Time { return now }
+	return guards, &now
+}
+
+func TestProviderGuardsBreaker(t *testing.T) {
+	assert := assert.New(t)
+	guards, now := newTestGuards(2, nil, 1)
+	ctx := context.Background()
+	failing := func() error { return statusError{http.StatusServiceUnavailable, "vendor down"} }
+	calls := 0
+	working := func() error { calls++; return nil }
+
+	assert.EqualError(guards.Call(ctx, "3play", failing), "vendor down")
+	assert.Nil(guards.Call(ctx, "3play", working))
+	assert.EqualError(guards.Call(ctx, "3play", failing), "vendor down")
+	assert.Equal(breakerClosed, guards.Status([]string{"3play"})[0].State)
+	assert.EqualError(guards.Call(ctx, "3play", failing), "vendor down")
+
+	status := guards.Status([]string{"3play"})[0]
+	assert.Equal(breakerOpen, status.State)
+	assert.Equal(2, status.Failures)
+	assert.Equal("vendor down", status.LastError)
+	assert.Equal(now.Add(time.Minute), *status.RetryAt)
+	assert.Equal(ErrProviderUnavailable, guards.Call(ctx, "3play", working))
+	assert.Nil(guards.Call(ctx, "amara", working))
+	assert.Equal(2, calls)
+
+	// a failed trial call opens the breaker again
+	*now = now.Add(time.Minute)
+	assert.Equal(breakerHalfOpen, guards.Status([]string{"3play"})[0].State)
+	assert.EqualError(guards.Call(ctx, "3play", failing), "vendor down")
+	assert.Equal(ErrProviderUnavailable, guards.Call(ctx, "3play", working))
+
+	// a successful one closes it
+	*now = now.Add(time.Minute)
+	assert.Nil(guards.Call(ctx, "3play", working))
+	status = guards.Status([]string{"3play"})[0]
+	assert.Equal(breakerClosed, status.State)
+	assert.Equal(0, status.Failures)
+	assert.Nil(status.OpenedAt)
+}
+
+func TestProviderGuardsCancelledCall(t *testing.T) {
+	guards, _ := newTestGuards(1, nil, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := guards.Call(ctx, "3play", func() error { return ctx.Err() })
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, breakerClosed, guards.Status([]string{"3play"})[0].State)
+}
+
+func TestProviderGuardsRejectedRequests(t *testing.T) {
+	assert := assert.New(t)
+	guards, _ := newTestGuards(1, nil, 1)
+	ctx := context.Background()
+	for _, err := range []error{
+		statusError{http.StatusNotFound, "not found"},
+		providers.ValidationError{Provider: "3play", Message: "unsupported language"},
+		providers.ErrInvalidProviderID,
+		errors.New("404: not_found-file not found"),
+		fmt.Errorf("could not create video: %w", errors.New("status 400: invalid video_url")),
+	} {
+		failing := func() error { return err }
+		assert.Equal(err, guards.Call(ctx, "3play", failing))
+		assert.Equal(breakerClosed, guards.Status([]string{"3play"})[0].State, err.Error())
+	}
+}
+
+func TestProviderGuardsProviderFailures(t *testing.T) {
+	for _, err := range []error{
+		statusError{http.StatusBadGateway, "bad gateway"},
+		errors.New("500: internal-server error"),
+		fmt.Errorf("could not create video: %w", errors.New("status 503: unavailable")),
+		context.DeadlineExceeded,
+		&url.Error{Op: "Post", URL: "https://api.rev.com", Err: errors.New("connection refused")},
+	} {
+		guards, _ := newTestGuards(1, nil, 1)
+		failing := func() error { return err }
+		guards.Call(context.Background(), "3play", failing)
+		assert.Equal(t, breakerOpen, guards.Status([]string{"3play"})[0].State, err.Error())
+	}
+}
+
+func TestProviderGuardsRateLimit(t *testing.T) {
+	assert := assert.New(t)
+	guards, now := newTestGuards(0, map[string]float64{"3play": 2}, 2)
+	ctx := context.Background()
+	working := func() error { return nil }
+
+	assert.Nil(guards.Call(ctx, "3play", working))
+	assert.Nil(guards.Call(ctx, "3play", working))
+	assert.Equal(ErrProviderRateLimited, guards.Call(ctx, "3play", working))
+	assert.Nil(guards.Call(ctx, "amara", working))
+
+	*now = now.Add(500 * time.Millisecond)
+	assert.Nil(guards.Call(ctx, "3play", working))
+	assert.Equal(ErrProviderRateLimited, guards.Call(ctx, "3play", working))
+	assert.Equal(2.0, guards.Status([]string{"3play"})[0].RateLimit)
+}
+
+func TestProviderGuardsNil(t *testing.T) {
+	var guards *ProviderGuards
+	assert.EqualError(t, guards.Call(context.Background(), "3play", func() error { return errors.New("vendor down") }), "vendor down")
+	assert.Equal(t, []ProviderStatus{{Provider: "3play", State: breakerClosed}}, guards.Status([]string{"3play"}))
+}
@@ -2,19 +2,74 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"path"
 	"sort"
-	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/nytimes/video-captions-api/database"
 	"github.com/nytimes/video-captions-api/providers"
+	"github.com/nytimes/video-captions-api/routing"
 	log "github.com/sirupsen/logrus"
 )
 
+var (
+	// ErrCallbacksNotSupported indicates that a provider doesn't implement providers.CallbackHandler
+	ErrCallbacksNotSupported = errors.New("provider does not support callbacks")
+
+	// ErrJobProviderMismatch indicates that a callback refers to a job from another provider
+	ErrJobProviderMismatch = errors.New("job does not belong to this provider")
+
+	// ErrCaptionUpdatesNotSupported indicates that a job provider doesn't implement providers.CaptionUpdater
+	ErrCaptionUpdatesNotSupported = errors.New("caption of this job cannot be updated")
+
+	// ErrCaptionVersionNotFound indicates that a job has no caption file with a given version
+	ErrCaptionVersionNotFound = errors.New("caption version not found")
+
+	// ErrImportNotSupported indicates that a provider can't adopt existing jobs
+	ErrImportNotSupported = errors.New("provider does not support importing jobs")
+
+	// ErrJobAlreadyImported indicates that a provider job is already managed by a job
+	ErrJobAlreadyImported = errors.New("provider job was already imported")
+
+	// ErrJobNotResubmittable indicates that a job didn't end in error
+	ErrJobNotResubmittable = errors.New("only jobs in error can be resubmitted")
+
+	// ErrEstimatesNotSupported indicates that a provider doesn't implement providers.Estimator
+	ErrEstimatesNotSupported = errors.New("provider does not support estimates")
+)
+
+// IndeterminateDispatchError is returned when a dispatch failed in a way that
+// doesn't tell whether the provider accepted the job, e.g. a timeout. The job
+// isn't dispatched to another provider, which could order it twice.
+type IndeterminateDispatchError struct {
+	Provider string
+	Err      error
+}
+
+func (e *IndeterminateDispatchError) Error() string {
+	return fmt.Sprintf("Error dispatching Job: outcome with %s is unknown, check whether it received the job before resubmitting it: %v", e.Provider, e.Err)
+}
+
+func (e *IndeterminateDispatchError) Unwrap() error { return e.Err }
+
+// indeterminate tells whether a failed dispatch may still have reached the provider
+func indeterminate(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
 // Client CaptionsService client
 type Client struct {
 	Providers      map[string]providers.Provider
@@ -23,11 +78,238 @@ type Client struct {
 	Storage        Storage
 	CallbackURL    string
 	CallbackAPIKey string
+	// CallbackSecrets overrides CallbackAPIKey per provider, CallbackTolerance
+	// bounds the age of signed callbacks
+	CallbackSecrets   map[string]string
+	CallbackTolerance time.Duration
+	// ProviderTimeout bounds every call made to a provider, ProviderTimeouts
+	// overrides it for specific providers
+	ProviderTimeout  time.Duration
+	ProviderTimeouts map[string]time.Duration
+	// DefaultProviders are tried in order for jobs that don't name a provider
+	DefaultProviders []string
+	// Router picks the provider of jobs that don't name one, before DefaultProviders
+	Router *routing.Router
+	// Guards holds the provider circuit breakers and rate limiters
+	Guards *ProviderGuards
+	// Queue dispatches the jobs created with EnqueueJob, jobs are dispatched
+	// synchronously when it is nil
+	Queue *DispatchQueue
+	// OutputMaxAttempts is how many times an output is downloaded and stored
+	// before it is marked as failed, 0 retries forever. Retries are delayed by
+	// OutputRetryBackoff, doubled after every failure.
+	OutputMaxAttempts  int
+	OutputRetryBackoff time.Duration
+	// ProviderTurnarounds is the delivery time of providers that aren't a
+	// providers.TurnaroundEstimator, jobs get their deadline from it
+	ProviderTurnarounds map[string]time.Duration
+	// WebhookSecret signs the webhook deliveries of subscriptions without
+	// their own secret and of job callback URLs. Deliveries are tried up to
+	// WebhookMaxAttempts times, 0 retries forever, with WebhookRetryBackoff
+	// doubled after every failure.
+	WebhookSecret       string
+	WebhookMaxAttempts  int
+	WebhookRetryBackoff time.Duration
+	WebhookTimeout      time.Duration
+	// Webhooks attempts webhook deliveries in the background, they are only
+	// attempted by RetryDeliveries when it is nil
+	Webhooks *WebhookSender
+}
+
+// providerContext returns a context bounded by the timeout configured for the provider
+func (c Client) providerContext(ctx context.Context, name string) (context.Context, context.CancelFunc) {
+	timeout := c.ProviderTimeout
+	if t, ok := c.ProviderTimeouts[name]; ok {
+		timeout = t
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// callProvider runs fn with a provider context, guarded by the provider circuit
+// breaker and rate limiter
+func (c Client) callProvider(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	return c.Guards.Call(ctx, name, func() error {
+		providerCtx, cancel := c.providerContext(ctx, name)
+		defer cancel()
+		return fn(providerCtx)
+	})
+}
+
+// GetProviderStatus returns the circuit breaker state of all registered providers
+func (c Client) GetProviderStatus() []ProviderStatus {
+	names := make([]string, 0, len(c.Providers))
+	for name := range c.Providers {
+		names = append(names, name)
+	}
+	return c.Guards.Status(names)
+}
+
+// GetProviders returns the capabilities of all registered providers sorted by name
+func (c Client) GetProviders() []providers.Capabilities {
+	capabilities := make([]providers.Capabilities, 0, len(c.Providers))
+	for _, provider := range c.Providers {
+		capabilities = append(capabilities, provider.GetCapabilities())
+	}
+	sort.Slice(capabilities, func(i, j int) bool { return capabilities[i].Name < capabilities[j].Name })
+	return capabilities
+}
+
+// GetProvider returns the capabilities of a provider given its name
+func (c Client) GetProvider(name string) (*providers.Capabilities, error) {
+	provider, ok := c.Providers[name]
+	if !ok {
+		return nil, providers.ErrProviderNotFound
+	}
+	capabilities := provider.GetCapabilities()
+	return &capabilities, nil
+}
+
+// ValidateJob checks a job's output types, language and provider params against
+// the capabilities of the providers it can be dispatched to. The job is valid if
+// any of them supports it, otherwise the first provider's error is returned.
+func (c Client) ValidateJob(job *database.Job) error {
+	var firstErr error
+	for _, name := range c.dispatchCandidates(job) {
+		err := c.validateJobFor(job, name)
+		if err == nil {
+			return nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		return providers.ErrProviderNotFound
+	}
+	return firstErr
+}
+
+func (c Client) validateJobFor(job *database.Job, providerName string) error {
+	provider, ok := c.Providers[providerName]
+	if !ok {
+		return providers.ErrProviderNotFound
+	}
+	outputTypes := make([]string, len(job.Outputs))
+	for i, output := range job.Outputs {
+		outputTypes[i] = output.Type
+	}
+	return provider.GetCapabilities().Validate(outputTypes, job.Language, job.ProviderParams)
+}
+
+// JobEstimate is the estimated cost and delivery time of a job with a provider,
+// Error explains why the provider couldn't estimate it
+type JobEstimate struct {
+	Provider string     `json:"provider"`
+	Cost     float64    `json:"cost"`
+	Currency string     `json:"currency,omitempty"`
+	ETA      *time.Time `json:"eta,omitempty"`
+	Details  string     `json:"details,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// EstimateJob estimates a job of the given media duration with every provider it
+// could be dispatched to, or with every provider supporting estimates when it
+// doesn't name one. Nothing is dispatched.
+func (c Client) EstimateJob(job *database.Job, duration time.Duration) []JobEstimate {
+	candidates := c.dispatchCandidates(job)
+	if len(candidates) == 0 {
+		for name, provider := range c.Providers {
+			if _, ok := provider.(providers.Estimator); ok {
+				candidates = append(candidates, name)
+			}
+		}
+		sort.Strings(candidates)
+	}
+
+	now := time.Now().UTC()
+	estimates := make([]JobEstimate, 0, len(candidates))
+	for _, name := range candidates {
+		estimate := JobEstimate{Provider: name}
+		result, err := c.estimateJobWith(job, name, duration)
+		if err != nil {
+			estimate.Error = err.Error()
+		} else {
+			eta := now.Add(result.Turnaround)
+			estimate.Cost = result.Cost
+			estimate.Currency = result.Currency
+			estimate.ETA = &eta
+			estimate.Details = result.Details
+		}
+		estimates = append(estimates, estimate)
+	}
+	return estimates
+}
+
+func (c Client) estimateJobWith(job *database.Job, name string, duration time.Duration) (*providers.Estimate, error) {
+	if err := c.validateJobFor(job, name); err != nil {
+		return nil, err
+	}
+	estimator, ok := c.Providers[name].(providers.Estimator)
+	if !ok {
+		return nil, ErrEstimatesNotSupported
+	}
+	return estimator.Estimate(providers.EstimateRequest{
+		Duration: duration,
+		JobType:  job.JobType,
+		Language: job.Language,
+		Params:   job.ProviderParams,
+	})
+}
+
+// RouteJob sets the provider of a job that doesn't name one from the first
+// matching routing rule. The routing decision is returned when rules were evaluated.
+func (c Client) RouteJob(job *database.Job) *routing.Decision {
+	if c.Router == nil || job.Provider != "" || len(job.Providers) > 0 {
+		return nil
+	}
+	decision := c.Router.Route(routingInput(job))
+	jobLogger := c.Logger.WithFields(log.Fields{"JobID": job.ID})
+	if !decision.Matched {
+		jobLogger.Info("No routing rule matched the job")
+		return &decision
+	}
+	jobLogger.WithFields(log.Fields{"Provider": decision.Provider, "Rule": decision.Rule}).Info("Routed job")
+	job.Provider = decision.Provider
+	job.Providers = decision.Providers
+	return &decision
+}
+
+// routingInput returns the job fields routing rules are evaluated against
+func routingInput(job *database.Job) routing.Input {
+	return routing.Input{
+		JobType:    job.JobType,
+		Language:   job.Language,
+		ParentID:   job.ParentID,
+		MediaURL:   job.MediaURL,
+		Turnaround: job.ProviderParams["turnaround_level_id"],
+	}
+}
+
+// dispatchCandidates returns the providers a job can be dispatched to, in order:
+// the job provider followed by its providers list, or DefaultProviders if it
+// names none
+func (c Client) dispatchCandidates(job *database.Job) []string {
+	var candidates []string
+	if job.Provider != "" {
+		candidates = append(candidates, job.Provider)
+	}
+	for _, name := range job.Providers {
+		if name != job.Provider {
+			candidates = append(candidates, name)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = append(candidates, c.DefaultProviders...)
+	}
+	return candidates
 }
 
 // GetJobs gets all jobs associated with a ParentID
-func (c Client) GetJobs(parentID string) ([]*database.JobSummary, error) {
-	jobs, err := c.DB.GetJobs(parentID)
+func (c Client) GetJobs(ctx context.Context, parentID string) ([]*database.JobSummary, error) {
+	jobs, err := c.DB.GetJobs(ctx, parentID)
 	if err != nil {
 		c.Logger.Errorf("Error loading jobs from DB for parent ID %s: %v", parentID, err)
 		return nil, err
@@ -41,28 +323,56 @@ func (c Client) GetJobs(parentID string) ([]*database.JobSummary, error) {
 }
 
 // GetJob gets a job by ID
-func (c Client) GetJob(jobID string) (*database.Job, error) {
-	job, err := c.DB.GetJob(jobID)
+func (c Client) GetJob(ctx context.Context, jobID string) (*database.Job, error) {
+	job, err := c.DB.GetJob(ctx, jobID)
 	if err != nil {
 		c.Logger.Error("Could not find Job in database")
 		return nil, err
 	}
 
-	if job.Done {
+	if job.Done || job.Status == database.StatusDispatching {
 		return job, nil
 	}
+	if job.Status == database.StatusQueued {
+		queued := *job
+		queued.QueuePosition = c.queuePosition(ctx, job)
+		return &queued, nil
+	}
 
 	providerID := job.GetProviderID()
 	fields := log.Fields{"JobID": jobID, "Provider": job.Provider, "ProviderID": providerID}
 	jobLogger := c.Logger.WithFields(fields)
-	provider := c.Providers[job.Provider]
 	jobLogger.Info("Fetching job from Provider")
-	providerJob, err := provider.GetProviderJob(job)
+	providerJob, err := c.fetchProviderJob(ctx, job)
+	if err == ErrProviderUnavailable || err == ErrProviderRateLimited {
+		jobLogger.WithError(err).Warn("Serving the last known job state")
+		return job, nil
+	}
 	if err != nil {
 		jobLogger.Error("error getting job from provider: ", err)
 		return nil, err
 	}
 
+	return job, c.syncJob(ctx, job, providerJob, jobLogger)
+}
+
+// fetchProviderJob gets the current state of a job from its provider
+func (c Client) fetchProviderJob(ctx context.Context, job *database.Job) (*database.ProviderJob, error) {
+	provider, ok := c.Providers[job.Provider]
+	if !ok {
+		return nil, providers.ErrProviderNotFound
+	}
+	var providerJob *database.ProviderJob
+	err := c.callProvider(ctx, job.Provider, func(ctx context.Context) (err error) {
+		providerJob, err = provider.GetProviderJob(ctx, job)
+		return err
+	})
+	return providerJob, err
+}
+
+// syncJob updates a stored job with its state on the provider, storing its
+// outputs once the provider is done with it
+func (c Client) syncJob(ctx context.Context, job *database.Job, providerJob *database.ProviderJob, jobLogger *log.Entry) error {
 	params := providerJob.Params
 
 	shouldUpdate := false
@@ -74,59 +384,584 @@ func (c Client) GetJob(jobID string) (*database.Job, error) {
 		}
 	}
 
-	if job.UpdateStatus(providerJob.Status, providerJob.Details) || shouldUpdate {
-		err = c.DB.UpdateJob(jobID, job)
+	providerStatusChanged := providerJob.RawStatus != "" && providerJob.RawStatus != job.ProviderStatus
+	if providerStatusChanged {
+		job.ProviderStatus = providerJob.RawStatus
+		shouldUpdate = true
+	}
+	if !providerJob.Status.Known() {
+		jobLogger.WithField("ProviderStatus", providerJob.RawStatus).Warn("Provider returned an unknown job status")
+	} else if !job.Status.CanTransitionTo(providerJob.Status) {
+		jobLogger.WithFields(log.Fields{"From": job.Status, "To": providerJob.Status}).Warn("Ignoring illegal job status transition")
 	}
 
-	if (job.Status == "complete" || job.Status == "delivered") && !job.Done {
+	var err error
+	statusChanged := job.UpdateStatus(providerJob.Status, providerJob.Details)
+	if statusChanged || shouldUpdate {
+		err = c.saveSync(ctx, job, func(stored *database.Job) {
+			if stored.ProviderParams == nil {
+				stored.ProviderParams = make(database.ProviderParams)
+			}
+			for k, v := range params {
+				stored.ProviderParams[k] = v
+			}
+			if providerStatusChanged {
+				stored.ProviderStatus = providerJob.RawStatus
+			}
+			if statusChanged {
+				stored.UpdateStatus(providerJob.Status, providerJob.Details)
+			}
+		})
+		// the stored job may have moved to a status it can't leave meanwhile
+		statusChanged = statusChanged && job.Status == providerJob.Status
+	}
+	if statusChanged || providerStatusChanged {
+		c.recordEvent(ctx, job, database.JobEvent{
+			Type:           database.JobEventStatusChanged,
+			ProviderStatus: providerJob.RawStatus,
+			Details:        providerJob.Details,
+			Payload:        string(providerJob.Payload),
+		})
+	}
+	if statusChanged {
+		c.notifyStatus(ctx, job)
+	}
+
+	if job.Status.IsReady() && !job.Done {
 		jobLogger.Info("Job is ready on the provider, downloading")
-		for i, output := range job.Outputs {
-			data, err := provider.Download(job, output.Type)
-			if err != nil {
-				jobLogger.WithError(err).Error("Failed to download file")
-				return job, nil
+		// failed attempts are stored on the outputs, the job is done once
+		// every output is stored or failed for good
+		done := c.storeOutputs(ctx, job, jobLogger) == nil
+		outputs := job.Outputs
+		err = c.saveSync(ctx, job, func(stored *database.Job) {
+			stored.Outputs = outputs
+			stored.Done = stored.Done || done
+		})
+		if done {
+			c.notify(ctx, job, EventCompleted)
+		}
+	}
+	return err
+}
+
+// saveSync applies the changes syncJob made to a job to its stored copy, so
+// changes made to the job while its provider was called, e.g. a cancel, are
+// kept. job is replaced with the stored job.
+func (c Client) saveSync(ctx context.Context, job *database.Job, apply func(*database.Job)) error {
+	stored, err := c.DB.ModifyJob(ctx, job.ID, func(stored *database.Job) error {
+		apply(stored)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	*job = *stored
+	return nil
+}
+
+// ImportJob adopts a job ordered outside the API by its provider ID. The job
+// is stored with its current state on the provider and managed like any other.
+func (c Client) ImportJob(ctx context.Context, job *database.Job, providerID string) (*database.Job, error) {
+	jobLogger := c.Logger.WithFields(log.Fields{"JobID": job.ID, "Provider": job.Provider, "ProviderID": providerID})
+	provider, ok := c.Providers[job.Provider]
+	if !ok {
+		jobLogger.Error("provider not found")
+		return nil, providers.ErrProviderNotFound
+	}
+	if !provider.GetCapabilities().Importable {
+		return nil, ErrImportNotSupported
+	}
+	existing, err := c.DB.GetJobByProviderID(ctx, providerID)
+	if err == nil && existing.Provider == job.Provider {
+		jobLogger.WithField("ExistingJobID", existing.ID).Warn("Provider job was already imported")
+		return existing, ErrJobAlreadyImported
+	}
+	if err := c.validateJobFor(job, job.Provider); err != nil {
+		return nil, err
+	}
+
+	job.ProviderParams["ProviderID"] = providerID
+	jobLogger.Info("Fetching job to import from Provider")
+	providerJob, err := c.fetchProviderJob(ctx, job)
+	if err != nil {
+		jobLogger.WithError(err).Error("error getting job from provider")
+		return nil, fmt.Errorf("Error fetching job from %s: %v", job.Provider, err)
+	}
+	if _, err := c.DB.StoreJob(ctx, job); err != nil {
+		jobLogger.Errorf("Error storing job in DB: %v", err)
+		return nil, fmt.Errorf("Error storing Job: %v", err)
+	}
+	c.recordEvent(ctx, job, database.JobEvent{Type: database.JobEventImported, CreatedAt: job.CreatedAt, Details: providerID})
+	c.notify(ctx, job, EventCreated)
+	return job, c.syncJob(ctx, job, providerJob, jobLogger)
+}
+
+// errOutputsPending is returned by storeOutputs while outputs wait for their next retry
+var errOutputsPending = errors.New("outputs are waiting to be retried")
+
+// storeOutputs downloads the job outputs that aren't stored yet from their
+// provider and stores them. Failures are recorded on the outputs, which are
+// retried with exponential backoff until OutputMaxAttempts and then marked as
+// failed. It returns an error while outputs are left to retry.
+func (c Client) storeOutputs(ctx context.Context, job *database.Job, jobLogger *log.Entry) error {
+	var pending error
+	for i := range job.Outputs {
+		output := &job.Outputs[i]
+		if output.URL != "" || output.Failed {
+			continue
+		}
+		if time.Now().Before(output.NextRetryAt) {
+			if pending == nil {
+				pending = errOutputsPending
 			}
-			jobLogger.Info("Download done, storing")
-			dest, err := c.Storage.Store(data, fmt.Sprintf("%s/%s", job.Provider, output.Filename))
-			if err != nil {
-				jobLogger.WithError(err).Error("Failed to store file")
-				return job, nil
+			continue
+		}
+		err := c.storeOutput(ctx, job, output, jobLogger)
+		if err == ErrProviderUnavailable || err == ErrProviderRateLimited {
+			// the provider wasn't called, this isn't a failed attempt
+			pending = err
+			continue
+		}
+		output.Attempts++
+		if err == nil {
+			output.LastError = ""
+			output.NextRetryAt = time.Time{}
+			c.recordEvent(ctx, job, database.JobEvent{Type: database.JobEventOutputStored, Details: output.URL})
+			continue
+		}
+		output.LastError = err.Error()
+		c.recordEvent(ctx, job, database.JobEvent{
+			Type:    database.JobEventError,
+			Details: fmt.Sprintf("storing %s output failed: %v", output.Type, err),
+		})
+		outputLogger := jobLogger.WithFields(log.Fields{"Output": output.Type, "Attempts": output.Attempts})
+		if c.OutputMaxAttempts > 0 && output.Attempts >= c.OutputMaxAttempts {
+			outputLogger.Error("Giving up on output")
+			output.Failed = true
+			output.NextRetryAt = time.Time{}
+			continue
+		}
+		output.NextRetryAt = time.Now().Add(c.outputRetryDelay(output.Attempts))
+		outputLogger.WithField("NextRetryAt", output.NextRetryAt).Warn("Output will be retried")
+		pending = err
+	}
+	return pending
+}
+
+// storeOutput downloads an output from the job provider and stores it
+func (c Client) storeOutput(ctx context.Context, job *database.Job, output *database.JobOutput, jobLogger *log.Entry) error {
+	provider := c.Providers[job.Provider]
+	var data []byte
+	err := c.callProvider(ctx, job.Provider, func(ctx context.Context) error {
+		var err error
+		data, err = provider.Download(ctx, job, output.Type)
+		return err
+	})
+	if err != nil {
+		jobLogger.WithError(err).Error("Failed to download file")
+		return err
+	}
+	jobLogger.Info("Download done, storing")
+	dest, err := c.Storage.Store(ctx, data, fmt.Sprintf("%s/%s", job.Provider, output.Filename))
+	if err != nil {
+		jobLogger.WithError(err).Error("Failed to store file")
+		return err
+	}
+	output.URL = dest
+	return nil
+}
+
+// outputRetryDelay is the delay before the next attempt of an output that failed attempts times
+func (c Client) outputRetryDelay(attempts int) time.Duration {
+	return retryDelay(c.OutputRetryBackoff, attempts)
+}
+
+// retryDelay is backoff doubled for every failed attempt after the first one, up to a day
+func retryDelay(backoff time.Duration, attempts int) time.Duration {
+	delay := backoff
+	for i := 1; i < attempts && delay < 24*time.Hour; i++ {
+		delay *= 2
+	}
+	return delay
+}
+
+// UpdateCaption replaces the caption file of a job with a new version, stores
+// its outputs again and notifies CallbackURL. The replaced file is kept in
+// Storage, the job only references it so it stays small.
+func (c Client) UpdateCaption(ctx context.Context, jobID string, file database.UploadedFile) (*database.Job, error) {
+	job, err := c.DB.GetJob(ctx, jobID)
+	if err != nil {
+		c.Logger.Error("Could not find Job in database")
+		return nil, err
+	}
+	updater, ok := c.Providers[job.Provider].(providers.CaptionUpdater)
+	if !ok {
+		return nil, ErrCaptionUpdatesNotSupported
+	}
+	jobLogger := c.Logger.WithFields(log.Fields{"JobID": jobID, "Provider": job.Provider})
+	// the version is bumped on the stored job so concurrent updates each get
+	// their own version, providers serve the caption from the stored job
+	stored, err := c.DB.ModifyJob(ctx, jobID, func(job *database.Job) error {
+		job.ProviderParams = copyParams(job.ProviderParams)
+		previous := database.CaptionVersion{
+			Version:    providers.CaptionVersion(job),
+			Name:       job.CaptionFile.Name,
+			UploadedAt: providers.CaptionUploadedAt(job),
+		}
+		previousFile := job.CaptionFile.File
+		if err := updater.UpdateCaption(ctx, job, file); err != nil {
+			if err == providers.ErrCaptionNotUpdatable {
+				return err
 			}
-			job.Outputs[i].URL = dest
+			return providers.ValidationError{Provider: job.Provider, Message: err.Error()}
+		}
+		var err error
+		previous.URL, err = c.Storage.Store(ctx, previousFile, captionVersionFilename(job, previous))
+		if err != nil {
+			return fmt.Errorf("could not store the previous caption file: %w", err)
+		}
+		job.CaptionVersions = append(append([]database.CaptionVersion(nil), job.CaptionVersions...), previous)
+		job.Done = false
+		job.ResetOutputs()
+		return nil
+	})
+	if err != nil {
+		jobLogger.WithError(err).Error("Could not update caption file")
+		return nil, err
+	}
+	updated := *stored
+	job = &updated
+	version := providers.CaptionVersion(job)
+	jobLogger.WithField("Details", job.ProviderParams["details"]).Info("Caption file updated, storing outputs")
+	c.recordEvent(ctx, job, database.JobEvent{
+		Type:    database.JobEventCaptionUpdated,
+		Details: fmt.Sprintf("version %d", version),
+	})
+	job.Outputs = append([]database.JobOutput(nil), job.Outputs...)
+	storeErr := c.storeOutputs(ctx, job, jobLogger)
+	outputs := job.Outputs
+	// a newer version stores its own outputs
+	if err := c.saveSync(ctx, job, func(stored *database.Job) {
+		if providers.CaptionVersion(stored) == version {
+			stored.Outputs = outputs
+			stored.Done = storeErr == nil
+		}
+	}); err != nil {
+		return nil, err
+	}
+	if storeErr != nil {
+		return nil, storeErr
+	}
+	c.notify(ctx, job, EventCompleted)
+	if c.CallbackURL != "" {
+		if err := c.makeAPICall(ctx, job); err != nil {
+			jobLogger.Errorf("Encountered an error while making a callback call: %v", err)
+		}
+	}
+	return job, nil
+}
+
+// GetCaptionVersion returns a version of the caption file of a job, the current
+// one included. Only the current file is returned, earlier ones are in Storage
+// at their URL.
+func (c Client) GetCaptionVersion(ctx context.Context, jobID string, version int) (*database.CaptionVersion, []byte, error) {
+	job, err := c.DB.GetJob(ctx, jobID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, v := range job.CaptionVersions {
+		if v.Version == version {
+			return &v, nil, nil
+		}
+	}
+	if job.CaptionFile.File != nil && version == providers.CaptionVersion(job) {
+		current := &database.CaptionVersion{Version: version, Name: job.CaptionFile.Name, UploadedAt: providers.CaptionUploadedAt(job)}
+		return current, job.CaptionFile.File, nil
+	}
+	return nil, nil, ErrCaptionVersionNotFound
+}
+
+// captionVersionFilename is where a replaced caption file of a job is stored
+func captionVersionFilename(job *database.Job, version database.CaptionVersion) string {
+	name := path.Base(version.Name)
+	if version.Name == "" {
+		name = "caption"
+	}
+	return fmt.Sprintf("%s/%s/versions/%d/%s", job.Provider, job.ID, version.Version, name)
+}
+
+// DispatchJob dispatches a Job to the first of its candidate providers that
+// accepts it and stores it. Every attempt is recorded on the job, the job
+// provider is set to the one that accepted it and its deadline to the
+// turnaround of that provider. Jobs whose dispatch outcome is unknown are
+// stored in error, with an IndeterminateDispatchError.
+func (c Client) DispatchJob(ctx context.Context, job *database.Job) error {
+	if err := c.dispatch(ctx, job); err != nil {
+		var indeterminateErr *IndeterminateDispatchError
+		if errors.As(err, &indeterminateErr) {
+			c.storeIndeterminateJob(job, err)
+		}
+		return err
+	}
+	c.setDeadline(job, job.CreatedAt)
+
+	jobLogger := c.Logger.WithFields(log.Fields{"JobID": job.ID})
+	jobLogger.Info("Storing job in DB")
+	_, err := c.DB.StoreJob(ctx, job)
+	if err != nil {
+		jobLogger.Errorf("Error storing job in DB: %v", err)
+		return fmt.Errorf("Error storing Job: %v", err)
+	}
+	c.recordEvent(ctx, job, database.JobEvent{Type: database.JobEventCreated, CreatedAt: job.CreatedAt})
+	c.recordDispatchAttempts(ctx, job)
+	c.notify(ctx, job, EventCreated)
+	return nil
+}
+
+// storeIndeterminateJob stores a job the provider may have accepted in error, so
+// it can be reconciled with the provider
+func (c Client) storeIndeterminateJob(job *database.Job, err error) {
+	// the request context may be what timed out
+	ctx := context.Background()
+	job.Status = database.StatusError
+	job.Details = err.Error()
+	job.Done = true
+	if _, err := c.DB.StoreJob(ctx, job); err != nil {
+		c.Logger.WithField("JobID", job.ID).Errorf("Error storing job in DB: %v", err)
+		return
+	}
+	c.recordEvent(ctx, job, database.JobEvent{Type: database.JobEventCreated, CreatedAt: job.CreatedAt})
+	c.recordDispatchAttempts(ctx, job)
+	c.notify(ctx, job, EventFailed)
+}
+
+// Resubmission changes how a job is dispatched again, empty fields keep the
+// provider and params of the failed submission and empty ProviderParams values
+// drop them
+type Resubmission struct {
+	Provider       string
+	Turnaround     string
+	ProviderParams database.ProviderParams
+}
+
+// ResubmitJob dispatches a job in error again, keeping its ID. The failed
+// submission is archived in the job submissions.
+func (c Client) ResubmitJob(ctx context.Context, jobID string, resubmission Resubmission) (*database.Job, error) {
+	storedJob, err := c.DB.GetJob(ctx, jobID)
+	if err != nil {
+		c.Logger.Error("Could not find Job in database")
+		return nil, err
+	}
+	if storedJob.Status != database.StatusError {
+		return nil, ErrJobNotResubmittable
+	}
+	jobLogger := c.Logger.WithFields(log.Fields{"JobID": jobID, "Provider": storedJob.Provider})
+
+	// the stored job is left untouched until the resubmission is valid
+	resubmitted := *storedJob
+	job := &resubmitted
+	job.Outputs = append([]database.JobOutput(nil), storedJob.Outputs...)
+	job.Submissions = append(append([]database.Submission(nil), storedJob.Submissions...), database.Submission{
+		Provider:         job.Provider,
+		ProviderParams:   job.ProviderParams,
+		Status:           job.Status,
+		Details:          job.Details,
+		DispatchAttempts: job.DispatchAttempts,
+		ResubmittedAt:    time.Now(),
+	})
+	job.ProviderParams = requestedParams(job.ProviderParams)
+	for k, v := range resubmission.ProviderParams {
+		if v == "" {
+			delete(job.ProviderParams, k)
+			continue
 		}
+		job.ProviderParams[k] = v
+	}
+	if resubmission.Turnaround != "" {
+		job.ProviderParams["turnaround_level_id"] = resubmission.Turnaround
+	}
+	if resubmission.Provider != "" {
+		job.Provider = resubmission.Provider
+		job.Providers = nil
+	}
+	job.Status = database.StatusProcessing
+	job.Details = ""
+	job.Done = false
+	job.DispatchAttempts = nil
+	job.ResetOutputs()
+
+	if err := c.ValidateJob(job); err != nil {
+		jobLogger.Errorf("Job is not supported by provider: %v", err)
+		return nil, err
+	}
+
+	jobLogger.WithField("Submission", len(job.Submissions)+1).Info("Resubmitting job")
+	resubmittedAt := job.Submissions[len(job.Submissions)-1].ResubmittedAt
+	if c.Queue != nil {
+		return c.requeueJob(ctx, job, resubmittedAt)
+	}
+	if err := c.dispatch(ctx, job); err != nil {
+		job.Status = database.StatusError
+		job.Details = err.Error()
 		job.Done = true
-		err = c.DB.UpdateJob(jobID, job)
+		if updateErr := c.DB.UpdateJob(ctx, jobID, job); updateErr != nil {
+			jobLogger.Errorf("Error updating job in DB: %v", updateErr)
+		}
+		c.recordEvent(ctx, job, database.JobEvent{Type: database.JobEventResubmitted, CreatedAt: resubmittedAt})
+		c.recordDispatchAttempts(ctx, job)
+		c.notify(ctx, job, EventFailed)
+		return nil, err
+	}
+	c.setDeadline(job, resubmittedAt)
+	if err := c.DB.UpdateJob(ctx, jobID, job); err != nil {
+		jobLogger.Errorf("Error updating job in DB: %v", err)
+		return nil, fmt.Errorf("Error storing Job: %v", err)
 	}
-	return job, err
+	c.recordEvent(ctx, job, database.JobEvent{Type: database.JobEventResubmitted, CreatedAt: resubmittedAt})
+	c.recordDispatchAttempts(ctx, job)
+	c.notifyStatus(ctx, job)
+	return job, nil
 }
 
-// DispatchJob dispatches a Job given an existing Provider
-func (c Client) DispatchJob(job *database.Job) error {
-	provider := c.Providers[job.Provider]
+// requeueJob stores a resubmitted job with the queued status, so it is
+// dispatched by the dispatch queue within its provider concurrency cap
+func (c Client) requeueJob(ctx context.Context, job *database.Job, resubmittedAt time.Time) (*database.Job, error) {
 	jobLogger := c.Logger.WithFields(log.Fields{"JobID": job.ID, "Provider": job.Provider})
+	job.Status = database.StatusQueued
+	job.QueuedAt = resubmittedAt
+	job.Deadline = time.Time{}
+	job.OverdueAt = time.Time{}
+	requeued := *job
+	if _, err := c.DB.ModifyJob(ctx, job.ID, func(stored *database.Job) error {
+		if stored.Status != database.StatusError {
+			return ErrJobNotResubmittable
+		}
+		*stored = requeued
+		return nil
+	}); err != nil {
+		if err != ErrJobNotResubmittable {
+			jobLogger.Errorf("Error updating job in DB: %v", err)
+			err = fmt.Errorf("Error storing Job: %v", err)
+		}
+		return nil, err
+	}
+	jobLogger.Info("Queued resubmitted job")
+	c.recordEvent(ctx, job, database.JobEvent{Type: database.JobEventResubmitted, CreatedAt: resubmittedAt})
+	c.notifyStatus(ctx, job)
+	job.QueuePosition = c.queuePosition(ctx, job)
+	c.Queue.signal()
+	return job, nil
+}
+
+// requestedParams drops the params providers set on dispatch, which are
+// capitalized, e.g. ProviderID, from the params of a job
+func requestedParams(params database.ProviderParams) database.ProviderParams {
+	requested := make(database.ProviderParams, len(params))
+	for k, v := range params {
+		if k != "" && unicode.IsUpper(rune(k[0])) {
+			continue
+		}
+		requested[k] = v
+	}
+	return requested
+}
+
+// dispatch tries the job candidate providers in order until one accepts it
+func (c Client) dispatch(ctx context.Context, job *database.Job) error {
+	jobLogger := c.Logger.WithFields(log.Fields{"JobID": job.ID})
+	candidates := c.dispatchCandidates(job)
+	if len(candidates) == 0 {
+		jobLogger.Error("provider not found")
+		return providers.ErrProviderNotFound
+	}
+
+	params := job.ProviderParams
+	var err error
+	var failures []string
+	for i, name := range candidates {
+		if i > 0 && ctx.Err() != nil {
+			// the next provider wasn't called
+			err = ctx.Err()
+			break
+		}
+		// providers add their own params on dispatch, every attempt starts from the requested ones
+		job.Provider = name
+		job.ProviderParams = copyParams(params)
+		err = c.dispatchJobTo(ctx, job)
+		attempt := database.DispatchAttempt{Provider: name, At: time.Now()}
+		if err == nil {
+			job.DispatchAttempts = append(job.DispatchAttempts, attempt)
+			break
+		}
+		attempt.Error = err.Error()
+		if indeterminate(err) {
+			attempt.Indeterminate = true
+			job.DispatchAttempts = append(job.DispatchAttempts, attempt)
+			jobLogger.WithField("Provider", name).Error("Dispatch outcome is unknown, not falling back")
+			return &IndeterminateDispatchError{Provider: name, Err: err}
+		}
+		job.DispatchAttempts = append(job.DispatchAttempts, attempt)
+		var validationErr providers.ValidationError
+		if errors.As(err, &validationErr) {
+			failures = append(failures, err.Error())
+		} else {
+			failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+		}
+		if i < len(candidates)-1 {
+			jobLogger.WithField("Provider", name).Warnf("Falling back to %s", candidates[i+1])
+		}
+	}
+
+	if err != nil {
+		if len(job.DispatchAttempts) == 1 {
+			if err == providers.ErrProviderNotFound {
+				return err
+			}
+			return fmt.Errorf("Error dispatching Job: %v", err)
+		}
+		return fmt.Errorf("Error dispatching Job: all providers failed: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// dispatchJobTo validates and dispatches a job to its current provider
+func (c Client) dispatchJobTo(ctx context.Context, job *database.Job) error {
+	jobLogger := c.Logger.WithFields(log.Fields{"JobID": job.ID, "Provider": job.Provider})
+	provider := c.Providers[job.Provider]
 	if provider == nil {
 		jobLogger.Error("provider not found")
-		return errors.New("provider not found")
+		return providers.ErrProviderNotFound
+	}
+	if err := c.validateJobFor(job, job.Provider); err != nil {
+		jobLogger.Errorf("Job is not supported by provider: %v", err)
+		return err
 	}
 
 	jobLogger.Info("Dispatching job to provider")
-	err := provider.DispatchJob(job)
+	err := c.callProvider(ctx, job.Provider, func(ctx context.Context) error {
+		return provider.DispatchJob(ctx, job)
+	})
 	if err != nil {
 		jobLogger.Errorf("Error dispatching job to provider: %v", err)
-		return fmt.Errorf("Error dispatching Job: %v", err)
-	}
-	jobLogger.Info("Storing job in DB")
-	_, err = c.DB.StoreJob(job)
-	if err != nil {
-		jobLogger.Errorf("Error storing job in DB: %v", err)
-		return fmt.Errorf("Error storing Job: %v", err)
+		return err
 	}
 	return nil
 }
 
+func copyParams(params database.ProviderParams) database.ProviderParams {
+	if params == nil {
+		return nil
+	}
+	copied := make(database.ProviderParams, len(params))
+	for k, v := range params {
+		copied[k] = v
+	}
+	return copied
+}
+
 // CancelJob cancels a job by ID
-func (c Client) CancelJob(jobID string) (bool, error) {
-	job, err := c.DB.GetJob(jobID)
+func (c Client) CancelJob(ctx context.Context, jobID string) (bool, error) {
+	job, err := c.DB.GetJob(ctx, jobID)
 	if err != nil {
 		c.Logger.Error("Could not find Job in database")
 		return false, err
@@ -137,29 +972,70 @@ func (c Client) CancelJob(jobID string) (bool, error) {
 		return false, nil
 	}
 
-	job.Status = "cancelled"
-	job.Done = true
-
-	err = c.DB.UpdateJob(jobID, job)
+	// dispatching jobs are cancelled with their provider by the worker
+	// dispatching them once it is done
+	queued := false
+	job, err = c.DB.ModifyJob(ctx, jobID, func(stored *database.Job) error {
+		if stored.Done {
+			return errJobDone
+		}
+		queued = stored.Status == database.StatusQueued || stored.Status == database.StatusDispatching
+		stored.Status = database.StatusCancelled
+		stored.Done = true
+		return nil
+	})
+	if err == errJobDone {
+		c.Logger.Error("Cannot cancel a job that is already done")
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
 	c.Logger.Info("Cancelled job in the database")
-	if job.Provider == "3play" {
-		cancellable, err := c.Providers[job.Provider].CancelJob(job)
-		if err != nil {
-			if cancellable {
-				c.Logger.Errorf("Could not cancel job with 3play but set to cancel in DB: %v", err)
-				return true, fmt.Errorf("could not cancel job with 3play but set to cancel in DB: %v", err)
-			}
-			c.Logger.Error("job is no longer cancellable with 3play but was updated in the DB")
-			return true, errors.New("job is no longer cancellable with 3play but was updated in the DB")
+	c.recordEvent(ctx, job, database.JobEvent{Type: database.JobEventCancelled})
+	c.notifyStatus(ctx, job)
+	if queued {
+		// the job never reached its provider
+		return true, nil
+	}
+	return c.cancelWithProvider(ctx, job)
+}
+
+// cancelWithProvider cancels a job cancelled in the DB with its provider
+func (c Client) cancelWithProvider(ctx context.Context, job *database.Job) (bool, error) {
+	provider, ok := c.Providers[job.Provider]
+	if !ok {
+		c.Logger.Errorf("Provider %s is not registered, job was only cancelled in the DB", job.Provider)
+		return true, nil
+	}
+	var cancellable bool
+	cancelErr := c.callProvider(ctx, job.Provider, func(ctx context.Context) error {
+		var err error
+		cancellable, err = provider.CancelJob(ctx, job)
+		return err
+	})
+	if cancelErr != nil {
+		c.recordEvent(ctx, job, database.JobEvent{
+			Type:    database.JobEventError,
+			Details: fmt.Sprintf("cancelling with %s failed: %v", job.Provider, cancelErr),
+		})
+		if cancellable {
+			c.Logger.Errorf("Could not cancel job with %s but set to cancel in DB: %v", job.Provider, cancelErr)
+			return true, fmt.Errorf("could not cancel job with %s but set to cancel in DB: %v", job.Provider, cancelErr)
 		}
+		c.Logger.Errorf("job is no longer cancellable with %s but was updated in the DB", job.Provider)
+		return true, fmt.Errorf("job is no longer cancellable with %s but was updated in the DB", job.Provider)
 	}
 	c.Logger.Infof("Cancelled job with %s", job.Provider)
-	return true, err
+	return true, nil
 }
 
+// errJobDone is returned when a job is done before it could be changed
+var errJobDone = errors.New("job is done")
+
 // DownloadCaption downloads a caption of a given job in the specified format
-func (c Client) DownloadCaption(jobID string, captionType string) ([]byte, error) {
-	job, err := c.DB.GetJob(jobID)
+func (c Client) DownloadCaption(ctx context.Context, jobID string, captionType string) ([]byte, error) {
+	job, err := c.DB.GetJob(ctx, jobID)
 	if err != nil {
 		c.Logger.Error("Could not find Job in database")
 		return nil, err
@@ -170,7 +1046,11 @@ func (c Client) DownloadCaption(jobID string, captionType string) ([]byte, error
 	jobLogger := c.Logger.WithFields(fields)
 	provider := c.Providers[job.Provider]
 	jobLogger.Info("Downloading captions from provider")
-	captions, err := provider.Download(job, captionType)
+	var captions []byte
+	err = c.callProvider(ctx, job.Provider, func(ctx context.Context) error {
+		captions, err = provider.Download(ctx, job, captionType)
+		return err
+	})
 	if err != nil {
 		jobLogger.Error("error downloading captions from provider: ", err)
 		return nil, err
@@ -260,33 +1140,56 @@ func (c Client) GenerateTranscript(captionFile []byte, captionFormat string) (st
 	return "", fmt.Errorf("unable to generate a transcript for caption format: %v", captionFormat)
 }
 
-func (c Client) ProcessCallback(callbackData CallbackData, jobID string) error {
+// ParseCallback parses a callback payload with the named provider's CallbackHandler
+func (c Client) ParseCallback(providerName string, query url.Values, body []byte) (*providers.Callback, error) {
+	provider, ok := c.Providers[providerName]
+	if !ok {
+		return nil, providers.ErrProviderNotFound
+	}
+	handler, ok := provider.(providers.CallbackHandler)
+	if !ok {
+		return nil, ErrCallbacksNotSupported
+	}
+	return handler.ParseCallback(query, body)
+}
+
+// ProcessCallback refreshes the job a provider callback refers to and notifies CallbackURL
+func (c Client) ProcessCallback(ctx context.Context, providerName string, callback *providers.Callback) error {
 	jobLogger := c.Logger.WithFields(log.Fields{
-		"JobID":      jobID,
-		"ProviderID": callbackData.ID,
-		"Status":     callbackData.Status,
+		"JobID":      callback.JobID,
+		"Provider":   providerName,
+		"ProviderID": callback.ProviderID,
+		"Status":     callback.Status,
 	})
 	jobLogger.Info("Processing a callback for captions")
-	if callbackData.ID == 0 {
-		jobLogger.Error("Invalid Provider ID")
-		return errors.New("invalid Provider ID")
-	}
-	if jobID == "" {
-		databaseJob, err := c.DB.GetJobByProviderID(strconv.Itoa(callbackData.ID))
-		if err != nil {
-			jobLogger.Errorf("Could not retrieve job by provider ID: %v", err)
-			return err
+	var databaseJob *database.Job
+	var err error
+	if callback.JobID != "" {
+		databaseJob, err = c.DB.GetJob(ctx, callback.JobID)
+	} else {
+		if callback.ProviderID == "" {
+			jobLogger.Error("Invalid Provider ID")
+			return providers.ErrInvalidProviderID
 		}
-		jobID = databaseJob.ID
+		databaseJob, err = c.DB.GetJobByProviderID(ctx, callback.ProviderID)
 	}
-	job, err := c.GetJob(jobID)
+	if err != nil {
+		jobLogger.Errorf("Could not retrieve job: %v", err)
+		return err
+	}
+	// the job is only refreshed from the provider that owns it
+	if databaseJob.Provider != providerName {
+		jobLogger.Errorf("Job belongs to provider %s", databaseJob.Provider)
+		return ErrJobProviderMismatch
+	}
+	job, err := c.GetJob(ctx, databaseJob.ID)
 	if err != nil {
 		jobLogger.Errorf("Could not get job data: %v", err)
 		return err
 	}
 	if c.CallbackURL != "" {
 		jobLogger.Infof("Making API call to: %v", c.CallbackURL)
-		err = c.makeAPICall(job)
+		err = c.makeAPICall(ctx, job)
 		if err != nil {
 			jobLogger.Errorf("Encountered an error while making a callback call: %v", err)
 			return err
@@ -295,13 +1198,18 @@ func (c Client) ProcessCallback(callbackData CallbackData, jobID string) error {
 	return nil
 }
 
-func (c Client) makeAPICall(job *database.Job) error {
+func (c Client) makeAPICall(ctx context.Context, job *database.Job) error {
 	requestBody, err := json.Marshal(job)
 	if err != nil {
 		return err
 	}
 
-	resp, err := http.Post(c.CallbackURL, "application/json", bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.CallbackURL, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -1,13 +1,18 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/nytimes/video-captions-api/database"
+	"github.com/nytimes/video-captions-api/providers"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
@@ -21,18 +26,191 @@ func TestGetJob(t *testing.T) {
 		MediaURL: "http://vp.nyt.com/video.mp4",
 		Provider: "test-provider",
 	}
-	client.DB.StoreJob(job)
-	resultJob, _ := client.GetJob(job.ID)
+	client.DB.StoreJob(context.Background(), job)
+	resultJob, _ := client.GetJob(context.Background(), job.ID)
 	assert.Equal(job.ID, resultJob.ID)
 	assert.Equal(job.MediaURL, resultJob.MediaURL)
 }
 
 func TestDispatchJobNoProvider(t *testing.T) {
 	_, client := createCaptionsService("")
-	err := client.DispatchJob(&database.Job{Provider: "wrong-provider"})
+	err := client.DispatchJob(context.Background(), &database.Job{Provider: "wrong-provider"})
 	assert.Equal(t, "provider not found", err.Error())
 }
 
+func TestGetJobProviderTimeout(t *testing.T) {
+	assert := assert.New(t)
+	service, client := createCaptionsService("")
+	service.AddProvider(slowProvider{fakeProvider{logger: log.New()}})
+	client.ProviderTimeout = time.Minute
+	client.ProviderTimeouts = map[string]time.Duration{"slow-provider": 10 * time.Millisecond}
+	job := &database.Job{
+		ID:       "123",
+		MediaURL: "http://vp.nyt.com/video.mp4",
+		Provider: "slow-provider",
+	}
+	client.DB.StoreJob(context.Background(), job)
+	_, err := client.GetJob(context.Background(), job.ID)
+	assert.Equal(context.DeadlineExceeded, err)
+}
+
+func TestDispatchJobContextCancelled(t *testing.T) {
+	assert := assert.New(t)
+	service, client := createCaptionsService("")
+	service.AddProvider(slowProvider{fakeProvider{logger: log.New()}})
+	service.AddProvider(fakeProvider{logger: log.New()})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	job := &database.Job{ID: "123", Providers: []string{"slow-provider", "test-provider"}, ProviderParams: database.ProviderParams{}}
+	err := client.DispatchJob(ctx, job)
+	var indeterminateErr *IndeterminateDispatchError
+	assert.True(errors.As(err, &indeterminateErr))
+	assert.Equal("slow-provider", indeterminateErr.Provider)
+	assert.Equal(context.Canceled, indeterminateErr.Err)
+
+	// the provider may have received the job, it isn't ordered from another one
+	stored, err := client.DB.GetJob(context.Background(), "123")
+	assert.Nil(err)
+	assert.Equal(database.StatusError, stored.Status)
+	assert.True(stored.Done)
+	assert.Equal("slow-provider", stored.Provider)
+	assert.Len(stored.DispatchAttempts, 1)
+	assert.True(stored.DispatchAttempts[0].Indeterminate)
+}
+
+func TestDispatchJobFailover(t *testing.T) {
+	assert := assert.New(t)
+	service, client := createCaptionsService("")
+	service.AddProvider(brokenProvider{logger: log.New()})
+	service.AddProvider(fakeProvider{logger: log.New()})
+	job := &database.Job{
+		ID:             "123",
+		MediaURL:       "http://vp.nyt.com/video.mp4",
+		Provider:       "broken-provider",
+		Providers:      []string{"broken-provider", "test-provider"},
+		ProviderParams: map[string]string{"turnaround_level_id": "2"},
+	}
+	err := client.DispatchJob(context.Background(), job)
+	assert.Nil(err)
+	assert.Equal("test-provider", job.Provider)
+	assert.Len(job.DispatchAttempts, 2)
+	assert.Equal("broken-provider", job.DispatchAttempts[0].Provider)
+	assert.Equal("provider error", job.DispatchAttempts[0].Error)
+	assert.Equal("test-provider", job.DispatchAttempts[1].Provider)
+	assert.Empty(job.DispatchAttempts[1].Error)
+
+	storedJob, err := client.DB.GetJob(context.Background(), "123")
+	assert.Nil(err)
+	assert.Equal("test-provider", storedJob.Provider)
+	assert.Len(storedJob.DispatchAttempts, 2)
+}
+
+func TestDispatchJobDefaultProviders(t *testing.T) {
+	assert := assert.New(t)
+	service, client := createCaptionsService("")
+	service.AddProvider(brokenProvider{logger: log.New()})
+	service.AddProvider(fakeProvider{logger: log.New()})
+	client.DefaultProviders = []string{"broken-provider", "test-provider"}
+	job := &database.Job{ID: "123", MediaURL: "http://vp.nyt.com/video.mp4"}
+	err := client.DispatchJob(context.Background(), job)
+	assert.Nil(err)
+	assert.Equal("test-provider", job.Provider)
+	assert.Len(job.DispatchAttempts, 2)
+}
+
+func TestDispatchJobAllProvidersFail(t *testing.T) {
+	assert := assert.New(t)
+	service, client := createCaptionsService("")
+	service.AddProvider(brokenProvider{logger: log.New()})
+	service.AddProvider(fakeProvider{logger: log.New()})
+	job := &database.Job{
+		ID:             "123",
+		MediaURL:       "http://vp.nyt.com/video.mp4",
+		Providers:      []string{"test-provider", "broken-provider", "missing-provider"},
+		ProviderParams: map[string]string{"fidelity": "high"},
+	}
+	err := client.DispatchJob(context.Background(), job)
+	assert.EqualError(err, `Error dispatching Job: all providers failed: test-provider: unknown provider param "fidelity"; broken-provider: provider error; missing-provider: provider not found`)
+	assert.Len(job.DispatchAttempts, 3)
+	_, err = client.DB.GetJob(context.Background(), "123")
+	assert.Equal(database.ErrJobNotFound, err)
+}
+
+func TestResubmitJob(t *testing.T) {
+	assert := assert.New(t)
+	service, client := createCaptionsService("")
+	service.AddProvider(brokenProvider{logger: log.New()})
+	service.AddProvider(fakeProvider{logger: log.New()})
+	job := &database.Job{
+		ID:             "123",
+		MediaURL:       "http://vp.nyt.com/video.mp4",
+		Provider:       "broken-provider",
+		ProviderParams: map[string]string{"turnaround_level_id": "2", "fidelity": "high", "ProviderID": "1"},
+		Status:         "error",
+		Details:        "provider error",
+		Done:           true,
+		Outputs:        []database.JobOutput{{Type: "vtt", Filename: "video.vtt", URL: "somepath/video.vtt"}},
+	}
+	client.DB.StoreJob(context.Background(), job)
+
+	_, err := client.ResubmitJob(context.Background(), "123", Resubmission{})
+	assert.EqualError(err, "Error dispatching Job: provider error")
+	storedJob, _ := client.DB.GetJob(context.Background(), "123")
+	assert.Equal(database.StatusError, storedJob.Status)
+	assert.Len(storedJob.Submissions, 1)
+
+	_, err = client.ResubmitJob(context.Background(), "123", Resubmission{Provider: "test-provider"})
+	assert.EqualError(err, `test-provider: unknown provider param "fidelity"`)
+
+	resubmitted, err := client.ResubmitJob(context.Background(), "123", Resubmission{
+		Provider:       "test-provider",
+		Turnaround:     "3",
+		ProviderParams: database.ProviderParams{"fidelity": ""},
+	})
+	assert.Nil(err)
+	assert.Equal("123", resubmitted.ID)
+	assert.Equal("test-provider", resubmitted.Provider)
+	assert.Equal(database.StatusProcessing, resubmitted.Status)
+	assert.False(resubmitted.Done)
+	assert.Empty(resubmitted.Outputs[0].URL)
+	assert.Equal(database.ProviderParams{"turnaround_level_id": "3"}, resubmitted.ProviderParams)
+	assert.Len(resubmitted.DispatchAttempts, 1)
+
+	storedJob, _ = client.DB.GetJob(context.Background(), "123")
+	assert.Len(storedJob.Submissions, 2)
+	assert.Equal("broken-provider", storedJob.Submissions[0].Provider)
+	assert.Equal("provider error", storedJob.Submissions[0].Details)
+	assert.Equal("1", storedJob.Submissions[0].ProviderParams["ProviderID"])
+	assert.Equal("Error dispatching Job: provider error", storedJob.Submissions[1].Details)
+	assert.Equal("broken-provider", storedJob.Submissions[1].DispatchAttempts[0].Provider)
+
+	_, err = client.ResubmitJob(context.Background(), "123", Resubmission{})
+	assert.Equal(ErrJobNotResubmittable, err)
+}
+
+func TestGetJobBreakerOpen(t *testing.T) {
+	assert := assert.New(t)
+	service, client := createCaptionsService("")
+	service.AddProvider(brokenProvider{logger: log.New()})
+	client.Guards = NewProviderGuards(2, time.Minute, nil, 1)
+	job := &database.Job{
+		ID:       "123",
+		MediaURL: "http://vp.nyt.com/video.mp4",
+		Provider: "broken-provider",
+		Status:   "processing",
+	}
+	client.DB.StoreJob(context.Background(), job)
+
+	for i := 0; i < 2; i++ {
+		_, err := client.GetJob(context.Background(), job.ID)
+		assert.EqualError(err, "failed to get job")
+	}
+	resultJob, err := client.GetJob(context.Background(), job.ID)
+	assert.Nil(err)
+	assert.Equal(database.StatusProcessing, resultJob.Status)
+	assert.Equal(breakerOpen, client.GetProviderStatus()[0].State)
+}
+
 func TestGetJobReady(t *testing.T) {
 	assert := assert.New(t)
 	service, client := createCaptionsService("")
@@ -51,14 +229,83 @@ func TestGetJobReady(t *testing.T) {
 		OutputTypes: []string{"vtt", "srt"},
 	})
 	job.Status = "delivered"
-	client.DB.StoreJob(job)
+	client.DB.StoreJob(context.Background(), job)
 
-	resultJob, _ := client.GetJob(job.ID)
+	resultJob, _ := client.GetJob(context.Background(), job.ID)
 	assert.True(resultJob.Done)
 	assert.Equal("somepath/test-provider/"+resultJob.Outputs[0].Filename, resultJob.Outputs[0].URL)
 	assert.Equal("somepath/test-provider/"+resultJob.Outputs[1].Filename, resultJob.Outputs[1].URL)
 }
 
+// failingStorage fails to store the files of the given extension
+type failingStorage struct {
+	ignoreStorage
+	extension string
+}
+
+func (f failingStorage) Store(ctx context.Context, data []byte, filename string) (string, error) {
+	if strings.HasSuffix(filename, "."+f.extension) {
+		return "", errors.New("storage error")
+	}
+	return f.ignoreStorage.Store(ctx, data, filename)
+}
+
+func TestGetJobOutputRetries(t *testing.T) {
+	assert := assert.New(t)
+	service, client := createCaptionsService("")
+	service.AddProvider(fakeProvider{logger: log.New(), params: map[string]bool{"jobDone": true}})
+	client.Storage = failingStorage{extension: "srt"}
+	client.OutputMaxAttempts = 2
+	job, _ := newJobFromParams(jobParams{
+		MediaURL:    "http://vp.nyt.com/video.mp4",
+		Provider:    "test-provider",
+		OutputTypes: []string{"vtt", "srt"},
+	})
+	client.DB.StoreJob(context.Background(), job)
+
+	resultJob, err := client.GetJob(context.Background(), job.ID)
+	assert.Nil(err)
+	assert.False(resultJob.Done)
+	assert.Equal("somepath/test-provider/"+resultJob.Outputs[0].Filename, resultJob.Outputs[0].URL)
+	assert.Equal(1, resultJob.Outputs[0].Attempts)
+	assert.Empty(resultJob.Outputs[1].URL)
+	assert.Equal(1, resultJob.Outputs[1].Attempts)
+	assert.Equal("storage error", resultJob.Outputs[1].LastError)
+	assert.False(resultJob.Outputs[1].Failed)
+
+	resultJob, err = client.GetJob(context.Background(), job.ID)
+	assert.Nil(err)
+	assert.True(resultJob.Done)
+	assert.Equal(1, resultJob.Outputs[0].Attempts)
+	assert.Equal(2, resultJob.Outputs[1].Attempts)
+	assert.True(resultJob.Outputs[1].Failed)
+	assert.True(resultJob.Outputs[1].NextRetryAt.IsZero())
+}
+
+func TestGetJobOutputRetryBackoff(t *testing.T) {
+	assert := assert.New(t)
+	service, client := createCaptionsService("")
+	service.AddProvider(fakeProvider{logger: log.New(), params: map[string]bool{"jobDone": true}})
+	client.Storage = failingStorage{extension: "vtt"}
+	client.OutputRetryBackoff = time.Hour
+	job, _ := newJobFromParams(jobParams{
+		MediaURL:    "http://vp.nyt.com/video.mp4",
+		Provider:    "test-provider",
+		OutputTypes: []string{"vtt"},
+	})
+	client.DB.StoreJob(context.Background(), job)
+
+	client.GetJob(context.Background(), job.ID)
+	resultJob, _ := client.GetJob(context.Background(), job.ID)
+	assert.False(resultJob.Done)
+	assert.Equal(1, resultJob.Outputs[0].Attempts)
+	assert.WithinDuration(time.Now().Add(time.Hour), resultJob.Outputs[0].NextRetryAt, time.Minute)
+
+	assert.Equal(time.Hour, client.outputRetryDelay(1))
+	assert.Equal(4*time.Hour, client.outputRetryDelay(3))
+	assert.Equal(32*time.Hour, client.outputRetryDelay(10))
+}
+
 func TestGetJobs(t *testing.T) {
 	parentID := "Mom"
 
@@ -74,7 +321,7 @@ func TestGetJobs(t *testing.T) {
 		ParentID:  parentID,
 		CreatedAt: today,
 	}
-	client.DB.StoreJob(job1)
+	client.DB.StoreJob(context.Background(), job1)
 	job2 := &database.Job{
 		ID:        "456",
 		MediaURL:  "http://vp.nyt.com/video2.mp4",
@@ -82,9 +329,9 @@ func TestGetJobs(t *testing.T) {
 		ParentID:  parentID,
 		CreatedAt: yesterday,
 	}
-	client.DB.StoreJob(job2)
+	client.DB.StoreJob(context.Background(), job2)
 
-	summaries, _ := client.GetJobs(parentID)
+	summaries, _ := client.GetJobs(context.Background(), parentID)
 
 	assert := assert.New(t)
 	assert.NotNil(summaries)
@@ -108,8 +355,8 @@ func TestProviderJobError(t *testing.T) {
 		MediaURL: "http://vp.nyt.com/video.mp4",
 		Provider: "test-provider",
 	}
-	client.DB.StoreJob(job)
-	_, err := client.GetJob("123")
+	client.DB.StoreJob(context.Background(), job)
+	_, err := client.GetJob(context.Background(), "123")
 
 	assert := assert.New(t)
 	assert.NotNil(err)
@@ -129,12 +376,36 @@ func TestProviderStatusError(t *testing.T) {
 		ID:       "123",
 		MediaURL: "http://vp.nyt.com/video.mp4",
 		Provider: "test-provider",
+		Status:   database.StatusProcessing,
 	}
-	client.DB.StoreJob(job)
-	resultJob, _ := client.GetJob("123")
+	client.DB.StoreJob(context.Background(), job)
+	resultJob, _ := client.GetJob(context.Background(), "123")
 	assert := assert.New(t)
-	assert.NotNil(resultJob.Status)
-	assert.EqualValues(resultJob.Status, "My status")
+	assert.Equal(database.StatusProcessing, resultJob.Status)
+	assert.Equal("My status", resultJob.ProviderStatus)
+}
+
+func TestProviderStatusCancelled(t *testing.T) {
+	assert := assert.New(t)
+	service, client := createCaptionsService("")
+	service.AddProvider(fakeProvider{logger: log.New(), params: map[string]bool{"jobCancelled": true}})
+	client.DB.StoreJob(context.Background(), &database.Job{
+		ID:             "123",
+		Provider:       "test-provider",
+		Status:         database.StatusProcessing,
+		ProviderParams: database.ProviderParams{"ProviderID": "abc"},
+		Deadline:       time.Now().Add(-time.Hour),
+	})
+
+	job, err := client.GetJob(context.Background(), "123")
+	assert.Nil(err)
+	assert.Equal(database.StatusCancelled, job.Status)
+	assert.True(job.Done)
+	assert.False(job.IsOverdue(time.Now()))
+	pending, _ := client.DB.GetPendingJobs(context.Background())
+	assert.Empty(pending)
+	overdue, _ := client.GetOverdueJobs(context.Background())
+	assert.Empty(overdue)
 }
 
 func TestCancelClientJob(t *testing.T) {
@@ -146,10 +417,10 @@ func TestCancelClientJob(t *testing.T) {
 		MediaURL: "http://vp.nyt.com/video.mp4",
 		Provider: "test-provider",
 	}
-	client.DB.StoreJob(job)
-	resultJob, _ := client.GetJob(job.ID)
+	client.DB.StoreJob(context.Background(), job)
+	resultJob, _ := client.GetJob(context.Background(), job.ID)
 
-	canceled, err := client.CancelJob(resultJob.ID)
+	canceled, err := client.CancelJob(context.Background(), resultJob.ID)
 	assert.Nil(err)
 	assert.True(canceled)
 }
@@ -164,20 +435,52 @@ func TestCancelClientJobDone(t *testing.T) {
 		Provider: "test-provider",
 		Done:     true,
 	}
-	client.DB.StoreJob(job)
-	resultJob, _ := client.GetJob(job.ID)
+	client.DB.StoreJob(context.Background(), job)
+	resultJob, _ := client.GetJob(context.Background(), job.ID)
 
-	canceled, err := client.CancelJob(resultJob.ID)
+	canceled, err := client.CancelJob(context.Background(), resultJob.ID)
 	assert.Nil(err)
 	assert.False(canceled)
 }
 
+func TestCancelClientJobProviderError(t *testing.T) {
+	tests := []struct {
+		name  string
+		param string
+		err   string
+	}{
+		{"Cancel error", "cancelError", "could not cancel job with test-provider but set to cancel in DB: oh no"},
+		{"Not cancellable", "notCancellable", "job is no longer cancellable with test-provider but was updated in the DB"},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			service, client := createCaptionsService("")
+			assert := assert.New(t)
+			service.AddProvider(fakeProvider{logger: log.New(), params: map[string]bool{test.param: true}})
+			job := &database.Job{
+				ID:       "123",
+				MediaURL: "http://vp.nyt.com/video.mp4",
+				Provider: "test-provider",
+			}
+			client.DB.StoreJob(context.Background(), job)
+
+			canceled, err := client.CancelJob(context.Background(), job.ID)
+			assert.True(canceled)
+			assert.EqualError(err, test.err)
+			stored, _ := client.DB.GetJob(context.Background(), job.ID)
+			assert.Equal(database.StatusCancelled, stored.Status)
+			assert.True(stored.Done)
+		})
+	}
+}
+
 func TestCancelClientJob404(t *testing.T) {
 	service, client := createCaptionsService("")
 	assert := assert.New(t)
 	service.AddProvider(fakeProvider{logger: log.New()})
 
-	canceled, err := client.CancelJob("404")
+	canceled, err := client.CancelJob(context.Background(), "404")
 	assert.NotNil(err)
 	assert.EqualValues("job not found", err.Error())
 	assert.False(canceled)
@@ -192,8 +495,8 @@ func TestDownloadCaption(t *testing.T) {
 		MediaURL: "http://vp.nyt.com/video.mp4",
 		Provider: "test-provider",
 	}
-	client.DB.StoreJob(job)
-	caption, err := client.DownloadCaption("123", "vtt")
+	client.DB.StoreJob(context.Background(), job)
+	caption, err := client.DownloadCaption(context.Background(), "123", "vtt")
 	assert.Nil(err)
 	assert.Equal("WEBVTT\n\nNOTE Paragraph\n\n00:00:09.240 --> 00:00:11.010\nWe're all talking\nabout the Iowa caucuses", string(caption))
 }
@@ -207,8 +510,8 @@ func TestDownloadNonexistentCaption(t *testing.T) {
 		MediaURL: "http://vp.nyt.com/video.mp4",
 		Provider: "test-provider",
 	}
-	client.DB.StoreJob(job)
-	_, err := client.DownloadCaption("404", "vtt")
+	client.DB.StoreJob(context.Background(), job)
+	_, err := client.DownloadCaption(context.Background(), "404", "vtt")
 	assert.NotNil(err)
 	assert.EqualValues("job not found", err.Error())
 }
@@ -222,8 +525,8 @@ func TestDownloadCaptionProviderError(t *testing.T) {
 		MediaURL: "http://vp.nyt.com/video.mp4",
 		Provider: "broken-provider",
 	}
-	client.DB.StoreJob(job)
-	_, err := client.DownloadCaption("123", "vtt")
+	client.DB.StoreJob(context.Background(), job)
+	_, err := client.DownloadCaption(context.Background(), "123", "vtt")
 	assert.NotNil(err)
 	assert.EqualValues("download error", err.Error())
 }
@@ -237,8 +540,8 @@ func TestGenerateTranscriptSsa(t *testing.T) {
 		MediaURL: "http://vp.nyt.com/video.mp4",
 		Provider: "test-provider",
 	}
-	client.DB.StoreJob(job)
-	caption, err := client.DownloadCaption("123", "ssa")
+	client.DB.StoreJob(context.Background(), job)
+	caption, err := client.DownloadCaption(context.Background(), "123", "ssa")
 	assert.Nil(err)
 	transcript, err := client.GenerateTranscript(caption, "ssa")
 	assert.Nil(err)
@@ -254,8 +557,8 @@ func TestGenerateTranscriptVtt(t *testing.T) {
 		MediaURL: "http://vp.nyt.com/video.mp4",
 		Provider: "test-provider",
 	}
-	client.DB.StoreJob(job)
-	caption, err := client.DownloadCaption("123", "vtt")
+	client.DB.StoreJob(context.Background(), job)
+	caption, err := client.DownloadCaption(context.Background(), "123", "vtt")
 	assert.Nil(err)
 	transcript, err := client.GenerateTranscript(caption, "vtt")
 	assert.Nil(err)
@@ -271,8 +574,8 @@ func TestGenerateTranscriptSrt(t *testing.T) {
 		MediaURL: "http://vp.nyt.com/video.mp4",
 		Provider: "test-provider",
 	}
-	client.DB.StoreJob(job)
-	caption, err := client.DownloadCaption("123", "srt")
+	client.DB.StoreJob(context.Background(), job)
+	caption, err := client.DownloadCaption(context.Background(), "123", "srt")
 	assert.Nil(err)
 	transcript, err := client.GenerateTranscript(caption, "srt")
 	assert.Nil(err)
@@ -288,8 +591,8 @@ func TestGenerateTranscriptSbv(t *testing.T) {
 		MediaURL: "http://vp.nyt.com/video.mp4",
 		Provider: "test-provider",
 	}
-	client.DB.StoreJob(job)
-	caption, err := client.DownloadCaption("123", "sbv")
+	client.DB.StoreJob(context.Background(), job)
+	caption, err := client.DownloadCaption(context.Background(), "123", "sbv")
 	assert.Nil(err)
 	transcript, err := client.GenerateTranscript(caption, "sbv")
 	assert.Nil(err)
@@ -305,8 +608,8 @@ func TestGenerateTranscriptWrongFormat(t *testing.T) {
 		MediaURL: "http://vp.nyt.com/video.mp4",
 		Provider: "test-provider",
 	}
-	client.DB.StoreJob(job)
-	caption, err := client.DownloadCaption("123", "vtt")
+	client.DB.StoreJob(context.Background(), job)
+	caption, err := client.DownloadCaption(context.Background(), "123", "vtt")
 	assert.Nil(err)
 	_, err = client.GenerateTranscript(caption, "wrong")
 	assert.NotNil(err)
@@ -315,7 +618,8 @@ func TestGenerateTranscriptWrongFormat(t *testing.T) {
 
 type processCallbackClientTest struct {
 	name            string
-	providerID      int
+	provider        string
+	providerID      string
 	jobID           string
 	startFakeServer bool
 	error           error
@@ -326,7 +630,8 @@ func TestProcessCallbackClient(t *testing.T) {
 	tests := []processCallbackClientTest{
 		{
 			name:            "Happy path without jobID",
-			providerID:      11214314,
+			provider:        "test-provider",
+			providerID:      "11214314",
 			jobID:           "",
 			startFakeServer: true,
 			error:           nil,
@@ -334,7 +639,8 @@ func TestProcessCallbackClient(t *testing.T) {
 		},
 		{
 			name:            "Happy path with jobID",
-			providerID:      11214314,
+			provider:        "test-provider",
+			providerID:      "11214314",
 			jobID:           "123",
 			startFakeServer: false,
 			error:           nil,
@@ -342,21 +648,32 @@ func TestProcessCallbackClient(t *testing.T) {
 		},
 		{
 			name:            "Invalid provider ID",
-			providerID:      0,
+			provider:        "test-provider",
+			providerID:      "",
 			startFakeServer: false,
 			error:           fmt.Errorf("invalid Provider ID"),
 			serverResponse:  0,
 		},
 		{
 			name:            "Provider ID not found",
-			providerID:      58437938,
+			provider:        "test-provider",
+			providerID:      "58437938",
 			startFakeServer: false,
 			error:           fmt.Errorf("no jobs found with this parent ID"),
 			serverResponse:  0,
 		},
+		{
+			name:            "Job from another provider",
+			provider:        "3play",
+			providerID:      "11214314",
+			startFakeServer: false,
+			error:           fmt.Errorf("job does not belong to this provider"),
+			serverResponse:  0,
+		},
 		{
 			name:            "Callback error",
-			providerID:      11214314,
+			provider:        "test-provider",
+			providerID:      "11214314",
 			jobID:           "123",
 			startFakeServer: true,
 			error:           fmt.Errorf("500 Internal Server Error"),
@@ -379,29 +696,60 @@ func TestProcessCallbackClient(t *testing.T) {
 			service, client := createCaptionsService(callbackURL)
 			assert := assert.New(t)
 			service.AddProvider(fakeProvider{logger: log.New()})
+			service.AddProvider(threePlayProvider{fakeProvider{logger: log.New()}})
 			job := &database.Job{
 				ID:             "123",
 				MediaURL:       "http://vp.nyt.com/video.mp4",
 				Provider:       "test-provider",
 				ProviderParams: map[string]string{"ProviderID": "11214314"},
 			}
-			client.DB.StoreJob(job)
-			callbackData := CallbackData{
-				ID:          test.providerID,
-				MediaFileID: 3765758,
-				BatchID:     68841,
-				ReferenceID: "",
-				Duration:    190.848,
-				Default:     true,
-				Type:        "AsrTranscript",
-				LanguageID:  1,
-				LanguageIDs: []int{1},
-				Status:      "complete",
-				Cancellable: false,
+			client.DB.StoreJob(context.Background(), job)
+			callback := &providers.Callback{
+				JobID:      test.jobID,
+				ProviderID: test.providerID,
+				Status:     "complete",
 			}
 
-			err := client.ProcessCallback(callbackData, test.jobID)
+			err := client.ProcessCallback(context.Background(), test.provider, callback)
 			assert.Equal(test.error, err)
 		})
 	}
 }
+
+func TestProcessCallbackWrongProvider(t *testing.T) {
+	assert := assert.New(t)
+	service, client := createCaptionsService("")
+	service.AddProvider(reviewProvider{fakeProvider{logger: log.New()}})
+	service.AddProvider(threePlayProvider{fakeProvider{logger: log.New()}})
+	job := &database.Job{
+		ID:             "123",
+		Provider:       "test-provider",
+		Status:         database.StatusProcessing,
+		ProviderParams: database.ProviderParams{"ProviderID": "11214314"},
+	}
+	client.DB.StoreJob(context.Background(), job)
+
+	err := client.ProcessCallback(context.Background(), "3play", &providers.Callback{JobID: "123", Status: "complete"})
+	assert.Equal(ErrJobProviderMismatch, err)
+	stored, _ := client.DB.GetJob(context.Background(), "123")
+	assert.Equal(database.StatusProcessing, stored.Status)
+	events, _ := client.GetJobEvents(context.Background(), "123")
+	assert.Empty(events)
+}
+
+func TestParseCallback(t *testing.T) {
+	assert := assert.New(t)
+	service, client := createCaptionsService("")
+	service.AddProvider(fakeProvider{logger: log.New()})
+	service.AddProvider(brokenProvider{logger: log.New()})
+
+	callback, err := client.ParseCallback("test-provider", url.Values{"job_id": {"123"}}, []byte(`{"data": {"id": 11214314, "status": "complete"}}`))
+	assert.Nil(err)
+	assert.Equal(&providers.Callback{JobID: "123", ProviderID: "11214314", Status: "complete"}, callback)
+
+	_, err = client.ParseCallback("broken-provider", nil, nil)
+	assert.Equal(ErrCallbacksNotSupported, err)
+
+	_, err = client.ParseCallback("nope", nil, nil)
+	assert.Equal(providers.ErrProviderNotFound, err)
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/nytimes/video-captions-api/database"
+	"github.com/nytimes/video-captions-api/providers"
+	log "github.com/sirupsen/logrus"
+)
+
+// turnaround returns how long the provider of a job takes to deliver it, from
+// the provider itself or ProviderTurnarounds. It is 0 when neither knows.
+func (c Client) turnaround(job *database.Job) (time.Duration, error) {
+	if estimator, ok := c.Providers[job.Provider].(providers.TurnaroundEstimator); ok {
+		return estimator.Turnaround(job.ProviderParams)
+	}
+	return c.ProviderTurnarounds[job.Provider], nil
+}
+
+// setDeadline sets the deadline of a job dispatched at dispatchedAt from the
+// turnaround of its provider, jobs without a known turnaround have no deadline
+func (c Client) setDeadline(job *database.Job, dispatchedAt time.Time) {
+	job.Deadline = time.Time{}
+	job.OverdueAt = time.Time{}
+	turnaround, err := c.turnaround(job)
+	if err != nil {
+		c.Logger.WithError(err).WithFields(log.Fields{"JobID": job.ID, "Provider": job.Provider}).Warn("Could not compute job deadline")
+		return
+	}
+	if turnaround > 0 {
+		job.Deadline = dispatchedAt.Add(turnaround)
+	}
+}
+
+// GetOverdueJobs returns the jobs that aren't done past their deadline, the
+// most overdue first
+func (c Client) GetOverdueJobs(ctx context.Context) ([]database.Job, error) {
+	jobs, err := c.DB.GetPendingJobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	overdue := []database.Job{}
+	for _, job := range jobs {
+		if job.IsOverdue(now) {
+			overdue = append(overdue, job)
+		}
+	}
+	sort.Slice(overdue, func(i, j int) bool {
+		return overdue[i].Deadline.Before(overdue[j].Deadline)
+	})
+	return overdue, nil
+}
+
+// checkOverdueJobs flags the pending jobs past their deadline and returns how
+// many were flagged
+func (c Client) checkOverdueJobs(ctx context.Context, now time.Time) int {
+	jobs, err := c.DB.GetPendingJobs(ctx)
+	if err != nil {
+		c.Logger.WithError(err).Error("Could not load pending jobs")
+		return 0
+	}
+	flagged := 0
+	for i := range jobs {
+		if ctx.Err() != nil {
+			break
+		}
+		if c.checkOverdue(ctx, &jobs[i], now) {
+			flagged++
+		}
+	}
+	return flagged
+}
+
+// runMaintenance retries the webhook deliveries, flags the overdue jobs and
+// fails the interrupted dispatches every interval until ctx is done
+func (c Client) runMaintenance(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.RetryDeliveries(ctx)
+		c.checkOverdueJobs(ctx, time.Now())
+		c.expireDispatchLeases(ctx, time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// errNotOverdue is returned when a job is no longer overdue or already flagged
+var errNotOverdue = errors.New("job isn't overdue")
+
+// checkOverdue flags a job found past its deadline, once, recording an overdue
+// event and notifying its webhooks so producers can escalate
+func (c Client) checkOverdue(ctx context.Context, job *database.Job, now time.Time) bool {
+	if !job.IsOverdue(now) || !job.OverdueAt.IsZero() {
+		return false
+	}
+	jobLogger := c.Logger.WithFields(log.Fields{"JobID": job.ID, "Provider": job.Provider, "Deadline": job.Deadline})
+	// job may be stale, only the stored job is flagged and only when it is
+	// still overdue
+	job, err := c.DB.ModifyJob(ctx, job.ID, func(stored *database.Job) error {
+		if !stored.IsOverdue(now) || !stored.OverdueAt.IsZero() {
+			return errNotOverdue
+		}
+		stored.OverdueAt = now
+		return nil
+	})
+	if err == errNotOverdue {
+		return false
+	}
+	if err != nil {
+		jobLogger.WithError(err).Error("Could not update overdue job")
+		return false
+	}
+	jobLogger.Warn("Job is overdue")
+	jobsOverdue.WithLabelValues(job.Provider).Inc()
+	c.recordEvent(ctx, job, database.JobEvent{
+		Type:    database.JobEventOverdue,
+		Details: "deadline was " + job.Deadline.UTC().Format(time.RFC3339),
+	})
+	c.notify(ctx, job, EventOverdue)
+	return true
+}
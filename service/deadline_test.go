@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/NYTimes/gizmo/server"
+	"github.com/nytimes/video-captions-api/database"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// turnaroundProvider delivers jobs in a number of hours given by their turnaround_level_id
+type turnaroundProvider struct {
+	fakeProvider
+}
+
+func (p turnaroundProvider) Turnaround(params database.ProviderParams) (time.Duration, error) {
+	level, err := time.ParseDuration(params["turnaround_level_id"] + "h")
+	if err != nil {
+		return 0, err
+	}
+	return level, nil
+}
+
+func TestJobDeadline(t *testing.T) {
+	assert := assert.New(t)
+	service, client := createCaptionsService("")
+	createdAt := time.Now().Add(-time.Minute)
+
+	service.AddProvider(turnaroundProvider{fakeProvider{logger: log.New()}})
+	job := &database.Job{ID: "1", Provider: "test-provider", ProviderParams: database.ProviderParams{"turnaround_level_id": "2"}, CreatedAt: createdAt}
+	assert.Nil(client.DispatchJob(context.Background(), job))
+	assert.Equal(createdAt.Add(2*time.Hour), job.Deadline)
+
+	job = &database.Job{ID: "2", Provider: "test-provider", ProviderParams: database.ProviderParams{"turnaround_level_id": "soon"}, CreatedAt: createdAt}
+	assert.Nil(client.DispatchJob(context.Background(), job))
+	assert.True(job.Deadline.IsZero())
+
+	service.AddProvider(fakeProvider{logger: log.New()})
+	job = &database.Job{ID: "3", Provider: "test-provider", ProviderParams: database.ProviderParams{}, CreatedAt: createdAt}
+	assert.Nil(client.DispatchJob(context.Background(), job))
+	assert.True(job.Deadline.IsZero())
+
+	client.ProviderTurnarounds = map[string]time.Duration{"test-provider": 24 * time.Hour}
+	job = &database.Job{ID: "4", Provider: "test-provider", ProviderParams: database.ProviderParams{}, CreatedAt: createdAt}
+	assert.Nil(client.DispatchJob(context.Background(), job))
+	assert.Equal(createdAt.Add(24*time.Hour), job.Deadline)
+}
+
+func TestOverdueJobs(t *testing.T) {
+	assert := assert.New(t)
+	hook := &fakeWebhook{}
+	hookServer := httptest.NewServer(hook)
+	defer hookServer.Close()
+
+	server := server.NewSimpleServer(&server.Config{})
+	service, client := createCaptionsService("")
+	service.AddProvider(fakeProvider{logger: log.New()})
+	server.Register(service)
+	client.CreateSubscription(context.Background(), hookServer.URL, []string{EventOverdue}, "")
+
+	now := time.Now()
+	jobs := []*database.Job{
+		{ID: "late", Deadline: now.Add(-time.Hour)},
+		{ID: "later", Deadline: now.Add(-2 * time.Hour)},
+		{ID: "on-time", Deadline: now.Add(time.Hour)},
+		{ID: "no-deadline"},
+		{ID: "done", Deadline: now.Add(-time.Hour), Done: true, Status: database.StatusDelivered},
+	}
+	for _, job := range jobs {
+		job.Provider = "test-provider"
+		job.ProviderParams = database.ProviderParams{}
+		job.CreatedAt = now.Add(-3 * time.Hour)
+		job.LastCheckedAt = now
+		if job.Status == "" {
+			job.Status = database.StatusProcessing
+		}
+		client.DB.StoreJob(context.Background(), job)
+	}
+
+	r, _ := http.NewRequest("GET", "/jobs/overdue", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(200, w.Code)
+	var overdue []database.Job
+	json.NewDecoder(w.Body).Decode(&overdue)
+	assert.Len(overdue, 2)
+	assert.Equal("later", overdue[0].ID)
+	assert.Equal("late", overdue[1].ID)
+
+	assert.Equal(2, client.checkOverdueJobs(context.Background(), now))
+	assert.Equal(0, client.checkOverdueJobs(context.Background(), now))
+
+	late, _ := client.DB.GetJob(context.Background(), "late")
+	assert.Equal(now, late.OverdueAt)
+	onTime, _ := client.DB.GetJob(context.Background(), "on-time")
+	assert.True(onTime.OverdueAt.IsZero())
+	assert.Equal(2, client.RetryDeliveries(context.Background()))
+	assert.Equal([]string{EventOverdue, EventOverdue}, hook.events())
+
+	events, _ := client.GetJobEvents(context.Background(), "late")
+	assert.Len(events, 1)
+	assert.Equal(database.JobEventOverdue, events[0].Type)
+}
+
+func TestCheckOverdueStaleJob(t *testing.T) {
+	assert := assert.New(t)
+	_, client := createCaptionsService("")
+	now := time.Now()
+	client.DB.StoreJob(context.Background(), &database.Job{
+		ID:       "123",
+		Status:   database.StatusProcessing,
+		Deadline: now.Add(-time.Hour),
+	})
+	stale, _ := client.DB.GetPendingJobs(context.Background())
+
+	// the job is delivered after it was loaded
+	client.DB.ModifyJob(context.Background(), "123", func(job *database.Job) error {
+		job.UpdateStatus(database.StatusDelivered, "")
+		job.Done = true
+		return nil
+	})
+	assert.False(client.checkOverdue(context.Background(), &stale[0], now))
+
+	job, _ := client.DB.GetJob(context.Background(), "123")
+	assert.Equal(database.StatusDelivered, job.Status)
+	assert.True(job.Done)
+	assert.True(job.OverdueAt.IsZero())
+	deliveries, _ := client.GetDeliveries(context.Background(), "123")
+	assert.Len(deliveries, 0)
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nytimes/video-captions-api/database"
+	log "github.com/sirupsen/logrus"
+)
+
+// GetJobEvents returns the history of a job, oldest first
+func (c Client) GetJobEvents(ctx context.Context, jobID string) ([]database.JobEvent, error) {
+	if _, err := c.DB.GetJob(ctx, jobID); err != nil {
+		return nil, err
+	}
+	events, err := c.DB.GetJobEvents(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if events == nil {
+		events = []database.JobEvent{}
+	}
+	sort.Stable(database.ByEventCreatedAt(events))
+	return events, nil
+}
+
+// recordEvent appends an event to the history of a job. The job provider and
+// status are recorded unless set on the event. Failures are only logged, the
+// history must not get in the way of the job.
+func (c Client) recordEvent(ctx context.Context, job *database.Job, event database.JobEvent) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		c.Logger.WithError(err).WithField("JobID", job.ID).Error("Could not create an event id")
+		return
+	}
+	event.ID = id.String()
+	event.JobID = job.ID
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+	if event.Provider == "" {
+		event.Provider = job.Provider
+	}
+	if event.Status == "" {
+		event.Status = job.Status
+	}
+	if err := c.DB.AppendJobEvent(ctx, &event); err != nil {
+		c.Logger.WithError(err).WithFields(log.Fields{"JobID": job.ID, "Event": event.Type}).Error("Could not store job event")
+	}
+}
+
+// recordDispatchAttempts records the dispatch attempts of a job, failed ones as errors
+func (c Client) recordDispatchAttempts(ctx context.Context, job *database.Job) {
+	for _, attempt := range job.DispatchAttempts {
+		event := database.JobEvent{Type: database.JobEventDispatched, CreatedAt: attempt.At, Provider: attempt.Provider}
+		if attempt.Error != "" {
+			event.Type = database.JobEventError
+			event.Details = fmt.Sprintf("dispatch failed: %s", attempt.Error)
+		} else {
+			event.Details = job.GetProviderID()
+		}
+		c.recordEvent(ctx, job, event)
+	}
+}
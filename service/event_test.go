@@ -0,0 +1,111 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/NYTimes/gizmo/server"
+	"github.com/nytimes/video-captions-api/database"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// reviewProvider reports jobs in review with a raw vendor payload
+type reviewProvider struct {
+	fakeProvider
+}
+
+func (p reviewProvider) GetProviderJob(_ context.Context, job *database.Job) (*database.ProviderJob, error) {
+	return &database.ProviderJob{
+		ID:        job.GetProviderID(),
+		Status:    database.StatusInReview,
+		RawStatus: "EDITING",
+		Payload:   []byte(`{"status":"EDITING"}`),
+	}, nil
+}
+
+func getJobEvents(t *testing.T, server *server.SimpleServer, id string) []database.JobEvent {
+	r, _ := http.NewRequest("GET", "/jobs/"+id+"/events", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(t, 200, w.Code)
+	var events []database.JobEvent
+	json.NewDecoder(w.Body).Decode(&events)
+	return events
+}
+
+func eventTypes(events []database.JobEvent) []database.JobEventType {
+	var types []database.JobEventType
+	for _, event := range events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestJobEvents(t *testing.T) {
+	assert := assert.New(t)
+	server := server.NewSimpleServer(&server.Config{})
+	service, _ := createCaptionsService("")
+	service.AddProvider(brokenProvider{logger: log.New()})
+	service.AddProvider(fakeProvider{logger: log.New(), params: map[string]bool{"jobDone": true}})
+	server.Register(service)
+
+	body := `{"media_url": "http://vp.nyt.com/video.mp4", "providers": ["broken-provider", "test-provider"], "output_types": ["vtt"]}`
+	r, _ := http.NewRequest("POST", "/captions", bytes.NewReader([]byte(body)))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(201, w.Code)
+	var job database.Job
+	json.NewDecoder(w.Body).Decode(&job)
+
+	r, _ = http.NewRequest("GET", "/jobs/"+job.ID, nil)
+	server.ServeHTTP(httptest.NewRecorder(), r)
+
+	events := getJobEvents(t, server, job.ID)
+	assert.Equal([]database.JobEventType{
+		database.JobEventCreated,
+		database.JobEventError,
+		database.JobEventDispatched,
+		database.JobEventStatusChanged,
+		database.JobEventOutputStored,
+	}, eventTypes(events))
+	for _, event := range events {
+		assert.Equal(job.ID, event.JobID)
+		assert.NotEmpty(event.ID)
+	}
+	assert.Equal("broken-provider", events[1].Provider)
+	assert.Equal("dispatch failed: provider error", events[1].Details)
+	assert.Equal("test-provider", events[2].Provider)
+	assert.Equal(database.StatusDelivered, events[3].Status)
+	assert.Equal("somepath/test-provider/"+job.Outputs[0].Filename, events[4].Details)
+
+	r, _ = http.NewRequest("GET", "/jobs/404/events", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(404, w.Code)
+}
+
+func TestJobEventsProviderStatus(t *testing.T) {
+	assert := assert.New(t)
+	service, client := createCaptionsService("")
+	service.AddProvider(reviewProvider{fakeProvider{logger: log.New()}})
+	job := &database.Job{ID: "123", Provider: "test-provider", Status: database.StatusProcessing, ProviderParams: database.ProviderParams{}}
+	client.DB.StoreJob(context.Background(), job)
+
+	client.GetJob(context.Background(), "123")
+	// unchanged statuses aren't recorded again
+	client.GetJob(context.Background(), "123")
+	client.CancelJob(context.Background(), "123")
+
+	events, err := client.GetJobEvents(context.Background(), "123")
+	assert.Nil(err)
+	assert.Equal([]database.JobEventType{database.JobEventStatusChanged, database.JobEventCancelled}, eventTypes(events))
+	assert.Equal(database.StatusInReview, events[0].Status)
+	assert.Equal("EDITING", events[0].ProviderStatus)
+	assert.Equal(`{"status":"EDITING"}`, events[0].Payload)
+	assert.Equal(database.StatusCancelled, events[1].Status)
+}
@@ -2,20 +2,26 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/NYTimes/gizmo/server"
 	"github.com/nytimes/video-captions-api/database"
+	"github.com/nytimes/video-captions-api/providers"
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
 )
 
+// legacyCallbackProvider is the provider handling callbacks posted to /callback
+const legacyCallbackProvider = "3play"
+
 type captionsError struct {
 	Message string `json:"error"`
 }
@@ -25,36 +31,43 @@ type jobParams struct {
 	ParentID       string                  `json:"parent_id"`
 	MediaURL       string                  `json:"media_url"`
 	Provider       string                  `json:"provider"`
+	Providers      []string                `json:"providers"`
 	ProviderParams database.ProviderParams `json:"provider_params"`
 	OutputTypes    []string                `json:"output_types"`
 	Language       string                  `json:"language"`
 	CaptionFile    uploadedFile            `json:"caption_file,omitempty"`
+	Tenant         string                  `json:"tenant"`
+	CallbackURL    string                  `json:"callback_url"`
+	// Priority orders the dispatch queue, higher first
+	Priority int `json:"priority"`
 }
 
-type uploadedFile struct {
-	File []byte `json:"file"`
-	Name string `json:"name"`
+type importParams struct {
+	jobParams
+	ProviderID string `json:"provider_id"`
+}
+
+type subscriptionParams struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
 }
 
-type Callback struct {
-	Code int          `json:"code"`
-	Data CallbackData `json:"data"`
+type resubmitParams struct {
+	Provider       string                  `json:"provider"`
+	Turnaround     string                  `json:"turnaround_level_id"`
+	ProviderParams database.ProviderParams `json:"provider_params"`
 }
 
-type CallbackData struct {
-	Cancellable         bool    `json:"cancellable"`
-	Default             bool    `json:"default"`
-	ID                  int     `json:"id"`
-	BatchID             int     `json:"batch_id"`
-	LanguageID          int     `json:"language_id"`
-	MediaFileID         int     `json:"media_file_id"`
-	LanguageIDs         []int   `json:"language_ids"`
-	CancellationDetails string  `json:"cancellation_details"`
-	CancellationReason  string  `json:"cancellation_reason"`
-	ReferenceID         string  `json:"reference_id"`
-	Status              string  `json:"status"`
-	Type                string  `json:"type"`
-	Duration            float64 `json:"duration"`
+type estimateParams struct {
+	jobParams
+	// Duration is the media duration in seconds
+	Duration float64 `json:"duration"`
+}
+
+type uploadedFile struct {
+	File []byte `json:"file"`
+	Name string `json:"name"`
 }
 
 func newJobFromParams(newJob jobParams) (*database.Job, error) {
@@ -80,11 +93,9 @@ func newJobFromParams(newJob jobParams) (*database.Job, error) {
 	}
 
 	databaseJob := &database.Job{
-		ID:       id.String(),
-		ParentID: newJob.ParentID,
-		//nolint:godox
-		//TODO: put all possible status under a type/consts so we dont use strings everywhere
-		Status:         "processing",
+		ID:             id.String(),
+		ParentID:       newJob.ParentID,
+		Status:         database.StatusProcessing,
 		MediaURL:       newJob.MediaURL,
 		Provider:       newJob.Provider,
 		ProviderParams: newJob.ProviderParams,
@@ -93,6 +104,10 @@ func newJobFromParams(newJob jobParams) (*database.Job, error) {
 		Done:           false,
 		Language:       newJob.Language,
 		JobType:        newJob.JobType,
+		Providers:      newJob.Providers,
+		Tenant:         newJob.Tenant,
+		CallbackURL:    newJob.CallbackURL,
+		Priority:       newJob.Priority,
 	}
 
 	if newJob.CaptionFile.File != nil {
@@ -112,7 +127,7 @@ func (e captionsError) Error() string {
 // GetJobs returns all the Jobs associated with a ParentID
 func (s *CaptionsService) GetJobs(r *http.Request) (int, interface{}, error) {
 	parentID := server.Vars(r)["id"]
-	jobs, err := s.client.GetJobs(parentID)
+	jobs, err := s.client.GetJobs(r.Context(), parentID)
 	if err != nil {
 		if err == database.ErrNoJobs {
 			return http.StatusNotFound, nil, captionsError{err.Error()}
@@ -125,9 +140,14 @@ func (s *CaptionsService) GetJobs(r *http.Request) (int, interface{}, error) {
 // GetJob returns a Job given its ID
 func (s *CaptionsService) GetJob(r *http.Request) (int, interface{}, error) {
 	id := server.Vars(r)["id"]
+	// routes are registered in no particular order, /jobs/{id} may be matched
+	// first. Job IDs are UUIDs so no job is called overdue.
+	if id == "overdue" {
+		return s.GetOverdueJobs(r)
+	}
 	//nolint:godox
 	// TODO: on the 3play client, we should look at the errors field and check for not_found errors at least
-	job, err := s.client.GetJob(id)
+	job, err := s.client.GetJob(r.Context(), id)
 	if err != nil {
 		if err == database.ErrJobNotFound {
 			return http.StatusNotFound, nil, captionsError{err.Error()}
@@ -137,10 +157,19 @@ func (s *CaptionsService) GetJob(r *http.Request) (int, interface{}, error) {
 	return http.StatusOK, job, nil
 }
 
+// GetOverdueJobs lists the jobs that aren't done past their deadline
+func (s *CaptionsService) GetOverdueJobs(r *http.Request) (int, interface{}, error) {
+	jobs, err := s.client.GetOverdueJobs(r.Context())
+	if err != nil {
+		return http.StatusInternalServerError, nil, captionsError{err.Error()}
+	}
+	return http.StatusOK, jobs, nil
+}
+
 // CancelJob cancels a given Job by its ID
 func (s *CaptionsService) CancelJob(r *http.Request) (int, interface{}, error) {
 	id := server.Vars(r)["id"]
-	canceled, err := s.client.CancelJob(id)
+	canceled, err := s.client.CancelJob(r.Context(), id)
 	if err != nil {
 		return http.StatusNotFound, nil, captionsError{err.Error()}
 	}
@@ -150,6 +179,165 @@ func (s *CaptionsService) CancelJob(r *http.Request) (int, interface{}, error) {
 	return http.StatusOK, nil, nil
 }
 
+// ImportJob creates a job from a job ordered directly with a provider
+func (s *CaptionsService) ImportJob(r *http.Request) (int, interface{}, error) {
+	requestLogger := s.logger.WithFields(log.Fields{
+		"Handler": "ImportJob",
+		"Method":  r.Method,
+		"URI":     r.RequestURI,
+	})
+	params := importParams{jobParams: jobParams{
+		Language:       "en",
+		OutputTypes:    []string{"vtt"},
+		ProviderParams: make(database.ProviderParams),
+	}}
+	defer r.Body.Close()
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return http.StatusBadRequest, nil, captionsError{err.Error()}
+	}
+	if err := json.Unmarshal(data, &params); err != nil {
+		requestLogger.WithError(err).Error("Could not import job from request body")
+		return http.StatusBadRequest, nil, captionsError{"Malformed parameters"}
+	}
+	if params.Provider == "" || params.ProviderID == "" {
+		return http.StatusBadRequest, nil, captionsError{"Please provide a provider and provider_id"}
+	}
+	if params.ProviderParams == nil {
+		params.ProviderParams = make(database.ProviderParams)
+	}
+	if params.MediaURL == "" {
+		// outputs are named after the media, or the caption file name when there's none
+		params.CaptionFile = uploadedFile{Name: params.ProviderID}
+	}
+	job, err := newJobFromParams(params.jobParams)
+	if err != nil {
+		return http.StatusInternalServerError, nil, captionsError{err.Error()}
+	}
+	job.Providers = nil
+
+	imported, err := s.client.ImportJob(r.Context(), job, params.ProviderID)
+	switch err.(type) {
+	case nil:
+		return http.StatusCreated, imported, nil
+	case providers.ValidationError:
+		return http.StatusBadRequest, nil, captionsError{err.Error()}
+	}
+	switch err {
+	case providers.ErrProviderNotFound:
+		return http.StatusBadRequest, nil, captionsError{err.Error()}
+	case ErrImportNotSupported, ErrJobAlreadyImported:
+		return http.StatusConflict, imported, captionsError{err.Error()}
+	}
+	requestLogger.WithError(err).Error("could not import job")
+	return http.StatusInternalServerError, nil, captionsError{err.Error()}
+}
+
+// CreateSubscription registers a webhook notified of the events of every job
+func (s *CaptionsService) CreateSubscription(r *http.Request) (int, interface{}, error) {
+	var params subscriptionParams
+	defer r.Body.Close()
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return http.StatusBadRequest, nil, captionsError{err.Error()}
+	}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return http.StatusBadRequest, nil, captionsError{"Malformed parameters"}
+	}
+	subscription, err := s.client.CreateSubscription(r.Context(), params.URL, params.Events, params.Secret)
+	switch err {
+	case nil:
+		return http.StatusCreated, subscription, nil
+	case ErrInvalidWebhookURL, ErrInvalidWebhookEvent:
+		return http.StatusBadRequest, nil, captionsError{err.Error()}
+	}
+	return http.StatusInternalServerError, nil, captionsError{err.Error()}
+}
+
+// GetSubscriptions lists the registered webhooks
+func (s *CaptionsService) GetSubscriptions(r *http.Request) (int, interface{}, error) {
+	subscriptions, err := s.client.GetSubscriptions(r.Context())
+	if err != nil {
+		return http.StatusInternalServerError, nil, captionsError{err.Error()}
+	}
+	return http.StatusOK, subscriptions, nil
+}
+
+// DeleteSubscription unregisters a webhook
+func (s *CaptionsService) DeleteSubscription(r *http.Request) (int, interface{}, error) {
+	id := server.Vars(r)["id"]
+	err := s.client.DeleteSubscription(r.Context(), id)
+	switch err {
+	case nil:
+		return http.StatusOK, nil, nil
+	case database.ErrSubscriptionNotFound:
+		return http.StatusNotFound, nil, captionsError{err.Error()}
+	}
+	return http.StatusInternalServerError, nil, captionsError{err.Error()}
+}
+
+// GetJobEvents returns the history of a job
+func (s *CaptionsService) GetJobEvents(r *http.Request) (int, interface{}, error) {
+	id := server.Vars(r)["id"]
+	events, err := s.client.GetJobEvents(r.Context(), id)
+	switch err {
+	case nil:
+		return http.StatusOK, events, nil
+	case database.ErrJobNotFound:
+		return http.StatusNotFound, nil, captionsError{err.Error()}
+	}
+	return http.StatusInternalServerError, nil, captionsError{err.Error()}
+}
+
+// GetDeliveries lists the webhook deliveries of a job
+func (s *CaptionsService) GetDeliveries(r *http.Request) (int, interface{}, error) {
+	id := server.Vars(r)["id"]
+	deliveries, err := s.client.GetDeliveries(r.Context(), id)
+	switch err {
+	case nil:
+		return http.StatusOK, deliveries, nil
+	case database.ErrJobNotFound:
+		return http.StatusNotFound, nil, captionsError{err.Error()}
+	}
+	return http.StatusInternalServerError, nil, captionsError{err.Error()}
+}
+
+// ResubmitJob dispatches a job in error again, optionally to another provider
+func (s *CaptionsService) ResubmitJob(r *http.Request) (int, interface{}, error) {
+	id := server.Vars(r)["id"]
+	var params resubmitParams
+	defer r.Body.Close()
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return http.StatusBadRequest, nil, captionsError{err.Error()}
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &params); err != nil {
+			return http.StatusBadRequest, nil, captionsError{"Malformed parameters"}
+		}
+	}
+	job, err := s.client.ResubmitJob(r.Context(), id, Resubmission{
+		Provider:       params.Provider,
+		Turnaround:     params.Turnaround,
+		ProviderParams: params.ProviderParams,
+	})
+	switch err.(type) {
+	case nil:
+		return http.StatusOK, job, nil
+	case providers.ValidationError:
+		return http.StatusBadRequest, nil, captionsError{err.Error()}
+	}
+	switch err {
+	case database.ErrJobNotFound:
+		return http.StatusNotFound, nil, captionsError{err.Error()}
+	case providers.ErrProviderNotFound:
+		return http.StatusBadRequest, nil, captionsError{err.Error()}
+	case ErrJobNotResubmittable:
+		return http.StatusConflict, nil, captionsError{err.Error()}
+	}
+	return http.StatusInternalServerError, nil, captionsError{err.Error()}
+}
+
 // CreateJob create a Job
 func (s *CaptionsService) CreateJob(r *http.Request) (int, interface{}, error) {
 	requestLogger := s.logger.WithFields(log.Fields{
@@ -181,13 +369,35 @@ func (s *CaptionsService) CreateJob(r *http.Request) (int, interface{}, error) {
 		return http.StatusBadRequest, nil, captionsError{"Please provide a media_url or caption_file"}
 	}
 
+	if params.CallbackURL != "" && validateWebhookURL(params.CallbackURL) != nil {
+		return http.StatusBadRequest, nil, captionsError{"callback_url must be an absolute http or https URL"}
+	}
+
 	job, err := newJobFromParams(params)
 	if err != nil {
 		requestLogger.WithError(err).Error("could not create job from parameters")
 		return http.StatusInternalServerError, nil, captionsError{err.Error()}
 	}
 
-	err = s.client.DispatchJob(job)
+	s.client.RouteJob(job)
+
+	err = s.client.ValidateJob(job)
+	if err != nil {
+		requestLogger.WithError(err).Error("job is not supported by provider")
+		return http.StatusBadRequest, nil, captionsError{err.Error()}
+	}
+
+	if s.client.Queue != nil {
+		err = s.client.EnqueueJob(r.Context(), job)
+	} else {
+		err = s.client.DispatchJob(r.Context(), job)
+	}
+	var indeterminateErr *IndeterminateDispatchError
+	if errors.As(err, &indeterminateErr) {
+		// the job is stored in error, its ID is needed to reconcile it
+		requestLogger.WithError(err).Error("job dispatch outcome is unknown")
+		return http.StatusAccepted, job, nil
+	}
 	if err != nil {
 		requestLogger.WithError(err).Error("could not dispatch job")
 		return http.StatusInternalServerError, nil, captionsError{err.Error()}
@@ -196,6 +406,74 @@ func (s *CaptionsService) CreateJob(r *http.Request) (int, interface{}, error) {
 	return http.StatusCreated, job, nil
 }
 
+// RoutingDryRun returns the provider routing rules would pick for a job, and why
+func (s *CaptionsService) RoutingDryRun(r *http.Request) (int, interface{}, error) {
+	if s.client.Router == nil {
+		return http.StatusNotFound, nil, captionsError{"routing is not configured"}
+	}
+	params := jobParams{Language: "en"}
+	defer r.Body.Close()
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return http.StatusBadRequest, nil, captionsError{err.Error()}
+	}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return http.StatusBadRequest, nil, captionsError{"Malformed parameters"}
+	}
+	job, err := newJobFromParams(params)
+	if err != nil {
+		return http.StatusInternalServerError, nil, captionsError{err.Error()}
+	}
+	return http.StatusOK, s.client.Router.Route(routingInput(job)), nil
+}
+
+// EstimateJob returns the estimated cost and delivery time of a job without dispatching it
+func (s *CaptionsService) EstimateJob(r *http.Request) (int, interface{}, error) {
+	params := estimateParams{jobParams: jobParams{
+		Language:       "en",
+		OutputTypes:    []string{"vtt"},
+		ProviderParams: make(database.ProviderParams),
+	}}
+	defer r.Body.Close()
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return http.StatusBadRequest, nil, captionsError{err.Error()}
+	}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return http.StatusBadRequest, nil, captionsError{"Malformed parameters"}
+	}
+	if params.Duration <= 0 {
+		return http.StatusBadRequest, nil, captionsError{"Please provide the media duration in seconds"}
+	}
+	job, err := newJobFromParams(params.jobParams)
+	if err != nil {
+		return http.StatusInternalServerError, nil, captionsError{err.Error()}
+	}
+	s.client.RouteJob(job)
+	duration := time.Duration(params.Duration * float64(time.Second))
+	return http.StatusOK, s.client.EstimateJob(job, duration), nil
+}
+
+// GetProviders returns the capabilities of all registered providers
+func (s *CaptionsService) GetProviders(r *http.Request) (int, interface{}, error) {
+	return http.StatusOK, s.client.GetProviders(), nil
+}
+
+// GetProviderStatus returns the circuit breaker state of all registered providers
+func (s *CaptionsService) GetProviderStatus(r *http.Request) (int, interface{}, error) {
+	return http.StatusOK, s.client.GetProviderStatus(), nil
+}
+
+// GetProvider returns the capabilities of a provider given its name
+func (s *CaptionsService) GetProvider(r *http.Request) (int, interface{}, error) {
+	name := server.Vars(r)["name"]
+	capabilities, err := s.client.GetProvider(name)
+	if err != nil {
+		return http.StatusNotFound, nil, captionsError{err.Error()}
+	}
+	return http.StatusOK, capabilities, nil
+}
+
 // DownloadCaption downloads a caption in the specified format
 func (s *CaptionsService) DownloadCaption(w http.ResponseWriter, r *http.Request) {
 	id := server.Vars(r)["id"]
@@ -203,7 +481,7 @@ func (s *CaptionsService) DownloadCaption(w http.ResponseWriter, r *http.Request
 
 	defer r.Body.Close()
 
-	captionFile, err := s.client.DownloadCaption(id, captionFormat)
+	captionFile, err := s.client.DownloadCaption(r.Context(), id, captionFormat)
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
 	}
@@ -213,6 +491,66 @@ func (s *CaptionsService) DownloadCaption(w http.ResponseWriter, r *http.Request
 	w.Write(captionFile)
 }
 
+// UpdateCaption uploads a new version of the caption file of an upload job
+func (s *CaptionsService) UpdateCaption(r *http.Request) (int, interface{}, error) {
+	id := server.Vars(r)["id"]
+	var params jobParams
+	defer r.Body.Close()
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return http.StatusBadRequest, nil, captionsError{err.Error()}
+	}
+	if err := json.Unmarshal(data, &params); err != nil {
+		return http.StatusBadRequest, nil, captionsError{"Malformed parameters"}
+	}
+	if params.CaptionFile.File == nil {
+		return http.StatusBadRequest, nil, captionsError{"Please provide a caption_file"}
+	}
+	job, err := s.client.UpdateCaption(r.Context(), id, database.UploadedFile{
+		File: params.CaptionFile.File,
+		Name: params.CaptionFile.Name,
+	})
+	switch err.(type) {
+	case nil:
+		return http.StatusOK, job, nil
+	case providers.ValidationError:
+		return http.StatusBadRequest, nil, captionsError{err.Error()}
+	}
+	switch err {
+	case database.ErrJobNotFound:
+		return http.StatusNotFound, nil, captionsError{err.Error()}
+	case ErrCaptionUpdatesNotSupported, providers.ErrCaptionNotUpdatable:
+		return http.StatusConflict, nil, captionsError{err.Error()}
+	}
+	return http.StatusInternalServerError, nil, captionsError{err.Error()}
+}
+
+// GetCaptionVersion returns a version of the caption file of an upload job
+func (s *CaptionsService) GetCaptionVersion(w http.ResponseWriter, r *http.Request) {
+	id := server.Vars(r)["id"]
+	version, err := strconv.Atoi(server.Vars(r)["version"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	caption, file, err := s.client.GetCaptionVersion(r.Context(), id, version)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if file == nil {
+		http.Redirect(w, r, caption.URL, http.StatusFound)
+		return
+	}
+	format := strings.TrimPrefix(filepath.Ext(caption.Name), ".")
+	if format == "" {
+		format = "plain"
+	}
+	w.Header().Set("Content-Type", fmt.Sprintf("text/%s; charset=utf-8", format))
+	w.WriteHeader(http.StatusOK)
+	w.Write(file)
+}
+
 // GetTranscript returns a transcript of a given caption job
 func (s *CaptionsService) GetTranscript(w http.ResponseWriter, r *http.Request) {
 	id := server.Vars(r)["id"]
@@ -220,7 +558,7 @@ func (s *CaptionsService) GetTranscript(w http.ResponseWriter, r *http.Request)
 
 	defer r.Body.Close()
 
-	captionFile, err := s.client.DownloadCaption(id, captionFormat)
+	captionFile, err := s.client.DownloadCaption(r.Context(), id, captionFormat)
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
 	}
@@ -234,11 +572,18 @@ func (s *CaptionsService) GetTranscript(w http.ResponseWriter, r *http.Request)
 	w.Write([]byte(transcript))
 }
 
+// ProcessCallback processes a status callback from the provider in the URL,
+// /callback without a provider is kept as an alias for 3play callbacks
 func (s *CaptionsService) ProcessCallback(r *http.Request) (int, interface{}, error) {
+	providerName := server.Vars(r)["provider"]
+	if providerName == "" {
+		providerName = legacyCallbackProvider
+	}
 	requestLogger := s.logger.WithFields(log.Fields{
-		"Handler": "ProcessCallback",
-		"Method":  r.Method,
-		"URI":     r.RequestURI,
+		"Handler":  "ProcessCallback",
+		"Method":   r.Method,
+		"URI":      r.RequestURI,
+		"Provider": providerName,
 	})
 
 	defer r.Body.Close()
@@ -249,21 +594,30 @@ func (s *CaptionsService) ProcessCallback(r *http.Request) (int, interface{}, er
 		return http.StatusBadRequest, nil, captionsError{err.Error()}
 	}
 
-	callbackObject := Callback{}
-	err = json.Unmarshal(data, &callbackObject)
+	err = s.client.VerifyCallback(providerName, r.URL.Query(), r.Header, data, time.Now())
 	if err != nil {
-		requestLogger.WithError(err).Error("Could not unmarshal callback")
-		return http.StatusBadRequest, nil, captionsError{"Malformed parameters"}
+		callbackAuthFailures.WithLabelValues(providerName).Inc()
+		requestLogger.WithError(err).Warn("Rejected unauthenticated callback")
+		return http.StatusUnauthorized, nil, captionsError{err.Error()}
 	}
 
-	requestLogger.Infof("Received a callback for ID: %v", callbackObject.Data.ID)
+	callback, err := s.client.ParseCallback(providerName, r.URL.Query(), data)
+	if err != nil {
+		if err == providers.ErrProviderNotFound || err == ErrCallbacksNotSupported {
+			return http.StatusNotFound, nil, captionsError{err.Error()}
+		}
+		requestLogger.WithError(err).Error("Could not parse callback")
+		if err == providers.ErrInvalidProviderID {
+			return http.StatusBadRequest, nil, captionsError{err.Error()}
+		}
+		return http.StatusBadRequest, nil, captionsError{"Malformed parameters"}
+	}
 
-	queryParams := r.URL.Query()
-	jobID := queryParams.Get("job_id")
+	requestLogger.Infof("Received a callback for ID: %v", callback.ProviderID)
 
-	err = s.client.ProcessCallback(callbackObject.Data, jobID)
+	err = s.client.ProcessCallback(r.Context(), providerName, callback)
 	if err != nil {
-		requestLogger.Errorf("Could not process callback for ID: %v", callbackObject.Data.ID)
+		requestLogger.Errorf("Could not process callback for ID: %v", callback.ProviderID)
 		return http.StatusInternalServerError, nil, captionsError{err.Error()}
 	}
 	return http.StatusOK, nil, nil
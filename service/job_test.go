@@ -1,6 +1,8 @@
 package service
 
 import (
+	"context"
+	"sync"
 	"testing"
 
 	"bytes"
@@ -11,10 +13,15 @@ import (
 
 	"github.com/NYTimes/gizmo/server"
 	"github.com/nytimes/video-captions-api/database"
+	"github.com/nytimes/video-captions-api/providers"
+	"github.com/nytimes/video-captions-api/routing"
 	"github.com/stretchr/testify/assert"
 
 	"io/ioutil"
 	"net/http/httptest"
+	"time"
+
+	"github.com/nytimes/video-captions-api/config"
 )
 
 func TestCreateJob(t *testing.T) {
@@ -111,6 +118,476 @@ func TestCreateJobDispatchError(t *testing.T) {
 	assert.Equal(500, status)
 }
 
+func TestCreateJobValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  string
+		message string
+	}{
+		{
+			name:    "Unknown provider",
+			params:  `{"media_url": "http://vp.nyt.com/video.mp4", "provider": "nope"}`,
+			message: "provider not found",
+		},
+		{
+			name:    "Unsupported output type",
+			params:  `{"media_url": "http://vp.nyt.com/video.mp4", "provider": "test-provider", "output_types": ["vtt", "dfxp"]}`,
+			message: `test-provider: unsupported output type "dfxp", supported types are: vtt, srt, sbv, ssa`,
+		},
+		{
+			name:    "Unsupported language",
+			params:  `{"media_url": "http://vp.nyt.com/video.mp4", "provider": "test-provider", "language": "fr"}`,
+			message: `test-provider: unsupported language "fr", supported languages are: en, es`,
+		},
+		{
+			name:    "Unknown provider param",
+			params:  `{"media_url": "http://vp.nyt.com/video.mp4", "provider": "test-provider", "provider_params": {"fidelity": "high"}}`,
+			message: `test-provider: unknown provider param "fidelity"`,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			service, client := createCaptionsService("")
+			service.AddProvider(fakeProvider{logger: client.Logger})
+			r, _ := http.NewRequest("POST", "/captions", bytes.NewReader([]byte(test.params)))
+			status, resultJob, err := service.CreateJob(r)
+			assert.Equal(400, status)
+			assert.Nil(resultJob)
+			assert.EqualError(err, test.message)
+		})
+	}
+}
+
+func TestCreateJobFailover(t *testing.T) {
+	assert := assert.New(t)
+	service, client := createCaptionsService("")
+	service.AddProvider(brokenProvider{logger: client.Logger})
+	service.AddProvider(fakeProvider{logger: client.Logger})
+	params := `{"media_url": "http://vp.nyt.com/video.mp4", "providers": ["broken-provider", "test-provider"]}`
+	r, _ := http.NewRequest("POST", "/captions", bytes.NewReader([]byte(params)))
+	status, resultJob, err := service.CreateJob(r)
+	assert.Nil(err)
+	assert.Equal(201, status)
+	job := resultJob.(*database.Job)
+	assert.Equal("test-provider", job.Provider)
+	assert.Equal([]string{"broken-provider", "test-provider"}, job.Providers)
+	assert.Len(job.DispatchAttempts, 2)
+}
+
+func newTestRouter(t *testing.T) *routing.Router {
+	router, err := routing.NewRouter([]routing.Rule{
+		{Name: "spanish", Languages: []string{"es"}, Provider: "test-provider"},
+		{Name: "default", Provider: "broken-provider"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return router
+}
+
+func TestCreateJobRouted(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   string
+		status   int
+		provider string
+	}{
+		{
+			name:     "Matching rule",
+			params:   `{"media_url": "http://vp.nyt.com/video.mp4", "language": "es"}`,
+			status:   201,
+			provider: "test-provider",
+		},
+		{
+			name:     "Explicit provider",
+			params:   `{"media_url": "http://vp.nyt.com/video.mp4", "language": "en", "provider": "test-provider"}`,
+			status:   201,
+			provider: "test-provider",
+		},
+		{
+			name:   "Default rule",
+			params: `{"media_url": "http://vp.nyt.com/video.mp4", "language": "en"}`,
+			status: 500,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			assert := assert.New(t)
+			service, client := createCaptionsService("")
+			service.AddProvider(fakeProvider{logger: client.Logger})
+			service.AddProvider(brokenProvider{logger: client.Logger})
+			service.SetRouter(newTestRouter(t))
+			r, _ := http.NewRequest("POST", "/captions", bytes.NewReader([]byte(test.params)))
+			status, resultJob, _ := service.CreateJob(r)
+			assert.Equal(test.status, status)
+			if test.provider != "" {
+				assert.Equal(test.provider, resultJob.(*database.Job).Provider)
+			}
+		})
+	}
+}
+
+func TestRoutingDryRun(t *testing.T) {
+	assert := assert.New(t)
+	server := server.NewSimpleServer(&server.Config{})
+	service, _ := createCaptionsService("")
+	service.SetRouter(newTestRouter(t))
+	server.Register(service)
+	body := []byte(`{"media_url": "http://vp.nyt.com/video.mp4", "language": "fr"}`)
+	r, _ := http.NewRequest("POST", "/routing/dry-run", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(200, w.Code)
+	var decision routing.Decision
+	err := json.NewDecoder(w.Body).Decode(&decision)
+	if err != nil {
+		t.Errorf("%s: unable to JSON decode response body: %s", w.Body, err)
+	}
+	assert.Equal(routing.Decision{
+		Matched:  true,
+		Provider: "broken-provider",
+		Rule:     "default",
+		Reasons:  []string{"rule has no conditions"},
+		Skipped:  []routing.Mismatch{{Rule: "spanish", Reason: `language "fr" does not match es`}},
+	}, decision)
+}
+
+func TestRoutingDryRunNotConfigured(t *testing.T) {
+	service, _ := createCaptionsService("")
+	r, _ := http.NewRequest("POST", "/routing/dry-run", bytes.NewReader([]byte(`{}`)))
+	status, _, err := service.RoutingDryRun(r)
+	assert.Equal(t, 404, status)
+	assert.EqualError(t, err, "routing is not configured")
+}
+
+func TestEstimateJob(t *testing.T) {
+	assert := assert.New(t)
+	server := server.NewSimpleServer(&server.Config{})
+	service, client := createCaptionsService("")
+	service.AddProvider(fakeProvider{logger: client.Logger})
+	service.AddProvider(providers.NewAmaraProvider(&providers.AmaraConfig{
+		RatePerMinute: 1.5,
+		Currency:      "USD",
+		Turnaround:    48 * time.Hour,
+	}, &config.CaptionsServiceConfig{Logger: client.Logger}))
+	server.Register(service)
+
+	tests := []struct {
+		name      string
+		body      string
+		estimates []JobEstimate
+	}{
+		{
+			"Candidates",
+			`{"media_url": "http://vp.nyt.com/video.mp4", "providers": ["amara", "test-provider"], "duration": 150}`,
+			[]JobEstimate{
+				{Provider: "amara", Cost: 3.75, Currency: "USD", Details: "1.50 USD per minute"},
+				{Provider: "test-provider", Error: "provider does not support estimates"},
+			},
+		},
+		{
+			"Estimators",
+			`{"media_url": "http://vp.nyt.com/video.mp4", "duration": 60}`,
+			[]JobEstimate{{Provider: "amara", Cost: 1.5, Currency: "USD", Details: "1.50 USD per minute"}},
+		},
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest("POST", "/captions/estimate", bytes.NewReader([]byte(test.body)))
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		assert.Equal(200, w.Code, test.name)
+		var estimates []JobEstimate
+		if err := json.NewDecoder(w.Body).Decode(&estimates); err != nil {
+			t.Fatalf("%s: unable to JSON decode response body: %s", w.Body, err)
+		}
+		for i := range estimates {
+			if estimates[i].ETA != nil {
+				assert.WithinDuration(time.Now().Add(48*time.Hour), *estimates[i].ETA, time.Minute, test.name)
+				estimates[i].ETA = nil
+			}
+		}
+		assert.Equal(test.estimates, estimates, test.name)
+	}
+}
+
+func TestEstimateJobMissingDuration(t *testing.T) {
+	service, _ := createCaptionsService("")
+	r, _ := http.NewRequest("POST", "/captions/estimate", bytes.NewReader([]byte(`{"provider": "amara"}`)))
+	status, _, err := service.EstimateJob(r)
+	assert.Equal(t, 400, status)
+	assert.EqualError(t, err, "Please provide the media duration in seconds")
+}
+
+func TestUpdateCaption(t *testing.T) {
+	assert := assert.New(t)
+	callbacks := make(chan database.Job, 1)
+	callbackServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var job database.Job
+		json.NewDecoder(r.Body).Decode(&job)
+		callbacks <- job
+	}))
+	defer callbackServer.Close()
+	server := server.NewSimpleServer(&server.Config{})
+	service, client := createCaptionsService(callbackServer.URL)
+	storage := memoryStorage{}
+	service.client.Storage = storage
+	service.AddProvider(fakeProvider{logger: client.Logger})
+	service.AddProvider(providers.NewUploadProvider(&config.CaptionsServiceConfig{Logger: client.Logger}, client.DB))
+	server.Register(service)
+	first := "WEBVTT\n\n00:00:00.000 --> 00:00:01.000\nFirst"
+	second := "WEBVTT\n\n00:00:00.000 --> 00:00:01.000\nSecond"
+	encode := func(caption string) string {
+		data, _ := json.Marshal([]byte(caption))
+		return string(data)
+	}
+
+	body := fmt.Sprintf(`{"provider": "upload", "caption_file": {"file": %s, "name": "video.vtt"}}`, encode(first))
+	r, _ := http.NewRequest("POST", "/captions", bytes.NewReader([]byte(body)))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(201, w.Code)
+	var job database.Job
+	json.NewDecoder(w.Body).Decode(&job)
+	assert.Equal("Version 1", job.ProviderParams["details"])
+	synced, err := client.GetJob(context.Background(), job.ID)
+	assert.Nil(err)
+	assert.Equal("delivered", synced.ProviderStatus)
+
+	body = fmt.Sprintf(`{"caption_file": {"file": %s}}`, encode(second))
+	r, _ = http.NewRequest("PUT", "/jobs/"+job.ID+"/caption", bytes.NewReader([]byte(body)))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(200, w.Code)
+	var updated database.Job
+	json.NewDecoder(w.Body).Decode(&updated)
+	assert.Equal(job.ID, updated.ID)
+	assert.Equal(database.StatusDelivered, updated.Status)
+	assert.Equal("Version 2", updated.ProviderParams["details"])
+	assert.True(updated.Done)
+	assert.Equal("https://storage.googleapis.com/bucket/upload/"+job.Outputs[0].Filename, updated.Outputs[0].URL)
+	assert.Len(updated.CaptionVersions, 1)
+	assert.Equal(1, updated.CaptionVersions[0].Version)
+	assert.Equal("video.vtt", updated.CaptionVersions[0].Name)
+	// the replaced file is only referenced by the job
+	versionFile := "upload/" + job.ID + "/versions/1/video.vtt"
+	versionURL := "https://storage.googleapis.com/bucket/" + versionFile
+	assert.Equal(versionURL, updated.CaptionVersions[0].URL)
+	assert.Equal(first, string(storage[versionFile]))
+	select {
+	case notified := <-callbacks:
+		assert.Equal("Version 2", notified.ProviderParams["details"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("callback URL was not notified")
+	}
+
+	r, _ = http.NewRequest("GET", "/jobs/"+job.ID+"/caption/1", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(302, w.Code)
+	assert.Equal(versionURL, w.Header().Get("Location"))
+
+	r, _ = http.NewRequest("GET", "/jobs/"+job.ID+"/caption/2", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(200, w.Code)
+	assert.Equal("text/vtt; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(second, w.Body.String())
+
+	r, _ = http.NewRequest("GET", "/jobs/"+job.ID+"/caption/3", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(404, w.Code)
+}
+
+func TestUpdateCaptionErrors(t *testing.T) {
+	server := server.NewSimpleServer(&server.Config{})
+	service, client := createCaptionsService("")
+	service.AddProvider(fakeProvider{logger: client.Logger})
+	service.AddProvider(providers.NewUploadProvider(&config.CaptionsServiceConfig{Logger: client.Logger}, client.DB))
+	upload := &database.Job{
+		ID:             "upload-job",
+		Provider:       "upload",
+		CaptionFile:    database.UploadedFile{File: []byte("WEBVTT\n"), Name: "video.vtt"},
+		ProviderParams: database.ProviderParams{"ProviderID": "upload-job"},
+	}
+	client.DB.StoreJob(context.Background(), upload)
+	client.DB.StoreJob(context.Background(), &database.Job{ID: "vendor-job", Provider: "test-provider", ProviderParams: database.ProviderParams{}})
+	client.DB.StoreJob(context.Background(), &database.Job{
+		ID:             "cancelled-job",
+		Provider:       "upload",
+		Status:         database.StatusCancelled,
+		Done:           true,
+		CaptionFile:    database.UploadedFile{File: []byte("WEBVTT\n"), Name: "video.vtt"},
+		ProviderParams: database.ProviderParams{"ProviderID": "cancelled-job"},
+	})
+
+	tests := []struct {
+		name   string
+		id     string
+		body   string
+		status int
+		err    string
+	}{
+		{"Missing file", "upload-job", `{}`, 400, "Please provide a caption_file"},
+		{"Invalid file", "upload-job", `{"caption_file": {"file": "bm90IHZ0dA==", "name": "fix.vtt"}}`, 400, `upload: [header] invalid signature, expecting: "WEBVTT", got: "not" [line 1]`},
+		{"Not an upload", "vendor-job", `{"caption_file": {"file": "V0VCVlRUCg=="}}`, 409, "caption of this job cannot be updated"},
+		{"Not found", "404", `{"caption_file": {"file": "V0VCVlRUCg=="}}`, 404, "job not found"},
+		{"Cancelled", "cancelled-job", `{"caption_file": {"file": "V0VCVlRUCg=="}}`, 409, "caption of a cancelled or failed job cannot be updated"},
+	}
+	server.Register(service)
+	for _, test := range tests {
+		r, _ := http.NewRequest("PUT", "/jobs/"+test.id+"/caption", bytes.NewReader([]byte(test.body)))
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		var body map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&body)
+		assert.Equal(t, test.status, w.Code, test.name)
+		assert.Equal(t, test.err, body["error"], test.name)
+	}
+	cancelled, _ := client.DB.GetJob(context.Background(), "cancelled-job")
+	assert.Equal(t, database.StatusCancelled, cancelled.Status)
+	assert.Empty(t, cancelled.CaptionVersions)
+}
+
+func TestUpdateCaptionConcurrent(t *testing.T) {
+	assert := assert.New(t)
+	service, client := createCaptionsService("")
+	service.AddProvider(providers.NewUploadProvider(&config.CaptionsServiceConfig{Logger: client.Logger}, client.DB))
+	job := &database.Job{
+		ID:             "123",
+		Provider:       "upload",
+		ProviderParams: database.ProviderParams{},
+		CaptionFile:    database.UploadedFile{File: []byte("WEBVTT\n"), Name: "video.vtt"},
+		Outputs:        []database.JobOutput{{Type: "vtt", Filename: "video.vtt"}},
+	}
+	assert.Nil(client.DispatchJob(context.Background(), job))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.UpdateCaption(context.Background(), "123", database.UploadedFile{File: []byte("WEBVTT\n")})
+			assert.Nil(err)
+		}()
+	}
+	wg.Wait()
+
+	updated, _ := client.DB.GetJob(context.Background(), "123")
+	assert.Equal(3, providers.CaptionVersion(updated))
+	if assert.Len(updated.CaptionVersions, 2) {
+		assert.Equal(1, updated.CaptionVersions[0].Version)
+		assert.Equal(2, updated.CaptionVersions[1].Version)
+	}
+}
+
+func TestImportJob(t *testing.T) {
+	assert := assert.New(t)
+	server := server.NewSimpleServer(&server.Config{})
+	service, client := createCaptionsService("")
+	service.AddProvider(fakeProvider{logger: client.Logger, params: map[string]bool{"jobDone": true}})
+	server.Register(service)
+
+	body := `{"provider": "test-provider", "provider_id": "123", "parent_id": "parent", "output_types": ["vtt", "srt"]}`
+	r, _ := http.NewRequest("POST", "/jobs/import", bytes.NewReader([]byte(body)))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(201, w.Code)
+	var job database.Job
+	json.NewDecoder(w.Body).Decode(&job)
+	assert.Equal("123", job.GetProviderID())
+	assert.Equal(database.StatusDelivered, job.Status)
+	assert.True(job.Done)
+	assert.Equal(fmt.Sprintf("somepath/test-provider/123_%s.vtt", job.ID), job.Outputs[0].URL)
+	assert.Equal(fmt.Sprintf("somepath/test-provider/123_%s.srt", job.ID), job.Outputs[1].URL)
+
+	storedJob, err := client.DB.GetJob(context.Background(), job.ID)
+	assert.Nil(err)
+	assert.Equal("parent", storedJob.ParentID)
+	assert.True(storedJob.Done)
+
+	r, _ = http.NewRequest("POST", "/jobs/import", bytes.NewReader([]byte(body)))
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(409, w.Code)
+}
+
+func TestImportJobErrors(t *testing.T) {
+	server := server.NewSimpleServer(&server.Config{})
+	service, client := createCaptionsService("")
+	service.AddProvider(brokenProvider{logger: client.Logger})
+	service.AddProvider(threePlayProvider{fakeProvider{logger: client.Logger, params: map[string]bool{"jobError": true}}})
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		err    string
+	}{
+		{"Malformed", `not json`, 400, "Malformed parameters"},
+		{"Missing provider ID", `{"provider": "3play"}`, 400, "Please provide a provider and provider_id"},
+		{"Unknown provider", `{"provider": "missing-provider", "provider_id": "1"}`, 400, "provider not found"},
+		{"Not importable", `{"provider": "broken-provider", "provider_id": "1"}`, 409, "provider does not support importing jobs"},
+		{"Invalid output", `{"provider": "3play", "provider_id": "1", "output_types": ["doc"]}`, 400, `test-provider: unsupported output type "doc", supported types are: vtt, srt, sbv, ssa`},
+		{"Provider error", `{"provider": "3play", "provider_id": "1"}`, 500, "Error fetching job from 3play: oh no"},
+	}
+	server.Register(service)
+	for _, test := range tests {
+		r, _ := http.NewRequest("POST", "/jobs/import", bytes.NewReader([]byte(test.body)))
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		var body map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&body)
+		assert.Equal(t, test.status, w.Code, test.name)
+		assert.Equal(t, test.err, body["error"], test.name)
+	}
+	_, err := client.DB.GetJobByProviderID(context.Background(), "1")
+	assert.Equal(t, database.ErrNoJobs, err)
+}
+
+func TestResubmitJobErrors(t *testing.T) {
+	server := server.NewSimpleServer(&server.Config{})
+	service, client := createCaptionsService("")
+	service.AddProvider(fakeProvider{logger: client.Logger})
+	service.AddProvider(brokenProvider{logger: client.Logger})
+	client.DB.StoreJob(context.Background(), &database.Job{ID: "failed-job", Provider: "broken-provider", Status: "error", ProviderParams: database.ProviderParams{}})
+	client.DB.StoreJob(context.Background(), &database.Job{ID: "processing-job", Provider: "test-provider", Status: "processing", ProviderParams: database.ProviderParams{}})
+
+	tests := []struct {
+		name   string
+		id     string
+		body   string
+		status int
+		err    string
+	}{
+		{"Malformed", "failed-job", `not json`, 400, "Malformed parameters"},
+		{"Unknown provider", "failed-job", `{"provider": "missing-provider"}`, 400, "provider not found"},
+		{"Invalid params", "failed-job", `{"provider": "test-provider", "provider_params": {"fidelity": "high"}}`, 400, `test-provider: unknown provider param "fidelity"`},
+		{"Not in error", "processing-job", ``, 409, "only jobs in error can be resubmitted"},
+		{"Not found", "404", ``, 404, "job not found"},
+		{"Dispatch error", "failed-job", ``, 500, "Error dispatching Job: provider error"},
+		{"Resubmitted", "failed-job", `{"provider": "test-provider"}`, 200, ""},
+	}
+	server.Register(service)
+	for _, test := range tests {
+		r, _ := http.NewRequest("POST", "/jobs/"+test.id+"/resubmit", bytes.NewReader([]byte(test.body)))
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		var body map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&body)
+		assert.Equal(t, test.status, w.Code, test.name)
+		if test.err != "" {
+			assert.Equal(t, test.err, body["error"], test.name)
+		}
+	}
+	job, _ := client.DB.GetJob(context.Background(), "failed-job")
+	assert.Equal(t, "test-provider", job.Provider)
+	assert.Len(t, job.Submissions, 2)
+}
+
 func TestCreateJobInvalidBody(t *testing.T) {
 	assert := assert.New(t)
 	service, client := createCaptionsService("")
@@ -140,6 +617,74 @@ func TestGetJob404(t *testing.T) {
 	assert.Equal("job not found", jobBody["error"])
 }
 
+func TestGetProviders(t *testing.T) {
+	assert := assert.New(t)
+	server := server.NewSimpleServer(&server.Config{})
+	service, client := createCaptionsService("")
+	service.AddProvider(fakeProvider{logger: client.Logger})
+	service.AddProvider(brokenProvider{logger: client.Logger})
+	server.Register(service)
+	r, _ := http.NewRequest("GET", "/providers", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(200, w.Code)
+	var providersBody []map[string]interface{}
+	err := json.NewDecoder(w.Body).Decode(&providersBody)
+	if err != nil {
+		t.Errorf("%s: unable to JSON decode response body: %s", w.Body, err)
+	}
+	assert.Len(providersBody, 2)
+	assert.Equal("broken-provider", providersBody[0]["name"])
+	assert.Equal("test-provider", providersBody[1]["name"])
+	assert.Equal(true, providersBody[1]["cancellable"])
+}
+
+func TestGetProviderStatus(t *testing.T) {
+	assert := assert.New(t)
+	server := server.NewSimpleServer(&server.Config{})
+	service, client := createCaptionsService("")
+	service.AddProvider(fakeProvider{logger: client.Logger})
+	service.AddProvider(brokenProvider{logger: client.Logger})
+	server.Register(service)
+	r, _ := http.NewRequest("GET", "/status/providers", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(200, w.Code)
+	var statuses []ProviderStatus
+	err := json.NewDecoder(w.Body).Decode(&statuses)
+	if err != nil {
+		t.Errorf("%s: unable to JSON decode response body: %s", w.Body, err)
+	}
+	assert.Equal([]ProviderStatus{
+		{Provider: "broken-provider", State: "closed"},
+		{Provider: "test-provider", State: "closed"},
+	}, statuses)
+}
+
+func TestGetProvider(t *testing.T) {
+	assert := assert.New(t)
+	server := server.NewSimpleServer(&server.Config{})
+	service, client := createCaptionsService("")
+	service.AddProvider(fakeProvider{logger: client.Logger})
+	server.Register(service)
+
+	r, _ := http.NewRequest("GET", "/providers/test-provider", nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(200, w.Code)
+	var providerBody map[string]interface{}
+	err := json.NewDecoder(w.Body).Decode(&providerBody)
+	if err != nil {
+		t.Errorf("%s: unable to JSON decode response body: %s", w.Body, err)
+	}
+	assert.Equal([]interface{}{"vtt", "srt", "sbv", "ssa"}, providerBody["output_types"])
+
+	r, _ = http.NewRequest("GET", "/providers/nope", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(404, w.Code)
+}
+
 func TestCancelJob(t *testing.T) {
 	assert := assert.New(t)
 	server := server.NewSimpleServer(&server.Config{})
@@ -210,7 +755,7 @@ func TestCancelJobDone(t *testing.T) {
 		Provider: "test-provider",
 		Done:     true,
 	}
-	client.DB.StoreJob(job)
+	client.DB.StoreJob(context.Background(), job)
 	server.Register(service)
 	r, _ := http.NewRequest("POST", "/jobs/123/cancel", nil)
 	w := httptest.NewRecorder()
@@ -234,7 +779,7 @@ func TestDownload(t *testing.T) {
 		MediaURL: "http://vp.nyt.com/video.mp4",
 		Provider: "test-provider",
 	}
-	client.DB.StoreJob(job)
+	client.DB.StoreJob(context.Background(), job)
 	server.Register(service)
 	r, _ := http.NewRequest("GET", "/jobs/123/download/vtt", bytes.NewReader(nil))
 	w := httptest.NewRecorder()
@@ -254,7 +799,7 @@ func TestDownloadMissingCaption(t *testing.T) {
 		MediaURL: "http://vp.nyt.com/video.mp4",
 		Provider: "test-provider",
 	}
-	client.DB.StoreJob(job)
+	client.DB.StoreJob(context.Background(), job)
 	server.Register(service)
 	r, _ := http.NewRequest("GET", "/jobs/456/download/srt", bytes.NewReader(nil))
 	w := httptest.NewRecorder()
@@ -274,7 +819,7 @@ func TestDownloadBadRequest(t *testing.T) {
 		MediaURL: "http://vp.nyt.com/video.mp4",
 		Provider: "test-provider",
 	}
-	client.DB.StoreJob(job)
+	client.DB.StoreJob(context.Background(), job)
 	server.Register(service)
 	r, _ := http.NewRequest("GET", "/jobs/123/download", bytes.NewReader(nil))
 	w := httptest.NewRecorder()
@@ -294,7 +839,7 @@ func TestTranscript(t *testing.T) {
 		MediaURL: "http://vp.nyt.com/video.mp4",
 		Provider: "test-provider",
 	}
-	client.DB.StoreJob(job)
+	client.DB.StoreJob(context.Background(), job)
 	server.Register(service)
 	r, _ := http.NewRequest("GET", "/jobs/123/transcript/vtt", bytes.NewReader(nil))
 	w := httptest.NewRecorder()
@@ -314,7 +859,7 @@ func TestTranscriptMissingCaption(t *testing.T) {
 		MediaURL: "http://vp.nyt.com/video.mp4",
 		Provider: "test-provider",
 	}
-	client.DB.StoreJob(job)
+	client.DB.StoreJob(context.Background(), job)
 	server.Register(service)
 	r, _ := http.NewRequest("GET", "/jobs/456/transcript/vtt", bytes.NewReader(nil))
 	w := httptest.NewRecorder()
@@ -334,7 +879,7 @@ func TestTranscriptBadRequest(t *testing.T) {
 		MediaURL: "http://vp.nyt.com/video.mp4",
 		Provider: "test-provider",
 	}
-	client.DB.StoreJob(job)
+	client.DB.StoreJob(context.Background(), job)
 	server.Register(service)
 	r, _ := http.NewRequest("GET", "/jobs/123/transcript/wrong", bytes.NewReader(nil))
 	w := httptest.NewRecorder()
@@ -344,6 +889,8 @@ func TestTranscriptBadRequest(t *testing.T) {
 
 type processCallbackTest struct {
 	name            string
+	path            string
+	provider        string
 	callbackID      int
 	responseCode    int
 	startFakeServer bool
@@ -353,16 +900,52 @@ func TestProcessCallback(t *testing.T) {
 	tests := []processCallbackTest{
 		{
 			name:            "Success",
+			path:            "/callback",
+			provider:        "3play",
 			callbackID:      11214314,
 			responseCode:    200,
 			startFakeServer: true,
 		},
 		{
 			name:            "Provider ID not found",
+			path:            "/callback",
+			provider:        "3play",
 			callbackID:      67849,
 			responseCode:    500,
 			startFakeServer: false,
 		},
+		{
+			name:            "Provider route",
+			path:            "/callback/test-provider",
+			provider:        "test-provider",
+			callbackID:      11214314,
+			responseCode:    200,
+			startFakeServer: true,
+		},
+		{
+			name:            "Invalid provider ID",
+			path:            "/callback/test-provider",
+			provider:        "test-provider",
+			callbackID:      0,
+			responseCode:    400,
+			startFakeServer: false,
+		},
+		{
+			name:            "Provider without callbacks",
+			path:            "/callback/broken-provider",
+			provider:        "broken-provider",
+			callbackID:      11214314,
+			responseCode:    404,
+			startFakeServer: false,
+		},
+		{
+			name:            "Unknown provider",
+			path:            "/callback/nope",
+			provider:        "nope",
+			callbackID:      11214314,
+			responseCode:    404,
+			startFakeServer: false,
+		},
 	}
 	for _, test := range tests {
 		test := test
@@ -381,18 +964,20 @@ func TestProcessCallback(t *testing.T) {
 			server := server.NewSimpleServer(&server.Config{})
 			assert := assert.New(t)
 			service.AddProvider(fakeProvider{logger: client.Logger})
+			service.AddProvider(threePlayProvider{fakeProvider{logger: client.Logger}})
+			service.AddProvider(brokenProvider{logger: client.Logger})
 			job := &database.Job{
 				ID:             "123",
 				MediaURL:       "http://vp.nyt.com/video.mp4",
-				Provider:       "test-provider",
+				Provider:       test.provider,
 				ProviderParams: map[string]string{"ProviderID": "11214314"},
 			}
-			client.DB.StoreJob(job)
+			client.DB.StoreJob(context.Background(), job)
 			server.Register(service)
 
-			captionCallback := Callback{
+			captionCallback := providers.ThreePlayCallback{
 				Code: 200,
-				Data: CallbackData{
+				Data: providers.ThreePlayCallbackData{
 					ID:          test.callbackID,
 					MediaFileID: 3765758,
 					BatchID:     68841,
@@ -408,7 +993,7 @@ func TestProcessCallback(t *testing.T) {
 			}
 
 			callbackBody, _ := json.Marshal(captionCallback)
-			r, _ := http.NewRequest("POST", "/callback", bytes.NewReader(callbackBody))
+			r, _ := http.NewRequest("POST", test.path, bytes.NewReader(callbackBody))
 			r.Header.Set("Content-Type", "application/json")
 			w := httptest.NewRecorder()
 			server.ServeHTTP(w, r)
@@ -416,3 +1001,26 @@ func TestProcessCallback(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateJobDispatchTimeout(t *testing.T) {
+	assert := assert.New(t)
+	server := server.NewSimpleServer(&server.Config{})
+	service, client := createCaptionsService("")
+	service.client.ProviderTimeouts = map[string]time.Duration{"slow-provider": 10 * time.Millisecond}
+	service.AddProvider(slowProvider{fakeProvider{logger: client.Logger}})
+	service.AddProvider(fakeProvider{logger: client.Logger})
+	server.Register(service)
+
+	body := `{"media_url": "http://vp.nyt.com/video.mp4", "providers": ["slow-provider", "test-provider"]}`
+	r, _ := http.NewRequest("POST", "/captions", bytes.NewReader([]byte(body)))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(202, w.Code)
+	var job database.Job
+	json.NewDecoder(w.Body).Decode(&job)
+	assert.Equal(database.StatusError, job.Status)
+	assert.Len(job.DispatchAttempts, 1)
+	assert.True(job.DispatchAttempts[0].Indeterminate)
+	_, err := client.DB.GetJob(context.Background(), job.ID)
+	assert.Nil(err)
+}
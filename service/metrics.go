@@ -0,0 +1,36 @@
+package service
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var callbackAuthFailures = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "captions",
+		Name:      "callback_auth_failures_total",
+		Help:      "Number of inbound callbacks rejected because of an invalid token or signature.",
+	},
+	[]string{"provider"},
+)
+
+var providerCallsRejected = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "captions",
+		Name:      "provider_calls_rejected_total",
+		Help:      "Number of provider calls rejected by a circuit breaker or rate limiter.",
+	},
+	[]string{"provider", "reason"},
+)
+
+var jobsOverdue = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "captions",
+		Name:      "jobs_overdue_total",
+		Help:      "Number of jobs found past their deadline.",
+	},
+	[]string{"provider"},
+)
+
+func init() {
+	prometheus.MustRegister(callbackAuthFailures)
+	prometheus.MustRegister(providerCallsRejected)
+	prometheus.MustRegister(jobsOverdue)
+}
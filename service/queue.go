@@ -0,0 +1,296 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nytimes/video-captions-api/database"
+	log "github.com/sirupsen/logrus"
+)
+
+// DispatchQueue dispatches queued jobs in the background, highest priority
+// first, with at most Concurrency[provider] dispatches in flight per provider.
+// The queue is persisted as the jobs with the queued status, so jobs queued
+// before a restart are dispatched once it is running again. Workers claim a
+// job in the DB by moving it to dispatching, so it is dispatched once however
+// many replicas run a queue.
+type DispatchQueue struct {
+	client Client
+	// Workers is the number of jobs dispatched at the same time
+	Workers int
+	// Concurrency caps the dispatches in flight per provider, providers
+	// without a cap are only bound by Workers. The cap applies to the first
+	// provider a job is dispatched to, its fallbacks included.
+	Concurrency map[string]int
+	// PollInterval is how often idle workers look for jobs they couldn't
+	// dispatch earlier
+	PollInterval time.Duration
+	// Lease bounds the dispatch of a job, jobs still dispatching after it are
+	// put in error by the maintenance loop as their worker was interrupted
+	Lease time.Duration
+
+	mtx      sync.Mutex
+	inflight map[string]int
+	// claimed keeps the workers of this queue from racing for the same job
+	claimed map[string]bool
+	wake    chan struct{}
+}
+
+// NewDispatchQueue creates a DispatchQueue dispatching the jobs of client
+func NewDispatchQueue(client Client, workers int, concurrency map[string]int) *DispatchQueue {
+	return &DispatchQueue{
+		client:       client,
+		Workers:      workers,
+		Concurrency:  concurrency,
+		PollInterval: 5 * time.Second,
+		Lease:        10 * time.Minute,
+		inflight:     make(map[string]int),
+		claimed:      make(map[string]bool),
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Run dispatches queued jobs with Workers workers until ctx is done
+func (q *DispatchQueue) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < q.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *DispatchQueue) work(ctx context.Context) {
+	ticker := time.NewTicker(q.PollInterval)
+	defer ticker.Stop()
+	for ctx.Err() == nil {
+		if q.RunOnce(ctx) {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce dispatches the next queued job whose provider isn't at its cap and
+// tells whether there was one
+func (q *DispatchQueue) RunOnce(ctx context.Context) bool {
+	job, provider := q.next(ctx)
+	if job == nil {
+		return false
+	}
+	// other workers may pick up the following jobs
+	q.signal()
+	q.client.dispatchQueued(ctx, job.ID, q.Lease)
+	q.release(job.ID, provider)
+	return true
+}
+
+// signal wakes up an idle worker
+func (q *DispatchQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next claims the first queued job whose provider has room for another dispatch
+func (q *DispatchQueue) next(ctx context.Context) (*database.Job, string) {
+	jobs, err := q.client.DB.GetQueuedJobs(ctx)
+	if err != nil {
+		q.client.Logger.WithError(err).Error("Could not load queued jobs")
+		return nil, ""
+	}
+	sort.Sort(database.ByQueueOrder(jobs))
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	for i := range jobs {
+		job := &jobs[i]
+		if q.claimed[job.ID] {
+			continue
+		}
+		provider := q.provider(job)
+		if limit := q.Concurrency[provider]; limit > 0 && q.inflight[provider] >= limit {
+			continue
+		}
+		q.claimed[job.ID] = true
+		q.inflight[provider]++
+		return job, provider
+	}
+	return nil, ""
+}
+
+func (q *DispatchQueue) release(jobID, provider string) {
+	q.mtx.Lock()
+	delete(q.claimed, jobID)
+	q.inflight[provider]--
+	q.mtx.Unlock()
+	q.signal()
+}
+
+// provider is the provider a job is dispatched to first
+func (q *DispatchQueue) provider(job *database.Job) string {
+	if candidates := q.client.dispatchCandidates(job); len(candidates) > 0 {
+		return candidates[0]
+	}
+	return job.Provider
+}
+
+// EnqueueJob stores a job with the queued status, it is dispatched by the
+// dispatch queue workers
+func (c Client) EnqueueJob(ctx context.Context, job *database.Job) error {
+	jobLogger := c.Logger.WithFields(log.Fields{"JobID": job.ID, "Priority": job.Priority})
+	job.Status = database.StatusQueued
+	job.QueuedAt = time.Now()
+	jobLogger.Info("Queuing job")
+	// workers read the stored job while the caller still holds this one
+	stored := *job
+	if _, err := c.DB.StoreJob(ctx, &stored); err != nil {
+		jobLogger.Errorf("Error storing job in DB: %v", err)
+		return fmt.Errorf("Error storing Job: %v", err)
+	}
+	c.recordEvent(ctx, job, database.JobEvent{Type: database.JobEventCreated, CreatedAt: job.CreatedAt})
+	c.notify(ctx, job, EventCreated)
+	job.QueuePosition = c.queuePosition(ctx, job)
+	if c.Queue != nil {
+		c.Queue.signal()
+	}
+	return nil
+}
+
+// queuePosition returns the rank of a queued job in the dispatch queue, from 1
+func (c Client) queuePosition(ctx context.Context, job *database.Job) int {
+	jobs, err := c.DB.GetQueuedJobs(ctx)
+	if err != nil {
+		c.Logger.WithError(err).WithField("JobID", job.ID).Error("Could not load queued jobs")
+		return 0
+	}
+	sort.Sort(database.ByQueueOrder(jobs))
+	for i := range jobs {
+		if jobs[i].ID == job.ID {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// errJobNotQueued is returned when a job was cancelled or claimed by another
+// worker before it could be claimed
+var errJobNotQueued = errors.New("job is no longer queued")
+
+// dispatchQueued claims a queued job and dispatches it like DispatchJob,
+// unless it was cancelled or claimed by another replica in the meantime.
+// Failures put the job in error.
+func (c Client) dispatchQueued(ctx context.Context, jobID string, lease time.Duration) {
+	jobLogger := c.Logger.WithFields(log.Fields{"JobID": jobID})
+	claimed, err := c.DB.ModifyJob(ctx, jobID, func(stored *database.Job) error {
+		if stored.Status != database.StatusQueued {
+			return errJobNotQueued
+		}
+		stored.UpdateStatus(database.StatusDispatching, "")
+		stored.LeaseExpiresAt = time.Now().Add(lease)
+		return nil
+	})
+	if err == errJobNotQueued {
+		return
+	}
+	if err != nil {
+		jobLogger.WithError(err).Error("Could not claim queued job")
+		return
+	}
+	// the stored job is only updated once the dispatch is over
+	dispatching := *claimed
+	job := &dispatching
+	jobLogger.WithField("QueuedFor", time.Since(job.QueuedAt)).Info("Dispatching queued job")
+	dispatchErr := c.dispatch(ctx, job)
+	if dispatchErr != nil {
+		jobLogger.WithError(dispatchErr).Error("could not dispatch job")
+	}
+
+	// the job may have been cancelled during the dispatch, its status is kept
+	// but the provider job is recorded so it can be cancelled
+	cancelled := false
+	updated, err := c.DB.ModifyJob(ctx, jobID, func(stored *database.Job) error {
+		cancelled = stored.Status == database.StatusCancelled
+		stored.Provider = job.Provider
+		stored.ProviderParams = job.ProviderParams
+		stored.DispatchAttempts = job.DispatchAttempts
+		stored.LeaseExpiresAt = time.Time{}
+		if cancelled {
+			return nil
+		}
+		if dispatchErr != nil {
+			stored.UpdateStatus(database.StatusError, dispatchErr.Error())
+		} else {
+			stored.UpdateStatus(database.StatusProcessing, "")
+			c.setDeadline(stored, stored.QueuedAt)
+		}
+		return nil
+	})
+	if err != nil {
+		jobLogger.Errorf("Error updating job in DB: %v", err)
+		return
+	}
+	c.recordDispatchAttempts(ctx, updated)
+	if cancelled {
+		if dispatchErr == nil {
+			jobLogger.Warn("Job was cancelled while it was dispatched, cancelling it with its provider")
+			if _, err := c.cancelWithProvider(ctx, updated); err != nil {
+				jobLogger.WithError(err).Error("Could not cancel dispatched job")
+			}
+		}
+		return
+	}
+	c.notifyStatus(ctx, updated)
+}
+
+// errLeaseHeld is returned when a dispatching job is still within its lease
+var errLeaseHeld = errors.New("job dispatch lease is held")
+
+// expireDispatchLeases puts the jobs still dispatching past their lease in
+// error and returns how many were. Their worker was interrupted, the job may
+// have been ordered from its provider so it isn't dispatched again.
+func (c Client) expireDispatchLeases(ctx context.Context, now time.Time) int {
+	jobs, err := c.DB.GetPendingJobs(ctx)
+	if err != nil {
+		c.Logger.WithError(err).Error("Could not load pending jobs")
+		return 0
+	}
+	expired := 0
+	for i := range jobs {
+		if jobs[i].Status != database.StatusDispatching || now.Before(jobs[i].LeaseExpiresAt) {
+			continue
+		}
+		job, err := c.DB.ModifyJob(ctx, jobs[i].ID, func(stored *database.Job) error {
+			if stored.Status != database.StatusDispatching || now.Before(stored.LeaseExpiresAt) {
+				return errLeaseHeld
+			}
+			stored.UpdateStatus(database.StatusError, "dispatch was interrupted, the job may have been ordered from its provider")
+			stored.LeaseExpiresAt = time.Time{}
+			return nil
+		})
+		if err == errLeaseHeld {
+			continue
+		}
+		if err != nil {
+			c.Logger.WithError(err).WithField("JobID", jobs[i].ID).Error("Could not update interrupted job")
+			continue
+		}
+		c.Logger.WithField("JobID", job.ID).Warn("Job dispatch was interrupted")
+		c.recordEvent(ctx, job, database.JobEvent{Type: database.JobEventError, Details: job.Details})
+		c.notifyStatus(ctx, job)
+		expired++
+	}
+	return expired
+}
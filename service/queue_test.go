@@ -0,0 +1,284 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/NYTimes/gizmo/server"
+	"github.com/nytimes/video-captions-api/database"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func createQueuedJob(t *testing.T, server *server.SimpleServer, body string) database.Job {
+	r, _ := http.NewRequest("POST", "/captions", bytes.NewReader([]byte(body)))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(t, 201, w.Code)
+	var job database.Job
+	json.NewDecoder(w.Body).Decode(&job)
+	return job
+}
+
+func TestDispatchQueue(t *testing.T) {
+	assert := assert.New(t)
+	server := server.NewSimpleServer(&server.Config{})
+	service, client := createCaptionsService("")
+	service.AddProvider(fakeProvider{logger: log.New()})
+	queue := NewDispatchQueue(client, 1, nil)
+	service.client.Queue = queue
+	server.Register(service)
+
+	low := createQueuedJob(t, server, `{"media_url": "http://vp.nyt.com/low.mp4", "provider": "test-provider"}`)
+	assert.Equal(database.StatusQueued, low.Status)
+	assert.Equal(1, low.QueuePosition)
+	assert.Empty(low.DispatchAttempts)
+	high := createQueuedJob(t, server, `{"media_url": "http://vp.nyt.com/high.mp4", "provider": "test-provider", "priority": 5}`)
+	assert.Equal(1, high.QueuePosition)
+	cancelled := createQueuedJob(t, server, `{"media_url": "http://vp.nyt.com/cancelled.mp4", "provider": "test-provider"}`)
+	assert.Equal(3, cancelled.QueuePosition)
+
+	r, _ := http.NewRequest("GET", "/jobs/"+low.ID, nil)
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	var job database.Job
+	json.NewDecoder(w.Body).Decode(&job)
+	assert.Equal(2, job.QueuePosition)
+
+	canceled, err := client.CancelJob(context.Background(), cancelled.ID)
+	assert.True(canceled)
+	assert.Nil(err)
+
+	assert.True(queue.RunOnce(context.Background()))
+	dispatched, _ := client.DB.GetJob(context.Background(), high.ID)
+	assert.Equal(database.StatusProcessing, dispatched.Status)
+	assert.Zero(dispatched.QueuePosition)
+	assert.Len(dispatched.DispatchAttempts, 1)
+	queued, _ := client.GetJob(context.Background(), low.ID)
+	assert.Equal(database.StatusQueued, queued.Status)
+	assert.Equal(1, queued.QueuePosition)
+
+	assert.True(queue.RunOnce(context.Background()))
+	assert.False(queue.RunOnce(context.Background()))
+	dispatched, _ = client.DB.GetJob(context.Background(), low.ID)
+	assert.Equal(database.StatusProcessing, dispatched.Status)
+	events, _ := client.GetJobEvents(context.Background(), low.ID)
+	assert.Equal([]database.JobEventType{database.JobEventCreated, database.JobEventDispatched}, eventTypes(events))
+	notDispatched, _ := client.DB.GetJob(context.Background(), cancelled.ID)
+	assert.Equal(database.StatusCancelled, notDispatched.Status)
+	assert.Empty(notDispatched.DispatchAttempts)
+}
+
+func TestDispatchQueueError(t *testing.T) {
+	assert := assert.New(t)
+	service, client := createCaptionsService("")
+	service.AddProvider(brokenProvider{logger: log.New()})
+	queue := NewDispatchQueue(client, 1, nil)
+	job := &database.Job{ID: "123", Provider: "broken-provider", ProviderParams: database.ProviderParams{}}
+	assert.Nil(client.EnqueueJob(context.Background(), job))
+
+	assert.True(queue.RunOnce(context.Background()))
+	failed, _ := client.DB.GetJob(context.Background(), "123")
+	assert.Equal(database.StatusError, failed.Status)
+	assert.True(failed.Done)
+	assert.Equal("Error dispatching Job: provider error", failed.Details)
+}
+
+func TestDispatchQueueConcurrency(t *testing.T) {
+	assert := assert.New(t)
+	service, client := createCaptionsService("")
+	service.AddProvider(fakeProvider{logger: log.New()})
+	service.AddProvider(brokenProvider{logger: log.New()})
+	queue := NewDispatchQueue(client, 3, map[string]int{"test-provider": 1})
+	now := time.Now()
+	for i, id := range []string{"first", "second", "other"} {
+		provider := "test-provider"
+		if id == "other" {
+			provider = "broken-provider"
+		}
+		client.DB.StoreJob(context.Background(), &database.Job{
+			ID:       id,
+			Provider: provider,
+			Status:   database.StatusQueued,
+			QueuedAt: now.Add(time.Duration(i) * time.Second),
+		})
+	}
+
+	first, provider := queue.next(context.Background())
+	assert.Equal("first", first.ID)
+	// test-provider is at its cap, "second" waits for "first"
+	other, otherProvider := queue.next(context.Background())
+	assert.Equal("other", other.ID)
+	job, _ := queue.next(context.Background())
+	assert.Nil(job)
+
+	first.Status = database.StatusProcessing
+	client.DB.UpdateJob(context.Background(), first.ID, first)
+	queue.release(first.ID, provider)
+	queue.release(other.ID, otherProvider)
+	second, _ := queue.next(context.Background())
+	assert.Equal("second", second.ID)
+}
+
+func TestDispatchQueueRun(t *testing.T) {
+	assert := assert.New(t)
+	service, client := createCaptionsService("")
+	service.AddProvider(fakeProvider{logger: log.New()})
+	queue := NewDispatchQueue(client, 2, nil)
+	client.Queue = queue
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		queue.Run(ctx)
+		close(done)
+	}()
+
+	for _, id := range []string{"1", "2", "3"} {
+		client.EnqueueJob(context.Background(), &database.Job{ID: id, Provider: "test-provider", ProviderParams: database.ProviderParams{}})
+	}
+	assert.Eventually(func() bool {
+		queued, _ := client.DB.GetQueuedJobs(context.Background())
+		return len(queued) == 0
+	}, time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+}
+
+// blockingProvider holds every dispatch until release is closed
+type blockingProvider struct {
+	fakeProvider
+	started    chan struct{}
+	release    chan struct{}
+	dispatches *int32
+	cancels    *int32
+}
+
+func newBlockingProvider() blockingProvider {
+	return blockingProvider{
+		fakeProvider: fakeProvider{logger: log.New()},
+		started:      make(chan struct{}, 1),
+		release:      make(chan struct{}),
+		dispatches:   new(int32),
+		cancels:      new(int32),
+	}
+}
+
+func (p blockingProvider) DispatchJob(_ context.Context, job *database.Job) error {
+	atomic.AddInt32(p.dispatches, 1)
+	job.ProviderParams["ProviderID"] = "abc"
+	p.started <- struct{}{}
+	<-p.release
+	return nil
+}
+
+func (p blockingProvider) CancelJob(_ context.Context, _ *database.Job) (bool, error) {
+	atomic.AddInt32(p.cancels, 1)
+	return true, nil
+}
+
+func TestDispatchQueueClaim(t *testing.T) {
+	assert := assert.New(t)
+	service, client := createCaptionsService("")
+	provider := newBlockingProvider()
+	service.AddProvider(provider)
+	assert.Nil(client.EnqueueJob(context.Background(), &database.Job{ID: "123", Provider: "test-provider", ProviderParams: database.ProviderParams{}}))
+
+	done := make(chan struct{})
+	go func() {
+		client.dispatchQueued(context.Background(), "123", time.Minute)
+		close(done)
+	}()
+	<-provider.started
+	job, _ := client.GetJob(context.Background(), "123")
+	assert.Equal(database.StatusDispatching, job.Status)
+
+	// another replica reading the queue doesn't dispatch the claimed job
+	replica := NewDispatchQueue(client, 1, nil)
+	assert.False(replica.RunOnce(context.Background()))
+	client.dispatchQueued(context.Background(), "123", time.Minute)
+	close(provider.release)
+	<-done
+
+	assert.Equal(int32(1), atomic.LoadInt32(provider.dispatches))
+	job, _ = client.DB.GetJob(context.Background(), "123")
+	assert.Equal(database.StatusProcessing, job.Status)
+	assert.True(job.LeaseExpiresAt.IsZero())
+}
+
+func TestDispatchQueueCancelDuringDispatch(t *testing.T) {
+	assert := assert.New(t)
+	service, client := createCaptionsService("")
+	provider := newBlockingProvider()
+	service.AddProvider(provider)
+	assert.Nil(client.EnqueueJob(context.Background(), &database.Job{ID: "123", Provider: "test-provider", ProviderParams: database.ProviderParams{}}))
+
+	done := make(chan struct{})
+	go func() {
+		client.dispatchQueued(context.Background(), "123", time.Minute)
+		close(done)
+	}()
+	<-provider.started
+	canceled, err := client.CancelJob(context.Background(), "123")
+	assert.True(canceled)
+	assert.Nil(err)
+	assert.Equal(int32(0), atomic.LoadInt32(provider.cancels))
+	close(provider.release)
+	<-done
+
+	job, _ := client.DB.GetJob(context.Background(), "123")
+	assert.Equal(database.StatusCancelled, job.Status)
+	assert.True(job.Done)
+	assert.Equal("abc", job.GetProviderID())
+	assert.Equal(int32(1), atomic.LoadInt32(provider.cancels))
+}
+
+func TestExpireDispatchLeases(t *testing.T) {
+	assert := assert.New(t)
+	_, client := createCaptionsService("")
+	now := time.Now()
+	client.DB.StoreJob(context.Background(), &database.Job{ID: "expired", Status: database.StatusDispatching, LeaseExpiresAt: now.Add(-time.Second)})
+	client.DB.StoreJob(context.Background(), &database.Job{ID: "leased", Status: database.StatusDispatching, LeaseExpiresAt: now.Add(time.Minute)})
+
+	assert.Equal(1, client.expireDispatchLeases(context.Background(), now))
+	job, _ := client.DB.GetJob(context.Background(), "expired")
+	assert.Equal(database.StatusError, job.Status)
+	assert.True(job.Done)
+	job, _ = client.DB.GetJob(context.Background(), "leased")
+	assert.Equal(database.StatusDispatching, job.Status)
+	assert.Equal(0, client.expireDispatchLeases(context.Background(), now))
+}
+
+func TestResubmitJobQueued(t *testing.T) {
+	assert := assert.New(t)
+	service, _ := createCaptionsService("")
+	service.AddProvider(fakeProvider{logger: log.New()})
+	queue := NewDispatchQueue(service.client, 1, nil)
+	service.client.Queue = queue
+	client := service.client
+	client.DB.StoreJob(context.Background(), &database.Job{
+		ID:             "123",
+		Provider:       "test-provider",
+		Status:         database.StatusError,
+		Done:           true,
+		ProviderParams: database.ProviderParams{"ProviderID": "old"},
+	})
+
+	job, err := client.ResubmitJob(context.Background(), "123", Resubmission{})
+	assert.Nil(err)
+	assert.Equal(database.StatusQueued, job.Status)
+	assert.Equal(1, job.QueuePosition)
+	assert.Empty(job.DispatchAttempts)
+
+	assert.True(queue.RunOnce(context.Background()))
+	dispatched, _ := client.DB.GetJob(context.Background(), "123")
+	assert.Equal(database.StatusProcessing, dispatched.Status)
+	assert.False(dispatched.Done)
+	assert.Len(dispatched.DispatchAttempts, 1)
+	assert.Len(dispatched.Submissions, 1)
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nytimes/video-captions-api/database"
+	log "github.com/sirupsen/logrus"
+)
+
+// Reconciler periodically refreshes the jobs that aren't done from their
+// providers, storing their outputs and notifying CallbackURL when they change,
+// so jobs progress without anyone calling GET /jobs/{id}
+type Reconciler struct {
+	client Client
+	// Interval is the time between two scans of the pending jobs
+	Interval time.Duration
+	// MinBackoff and MaxBackoff bound the time between two refreshes of a job,
+	// a tenth of the job age in between
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	now func() time.Time
+}
+
+// NewReconciler creates a Reconciler refreshing the jobs of client
+func NewReconciler(client Client, interval, minBackoff, maxBackoff time.Duration) *Reconciler {
+	return &Reconciler{
+		client:     client,
+		Interval:   interval,
+		MinBackoff: minBackoff,
+		MaxBackoff: maxBackoff,
+		now:        time.Now,
+	}
+}
+
+// Run scans the pending jobs every Interval until ctx is done
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		r.RunOnce(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce refreshes every pending job due for a check and returns how many were
+// refreshed
+func (r *Reconciler) RunOnce(ctx context.Context) int {
+	jobs, err := r.client.DB.GetPendingJobs(ctx)
+	if err != nil {
+		r.client.Logger.WithError(err).Error("Reconciler could not load pending jobs")
+		return 0
+	}
+	refreshed := 0
+	for i := range jobs {
+		if ctx.Err() != nil {
+			break
+		}
+		// the scan only picks the jobs to refresh, they are read again as
+		// refreshing the earlier ones takes time
+		if r.pending(&jobs[i]) && r.reconcile(ctx, jobs[i].ID) {
+			refreshed++
+		}
+	}
+	return refreshed
+}
+
+// pending tells whether a job is dispatched, not done and due for a check
+func (r *Reconciler) pending(job *database.Job) bool {
+	return !job.Done && job.Status != database.StatusQueued && job.Status != database.StatusDispatching && r.due(job)
+}
+
+// due tells whether a job was last checked longer than its backoff ago
+func (r *Reconciler) due(job *database.Job) bool {
+	if job.LastCheckedAt.IsZero() {
+		return true
+	}
+	return !r.now().Before(job.LastCheckedAt.Add(r.backoff(job)))
+}
+
+// backoff is the time between two refreshes of a job, jobs that have been
+// pending for long are checked less often
+func (r *Reconciler) backoff(job *database.Job) time.Duration {
+	backoff := r.now().Sub(job.CreatedAt) / 10
+	if backoff < r.MinBackoff {
+		backoff = r.MinBackoff
+	}
+	if r.MaxBackoff > 0 && backoff > r.MaxBackoff {
+		backoff = r.MaxBackoff
+	}
+	return backoff
+}
+
+// reconcile refreshes a job from its provider like GetJob, recording when it
+// was checked and notifying CallbackURL when its status changed. It tells
+// whether the job was refreshed.
+func (r *Reconciler) reconcile(ctx context.Context, id string) bool {
+	c := r.client
+	job, err := c.DB.GetJob(ctx, id)
+	if err != nil || !r.pending(job) {
+		return false
+	}
+	jobLogger := c.Logger.WithFields(log.Fields{"JobID": job.ID, "Provider": job.Provider, "ProviderID": job.GetProviderID()})
+	providerJob, err := c.fetchProviderJob(ctx, job)
+	if err == ErrProviderUnavailable || err == ErrProviderRateLimited {
+		jobLogger.WithError(err).Debug("Reconciler skipped job")
+		return false
+	}
+
+	checkedAt := r.now()
+	status, done := job.Status, job.Done
+	if err != nil {
+		jobLogger.WithError(err).Warn("Reconciler could not get job from provider")
+	} else if job, err = r.reread(ctx, job); err != nil {
+		jobLogger.WithError(err).Info("Reconciler skipped job changed while its provider was called")
+		return true
+	} else if err := c.syncJob(ctx, job, providerJob, jobLogger); err != nil {
+		jobLogger.WithError(err).Error("Reconciler could not update job")
+		return true
+	}
+	// only LastCheckedAt is written, changes made to the job meanwhile are kept
+	if _, err := c.DB.ModifyJob(ctx, id, func(stored *database.Job) error {
+		stored.LastCheckedAt = checkedAt
+		return nil
+	}); err != nil {
+		jobLogger.WithError(err).Error("Reconciler could not update job")
+		return true
+	}
+
+	if c.CallbackURL != "" && (job.Status != status || job.Done != done) {
+		jobLogger.Infof("Making API call to: %v", c.CallbackURL)
+		if err := c.makeAPICall(ctx, job); err != nil {
+			jobLogger.Errorf("Encountered an error while making a callback call: %v", err)
+		}
+	}
+	return true
+}
+
+// errJobChanged is returned by reread when a job was finished or resubmitted
+// while its provider was called
+var errJobChanged = errors.New("job changed while its provider was called")
+
+// reread reads a job again after its provider was called, the provider state
+// no longer applies to it once it's done or dispatched to another provider job
+func (r *Reconciler) reread(ctx context.Context, polled *database.Job) (*database.Job, error) {
+	job, err := r.client.DB.GetJob(ctx, polled.ID)
+	if err != nil {
+		return nil, err
+	}
+	if job.Done || job.Provider != polled.Provider || job.GetProviderID() != polled.GetProviderID() {
+		return nil, errJobChanged
+	}
+	return job, nil
+}
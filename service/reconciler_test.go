@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nytimes/video-captions-api/database"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReconcilerRunOnce(t *testing.T) {
+	assert := assert.New(t)
+	var mtx sync.Mutex
+	var notified []database.Job
+	callbackServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var job database.Job
+		json.NewDecoder(r.Body).Decode(&job)
+		mtx.Lock()
+		notified = append(notified, job)
+		mtx.Unlock()
+	}))
+	defer callbackServer.Close()
+
+	service, client := createCaptionsService(callbackServer.URL)
+	service.AddProvider(fakeProvider{logger: log.New(), params: map[string]bool{"jobDone": true}})
+	now := time.Now()
+	pending := &database.Job{
+		ID:             "pending",
+		Provider:       "test-provider",
+		Status:         database.StatusProcessing,
+		ProviderParams: database.ProviderParams{"ProviderID": "123"},
+		Outputs:        []database.JobOutput{{Type: "vtt", Filename: "video.vtt"}},
+		CreatedAt:      now.Add(-time.Hour),
+	}
+	recent := &database.Job{
+		ID:             "recent",
+		Provider:       "test-provider",
+		Status:         database.StatusProcessing,
+		ProviderParams: database.ProviderParams{},
+		CreatedAt:      now.Add(-time.Hour),
+		LastCheckedAt:  now.Add(-5 * time.Minute),
+	}
+	done := &database.Job{ID: "done", Provider: "test-provider", Status: database.StatusDelivered, Done: true}
+	for _, job := range []*database.Job{pending, recent, done} {
+		client.DB.StoreJob(context.Background(), job)
+	}
+
+	reconciler := NewReconciler(client, time.Minute, time.Minute, time.Hour)
+	reconciler.now = func() time.Time { return now }
+	assert.Equal(1, reconciler.RunOnce(context.Background()))
+
+	job, _ := client.DB.GetJob(context.Background(), "pending")
+	assert.Equal(database.StatusDelivered, job.Status)
+	assert.True(job.Done)
+	assert.Equal("somepath/test-provider/video.vtt", job.Outputs[0].URL)
+	assert.Equal(now, job.LastCheckedAt)
+	assert.Len(notified, 1)
+	assert.Equal("pending", notified[0].ID)
+
+	job, _ = client.DB.GetJob(context.Background(), "recent")
+	assert.Equal(database.StatusProcessing, job.Status)
+
+	reconciler.now = func() time.Time { return now.Add(6 * time.Minute) }
+	assert.Equal(1, reconciler.RunOnce(context.Background()))
+	job, _ = client.DB.GetJob(context.Background(), "recent")
+	assert.Equal(database.StatusDelivered, job.Status)
+	assert.Len(notified, 2)
+}
+
+func TestReconcilerProviderError(t *testing.T) {
+	assert := assert.New(t)
+	service, client := createCaptionsService("")
+	service.AddProvider(fakeProvider{logger: log.New(), params: map[string]bool{"jobError": true}})
+	client.DB.StoreJob(context.Background(), &database.Job{
+		ID:             "123",
+		Provider:       "test-provider",
+		Status:         database.StatusProcessing,
+		ProviderParams: database.ProviderParams{},
+		CreatedAt:      time.Now(),
+	})
+
+	reconciler := NewReconciler(client, time.Minute, time.Minute, time.Hour)
+	assert.Equal(1, reconciler.RunOnce(context.Background()))
+	job, _ := client.DB.GetJob(context.Background(), "123")
+	assert.Equal(database.StatusProcessing, job.Status)
+	assert.False(job.LastCheckedAt.IsZero())
+	assert.Equal(0, reconciler.RunOnce(context.Background()))
+}
+
+func TestReconcilerBackoff(t *testing.T) {
+	now := time.Now()
+	reconciler := NewReconciler(Client{}, time.Minute, time.Minute, time.Hour)
+	reconciler.now = func() time.Time { return now }
+
+	tests := []struct {
+		age     time.Duration
+		backoff time.Duration
+	}{
+		{time.Minute, time.Minute},
+		{time.Hour, 6 * time.Minute},
+		{48 * time.Hour, time.Hour},
+	}
+	for _, test := range tests {
+		job := &database.Job{CreatedAt: now.Add(-test.age)}
+		assert.Equal(t, test.backoff, reconciler.backoff(job), test.age.String())
+	}
+}
+
+// pollHookProvider calls onPoll every time a job is fetched
+type pollHookProvider struct {
+	fakeProvider
+	onPoll func()
+}
+
+func (p pollHookProvider) GetProviderJob(_ context.Context, _ *database.Job) (*database.ProviderJob, error) {
+	p.onPoll()
+	return &database.ProviderJob{Status: database.StatusInReview}, nil
+}
+
+func TestReconcilerKeepsConcurrentChanges(t *testing.T) {
+	assert := assert.New(t)
+	service, client := createCaptionsService("")
+	ids := []string{"first", "second"}
+	polled := 0
+	service.AddProvider(pollHookProvider{fakeProvider: fakeProvider{logger: log.New()}, onPoll: func() {
+		polled++
+		// both jobs are cancelled while the first one polled is fetched
+		for _, id := range ids {
+			client.DB.ModifyJob(context.Background(), id, func(job *database.Job) error {
+				job.UpdateStatus(database.StatusCancelled, "")
+				job.Done = true
+				return nil
+			})
+		}
+	}})
+	for _, id := range ids {
+		client.DB.StoreJob(context.Background(), &database.Job{
+			ID:             id,
+			Provider:       "test-provider",
+			Status:         database.StatusProcessing,
+			ProviderParams: database.ProviderParams{"ProviderID": id},
+			CreatedAt:      time.Now(),
+		})
+	}
+
+	reconciler := NewReconciler(client, time.Minute, time.Minute, time.Hour)
+	assert.Equal(1, reconciler.RunOnce(context.Background()))
+	assert.Equal(1, polled)
+	for _, id := range ids {
+		job, _ := client.DB.GetJob(context.Background(), id)
+		assert.Equal(database.StatusCancelled, job.Status, id)
+		assert.True(job.Done, id)
+	}
+}
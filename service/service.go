@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/NYTimes/gziphandler"
@@ -9,6 +10,7 @@ import (
 	"github.com/nytimes/video-captions-api/config"
 	"github.com/nytimes/video-captions-api/database"
 	"github.com/nytimes/video-captions-api/providers"
+	"github.com/nytimes/video-captions-api/routing"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -23,11 +25,30 @@ func NewCaptionsService(cfg *config.CaptionsServiceConfig, db database.DB) *Capt
 	storage, _ := NewGCSStorage(cfg.BucketName, cfg.Logger)
 	return &CaptionsService{
 		Client{
-			Providers:   make(map[string]providers.Provider),
-			DB:          db,
-			Logger:      cfg.Logger,
-			Storage:     storage,
-			CallbackURL: cfg.CallbackURL,
+			Providers:           make(map[string]providers.Provider),
+			DB:                  db,
+			Logger:              cfg.Logger,
+			Storage:             storage,
+			CallbackURL:         cfg.CallbackURL,
+			CallbackAPIKey:      cfg.CallbackAPIKey,
+			CallbackSecrets:     cfg.CallbackSecrets,
+			CallbackTolerance:   cfg.CallbackTolerance,
+			ProviderTimeout:     cfg.ProviderTimeout,
+			ProviderTimeouts:    cfg.ProviderTimeouts,
+			DefaultProviders:    cfg.DefaultProviders,
+			OutputMaxAttempts:   cfg.OutputMaxAttempts,
+			OutputRetryBackoff:  cfg.OutputRetryBackoff,
+			ProviderTurnarounds: cfg.ProviderTurnarounds,
+			WebhookSecret:       cfg.WebhookSecret,
+			WebhookMaxAttempts:  cfg.WebhookMaxAttempts,
+			WebhookRetryBackoff: cfg.WebhookRetryBackoff,
+			WebhookTimeout:      cfg.WebhookTimeout,
+			Guards: NewProviderGuards(
+				cfg.ProviderBreakerThreshold,
+				cfg.ProviderBreakerCooldown,
+				cfg.ProviderRateLimits,
+				cfg.ProviderRateBurst,
+			),
 		},
 		cfg.Logger,
 	}
@@ -38,6 +59,57 @@ func (s *CaptionsService) AddProvider(provider providers.Provider) {
 	s.client.Providers[provider.GetName()] = provider
 }
 
+// SetRouter sets the routing rules used for jobs that don't name a provider
+func (s *CaptionsService) SetRouter(router *routing.Router) {
+	s.client.Router = router
+}
+
+// StartWebhookSender attempts webhook deliveries in the background with
+// cfg.WebhookWorkers workers until ctx is done. It must be called before the
+// dispatch queue and the reconciler are started and the service is registered.
+func (s *CaptionsService) StartWebhookSender(ctx context.Context, cfg *config.CaptionsServiceConfig) {
+	if cfg.WebhookWorkers <= 0 {
+		s.logger.Info("Webhook sender is disabled, deliveries are attempted by the maintenance loop")
+		return
+	}
+	s.client.Webhooks = NewWebhookSender(s.client, cfg.WebhookWorkers)
+	go s.client.Webhooks.Run(ctx)
+}
+
+// StartDispatchQueue makes CreateJob queue jobs, which are dispatched in the
+// background by cfg.DispatchWorkers workers until ctx is done. It must be
+// called before the service is registered.
+func (s *CaptionsService) StartDispatchQueue(ctx context.Context, cfg *config.CaptionsServiceConfig) {
+	if cfg.DispatchWorkers <= 0 {
+		s.logger.Info("Dispatch queue is disabled, jobs are dispatched synchronously")
+		return
+	}
+	s.client.Queue = NewDispatchQueue(s.client, cfg.DispatchWorkers, cfg.ProviderConcurrency)
+	go s.client.Queue.Run(ctx)
+}
+
+// StartReconciler refreshes pending jobs in the background every
+// cfg.ReconcileInterval until ctx is done, providers must be added first
+func (s *CaptionsService) StartReconciler(ctx context.Context, cfg *config.CaptionsServiceConfig) {
+	if cfg.ReconcileInterval <= 0 {
+		s.logger.Info("Reconciler is disabled")
+		return
+	}
+	reconciler := NewReconciler(s.client, cfg.ReconcileInterval, cfg.ReconcileMinBackoff, cfg.ReconcileMaxBackoff)
+	go reconciler.Run(ctx)
+}
+
+// StartMaintenance retries the webhook deliveries due for a retry, flags the
+// jobs past their deadline and fails the interrupted dispatches every
+// cfg.MaintenanceInterval until ctx is done, whether the reconciler runs or not
+func (s *CaptionsService) StartMaintenance(ctx context.Context, cfg *config.CaptionsServiceConfig) {
+	if cfg.MaintenanceInterval <= 0 {
+		s.logger.Info("Maintenance is disabled, webhook deliveries aren't retried")
+		return
+	}
+	go s.client.runMaintenance(ctx, cfg.MaintenanceInterval)
+}
+
 // Prefix CaptionsService API prefix
 func (s *CaptionsService) Prefix() string {
 	return ""
@@ -54,23 +126,69 @@ func (s *CaptionsService) Endpoints() map[string]map[string]http.HandlerFunc {
 		"/captions/{id}": {
 			"GET": server.JSONToHTTP(s.GetJobs).ServeHTTP,
 		},
+		"/jobs/overdue": {
+			"GET": server.JSONToHTTP(s.GetOverdueJobs).ServeHTTP,
+		},
+		"/jobs/import": {
+			"POST": server.JSONToHTTP(s.ImportJob).ServeHTTP,
+		},
 		"/jobs/{id}": {
 			"GET": server.JSONToHTTP(s.GetJob).ServeHTTP,
 		},
 		"/captions": {
 			"POST": server.JSONToHTTP(s.CreateJob).ServeHTTP,
 		},
+		"/captions/estimate": {
+			"POST": server.JSONToHTTP(s.EstimateJob).ServeHTTP,
+		},
+		"/jobs/{id}/caption": {
+			"PUT": server.JSONToHTTP(s.UpdateCaption).ServeHTTP,
+		},
+		"/jobs/{id}/caption/{version}": {
+			"GET": s.GetCaptionVersion,
+		},
 		"/jobs/{id}/cancel": {
 			"POST": server.JSONToHTTP(s.CancelJob).ServeHTTP,
 		},
+		"/jobs/{id}/resubmit": {
+			"POST": server.JSONToHTTP(s.ResubmitJob).ServeHTTP,
+		},
+		"/jobs/{id}/events": {
+			"GET": server.JSONToHTTP(s.GetJobEvents).ServeHTTP,
+		},
+		"/jobs/{id}/deliveries": {
+			"GET": server.JSONToHTTP(s.GetDeliveries).ServeHTTP,
+		},
+		"/webhooks": {
+			"GET":  server.JSONToHTTP(s.GetSubscriptions).ServeHTTP,
+			"POST": server.JSONToHTTP(s.CreateSubscription).ServeHTTP,
+		},
+		"/webhooks/{id}": {
+			"DELETE": server.JSONToHTTP(s.DeleteSubscription).ServeHTTP,
+		},
 		"/jobs/{id}/download/{captionFormat}": {
 			"GET": s.DownloadCaption,
 		},
 		"/jobs/{id}/transcript/{captionFormat}": {
 			"GET": s.GetTranscript,
 		},
+		"/status/providers": {
+			"GET": server.JSONToHTTP(s.GetProviderStatus).ServeHTTP,
+		},
+		"/routing/dry-run": {
+			"POST": server.JSONToHTTP(s.RoutingDryRun).ServeHTTP,
+		},
+		"/providers": {
+			"GET": server.JSONToHTTP(s.GetProviders).ServeHTTP,
+		},
+		"/providers/{name}": {
+			"GET": server.JSONToHTTP(s.GetProvider).ServeHTTP,
+		},
 		"/callback": {
 			"POST": server.JSONToHTTP(s.ProcessCallback).ServeHTTP,
 		},
+		"/callback/{provider}": {
+			"POST": server.JSONToHTTP(s.ProcessCallback).ServeHTTP,
+		},
 	}
 }
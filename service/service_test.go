@@ -1,8 +1,13 @@
 package service
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
 	"testing"
 
 	"reflect"
@@ -20,12 +25,12 @@ type fakeProvider struct {
 	params map[string]bool
 }
 
-func (p fakeProvider) DispatchJob(job *database.Job) error {
+func (p fakeProvider) DispatchJob(_ context.Context, job *database.Job) error {
 	p.logger.Info("dispatching job")
 	return nil
 }
 
-func (p fakeProvider) Download(_ *database.Job, captionType string) ([]byte, error) {
+func (p fakeProvider) Download(_ context.Context, _ *database.Job, captionType string) ([]byte, error) {
 	switch captionType {
 	case "vtt":
 		return []byte("WEBVTT\n\nNOTE Paragraph\n\n00:00:09.240 --> 00:00:11.010\nWe're all talking\nabout the Iowa caucuses"), nil
@@ -40,12 +45,16 @@ func (p fakeProvider) Download(_ *database.Job, captionType string) ([]byte, err
 	}
 }
 
-func (p fakeProvider) GetProviderJob(job *database.Job) (*database.ProviderJob, error) {
+func (p fakeProvider) GetProviderJob(_ context.Context, job *database.Job) (*database.ProviderJob, error) {
 	if p.params["jobError"] {
 		return nil, errors.New("oh no")
 	}
 	if p.params["jobStatus"] {
-		return &database.ProviderJob{Status: "My status"}, nil
+		// a vendor status the provider doesn't translate
+		return &database.ProviderJob{RawStatus: "My status"}, nil
+	}
+	if p.params["jobCancelled"] {
+		return &database.ProviderJob{Status: database.StatusCancelled, RawStatus: "cancelled"}, nil
 	}
 	if p.params["jobDone"] {
 		job := &database.ProviderJob{
@@ -62,33 +71,99 @@ func (p fakeProvider) GetName() string {
 	return "test-provider"
 }
 
-func (p fakeProvider) CancelJob(job *database.Job) (bool, error) {
+func (p fakeProvider) CancelJob(_ context.Context, job *database.Job) (bool, error) {
+	if p.params["cancelError"] {
+		return true, errors.New("oh no")
+	}
+	if p.params["notCancellable"] {
+		return false, errors.New("job is not cancellable")
+	}
 	return true, nil
 }
 
+func (p fakeProvider) ParseCallback(query url.Values, body []byte) (*providers.Callback, error) {
+	var callback providers.ThreePlayCallback
+	if err := json.Unmarshal(body, &callback); err != nil {
+		return nil, err
+	}
+	if callback.Data.ID == 0 {
+		return nil, providers.ErrInvalidProviderID
+	}
+	return &providers.Callback{
+		JobID:      query.Get("job_id"),
+		ProviderID: strconv.Itoa(callback.Data.ID),
+		Status:     callback.Data.Status,
+	}, nil
+}
+
+func (p fakeProvider) GetCapabilities() providers.Capabilities {
+	return providers.Capabilities{
+		Name:        p.GetName(),
+		OutputTypes: []string{"vtt", "srt", "sbv", "ssa"},
+		Languages:   []string{"en", "es"},
+		Cancellable: true,
+		Importable:  true,
+		Params: []providers.ParamSpec{
+			{Name: "turnaround_level_id"},
+		},
+	}
+}
+
 type brokenProvider fakeProvider
 
 func (p brokenProvider) GetName() string {
 	return "broken-provider"
 }
 
-func (p brokenProvider) Download(_ *database.Job, _ string) ([]byte, error) {
+func (p brokenProvider) Download(_ context.Context, _ *database.Job, _ string) ([]byte, error) {
 	return nil, errors.New("download error")
 }
 
-func (p brokenProvider) DispatchJob(job *database.Job) error {
+func (p brokenProvider) DispatchJob(_ context.Context, job *database.Job) error {
 	return errors.New("provider error")
 }
 
-func (p brokenProvider) GetProviderJob(job *database.Job) (*database.ProviderJob, error) {
+func (p brokenProvider) GetProviderJob(_ context.Context, job *database.Job) (*database.ProviderJob, error) {
 	p.logger.Info("fetching job", job.GetProviderID())
-	return nil, errors.New("failed to get job")
+	return nil, statusError{http.StatusServiceUnavailable, "failed to get job"}
 }
 
-func (p brokenProvider) CancelJob(job *database.Job) (bool, error) {
+func (p brokenProvider) CancelJob(_ context.Context, job *database.Job) (bool, error) {
 	return false, nil
 }
 
+func (p brokenProvider) GetCapabilities() providers.Capabilities {
+	return providers.Capabilities{Name: p.GetName(), AdditionalParams: true}
+}
+
+// threePlayProvider receives the callbacks posted to /callback
+type threePlayProvider struct {
+	fakeProvider
+}
+
+func (p threePlayProvider) GetName() string {
+	return "3play"
+}
+
+// slowProvider blocks on every provider call until the context is done
+type slowProvider struct {
+	fakeProvider
+}
+
+func (p slowProvider) GetName() string {
+	return "slow-provider"
+}
+
+func (p slowProvider) GetProviderJob(ctx context.Context, _ *database.Job) (*database.ProviderJob, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (p slowProvider) DispatchJob(ctx context.Context, _ *database.Job) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
 func createCaptionsService(callbackURL string) (*CaptionsService, Client) {
 	client := Client{
 		Providers:   make(map[string]providers.Provider),
@@ -106,10 +181,18 @@ func createCaptionsService(callbackURL string) (*CaptionsService, Client) {
 
 type ignoreStorage struct{}
 
-func (i ignoreStorage) Store(_ []byte, filename string) (string, error) {
+func (i ignoreStorage) Store(_ context.Context, _ []byte, filename string) (string, error) {
 	return fmt.Sprintf("somepath/%s", filename), nil
 }
 
+// memoryStorage keeps stored files by name
+type memoryStorage map[string][]byte
+
+func (m memoryStorage) Store(_ context.Context, data []byte, filename string) (string, error) {
+	m[filename] = data
+	return fmt.Sprintf("https://storage.googleapis.com/bucket/%s", filename), nil
+}
+
 func TestAddProvider(t *testing.T) {
 	assert := assert.New(t)
 	service, client := createCaptionsService("")
@@ -152,7 +235,18 @@ func TestNewCaptionsService(t *testing.T) {
 	assert.Contains(service.Endpoints(), "/jobs/{id}")
 	assert.Contains(service.Endpoints(), "/captions")
 	assert.Contains(service.Endpoints(), "/jobs/{id}/cancel")
+	assert.Contains(service.Endpoints(), "/jobs/{id}/resubmit")
+	assert.Contains(service.Endpoints(), "/jobs/import")
+	assert.Contains(service.Endpoints(), "/jobs/{id}/deliveries")
+	assert.Contains(service.Endpoints(), "/jobs/{id}/events")
+	assert.Contains(service.Endpoints(), "/jobs/overdue")
+	assert.Contains(service.Endpoints(), "/webhooks")
 	assert.Contains(service.Endpoints(), "/jobs/{id}/download/{captionFormat}")
 	assert.Contains(service.Endpoints(), "/jobs/{id}/transcript/{captionFormat}")
 	assert.Contains(service.Endpoints(), "/callback")
+	assert.Contains(service.Endpoints(), "/callback/{provider}")
+	assert.Contains(service.Endpoints(), "/providers")
+	assert.Contains(service.Endpoints(), "/providers/{name}")
+	assert.Contains(service.Endpoints(), "/routing/dry-run")
+	assert.Contains(service.Endpoints(), "/status/providers")
 }
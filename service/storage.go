@@ -13,7 +13,7 @@ import (
 
 // Storage inferce for captions storage
 type Storage interface {
-	Store(data []byte, filename string) (string, error)
+	Store(ctx context.Context, data []byte, filename string) (string, error)
 }
 
 // GCSStorage implements the Storage interface and stores files on GCS
@@ -35,8 +35,7 @@ func NewGCSStorage(bucketName string, logger *log.Logger) (Storage, error) {
 }
 
 // Store implements Storage interface for GCSStorage
-func (gs *GCSStorage) Store(data []byte, filename string) (string, error) {
-	ctx := context.Background()
+func (gs *GCSStorage) Store(ctx context.Context, data []byte, filename string) (string, error) {
 	year, month, day := time.Now().Date()
 	objectFullName := fmt.Sprintf("%s/%s/%s/%s", strconv.Itoa(year), strconv.Itoa(int(month)), strconv.Itoa(day), filename)
 	obj := gs.bucketHandle.Object(objectFullName)
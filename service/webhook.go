@@ -0,0 +1,371 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nytimes/video-captions-api/database"
+	log "github.com/sirupsen/logrus"
+)
+
+// Webhook events
+const (
+	EventCreated       = "created"
+	EventStatusChanged = "status_changed"
+	EventCompleted     = "completed"
+	EventFailed        = "failed"
+	EventCancelled     = "cancelled"
+	EventOverdue       = "overdue"
+)
+
+const (
+	// eventHeader and deliveryHeader hold the event and the delivery ID of a webhook request
+	eventHeader    = "X-Captions-Event"
+	deliveryHeader = "X-Captions-Delivery"
+)
+
+var webhookEvents = []string{EventCreated, EventStatusChanged, EventCompleted, EventFailed, EventCancelled, EventOverdue}
+
+var (
+	// ErrInvalidWebhookURL indicates that a webhook URL isn't an absolute http(s) URL
+	ErrInvalidWebhookURL = errors.New("webhook url must be an absolute http or https URL")
+
+	// ErrInvalidWebhookEvent indicates that a subscription names an unknown event
+	ErrInvalidWebhookEvent = fmt.Errorf("webhook events must be any of %v", webhookEvents)
+)
+
+// webhookPayload is the body posted to webhooks
+type webhookPayload struct {
+	Event      string        `json:"event"`
+	DeliveryID string        `json:"delivery_id"`
+	CreatedAt  time.Time     `json:"created_at"`
+	Job        *database.Job `json:"job"`
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// validateWebhookURL checks that a webhook URL can be posted to
+func validateWebhookURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidWebhookURL
+	}
+	return nil
+}
+
+// CreateSubscription registers a webhook notified of the given events of
+// every job, or all of them when events is empty
+func (c Client) CreateSubscription(ctx context.Context, webhookURL string, events []string, secret string) (*database.Subscription, error) {
+	if err := validateWebhookURL(webhookURL); err != nil {
+		return nil, err
+	}
+	for _, event := range events {
+		if !containsString(webhookEvents, event) {
+			return nil, ErrInvalidWebhookEvent
+		}
+	}
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return nil, fmt.Errorf("could not create a subscription id: %v", err)
+	}
+	subscription := &database.Subscription{
+		ID:        id.String(),
+		URL:       webhookURL,
+		Events:    events,
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	}
+	if err := c.DB.StoreSubscription(ctx, subscription); err != nil {
+		return nil, err
+	}
+	return subscription, nil
+}
+
+// GetSubscriptions returns the registered webhooks
+func (c Client) GetSubscriptions(ctx context.Context) ([]database.Subscription, error) {
+	subscriptions, err := c.DB.GetSubscriptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if subscriptions == nil {
+		subscriptions = []database.Subscription{}
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt)
+	})
+	return subscriptions, nil
+}
+
+// DeleteSubscription unregisters a webhook, its pending deliveries aren't retried
+func (c Client) DeleteSubscription(ctx context.Context, id string) error {
+	return c.DB.DeleteSubscription(ctx, id)
+}
+
+// GetDeliveries returns the webhook deliveries of a job, oldest first
+func (c Client) GetDeliveries(ctx context.Context, jobID string) ([]database.Delivery, error) {
+	if _, err := c.DB.GetJob(ctx, jobID); err != nil {
+		return nil, err
+	}
+	deliveries, err := c.DB.GetDeliveries(ctx, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if deliveries == nil {
+		deliveries = []database.Delivery{}
+	}
+	sort.Sort(database.ByDeliveryCreatedAt(deliveries))
+	return deliveries, nil
+}
+
+// notifyStatus notifies the webhooks of a job status change
+func (c Client) notifyStatus(ctx context.Context, job *database.Job) {
+	c.notify(ctx, job, EventStatusChanged)
+	switch job.Status {
+	case database.StatusError:
+		c.notify(ctx, job, EventFailed)
+	case database.StatusCancelled:
+		c.notify(ctx, job, EventCancelled)
+	}
+}
+
+// notify delivers an event to the job callback_url and to the subscriptions
+// of the event. Deliveries are stored as pending and handed to the Webhooks
+// sender, the ones it doesn't attempt in time and failed ones are attempted
+// by RetryDeliveries.
+func (c Client) notify(ctx context.Context, job *database.Job, event string) {
+	jobLogger := c.Logger.WithFields(log.Fields{"JobID": job.ID, "Event": event})
+	subscriptions, err := c.DB.GetSubscriptions(ctx)
+	if err != nil {
+		jobLogger.WithError(err).Error("Could not load webhook subscriptions")
+	}
+	secrets := map[string]string{}
+	var deliveries []*database.Delivery
+	if job.CallbackURL != "" {
+		deliveries = append(deliveries, &database.Delivery{URL: job.CallbackURL})
+	}
+	for _, subscription := range subscriptions {
+		if len(subscription.Events) > 0 && !containsString(subscription.Events, event) {
+			continue
+		}
+		secrets[subscription.ID] = subscription.Secret
+		deliveries = append(deliveries, &database.Delivery{URL: subscription.URL, SubscriptionID: subscription.ID})
+	}
+
+	for _, delivery := range deliveries {
+		id, err := uuid.NewRandom()
+		if err != nil {
+			jobLogger.WithError(err).Error("Could not create a delivery id")
+			continue
+		}
+		delivery.ID = id.String()
+		delivery.JobID = job.ID
+		delivery.Event = event
+		delivery.CreatedAt = time.Now()
+		delivery.Pending = true
+		delivery.NextRetryAt = delivery.CreatedAt
+		if c.Webhooks != nil {
+			// RetryDeliveries leaves the delivery to the sender until then
+			delivery.NextRetryAt = delivery.CreatedAt.Add(c.WebhookTimeout + c.WebhookRetryBackoff)
+		}
+		delivery.Payload, err = json.Marshal(webhookPayload{
+			Event:      event,
+			DeliveryID: delivery.ID,
+			CreatedAt:  delivery.CreatedAt,
+			Job:        job,
+		})
+		if err != nil {
+			jobLogger.WithError(err).Error("Could not encode webhook payload")
+			continue
+		}
+		// the delivery is stored before it is attempted so it isn't lost on a crash
+		if err := c.DB.SaveDelivery(ctx, delivery); err != nil {
+			jobLogger.WithError(err).Error("Could not store webhook delivery")
+			continue
+		}
+		if c.Webhooks != nil && !c.Webhooks.send(delivery, secrets[delivery.SubscriptionID]) {
+			jobLogger.WithField("DeliveryID", delivery.ID).Warn("Webhook sender is busy, delivery will be retried")
+		}
+	}
+}
+
+// WebhookSender attempts webhook deliveries in the background, so notifying
+// a job event never waits on its webhooks
+type WebhookSender struct {
+	client Client
+	// Workers is the number of deliveries attempted at the same time
+	Workers int
+
+	deliveries chan queuedDelivery
+}
+
+type queuedDelivery struct {
+	delivery *database.Delivery
+	secret   string
+}
+
+// webhookBacklog is how many deliveries wait for a WebhookSender worker, the
+// ones that don't fit are left to RetryDeliveries
+const webhookBacklog = 1000
+
+// NewWebhookSender creates a WebhookSender attempting the deliveries of client
+func NewWebhookSender(client Client, workers int) *WebhookSender {
+	return &WebhookSender{
+		client:     client,
+		Workers:    workers,
+		deliveries: make(chan queuedDelivery, webhookBacklog),
+	}
+}
+
+// Run attempts the deliveries it is sent with Workers workers until ctx is done
+func (s *WebhookSender) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < s.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case queued := <-s.deliveries:
+					s.client.attemptDelivery(ctx, queued.delivery, queued.secret)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// send hands a stored delivery to the workers, it tells false when the
+// backlog is full
+func (s *WebhookSender) send(delivery *database.Delivery, secret string) bool {
+	select {
+	case s.deliveries <- queuedDelivery{delivery, secret}:
+		return true
+	default:
+		return false
+	}
+}
+
+// RetryDeliveries attempts the pending webhook deliveries due for a retry and
+// returns how many were attempted
+func (c Client) RetryDeliveries(ctx context.Context) int {
+	deliveries, err := c.DB.GetPendingDeliveries(ctx)
+	if err != nil {
+		c.Logger.WithError(err).Error("Could not load pending deliveries")
+		return 0
+	}
+	if len(deliveries) == 0 {
+		return 0
+	}
+	sort.Sort(database.ByDeliveryCreatedAt(deliveries))
+	subscriptions, err := c.DB.GetSubscriptions(ctx)
+	if err != nil {
+		c.Logger.WithError(err).Error("Could not load webhook subscriptions")
+		return 0
+	}
+	secrets := map[string]string{}
+	for _, subscription := range subscriptions {
+		secrets[subscription.ID] = subscription.Secret
+	}
+
+	attempted := 0
+	for i := range deliveries {
+		delivery := &deliveries[i]
+		if time.Now().Before(delivery.NextRetryAt) || ctx.Err() != nil {
+			continue
+		}
+		secret, ok := secrets[delivery.SubscriptionID]
+		if delivery.SubscriptionID != "" && !ok {
+			delivery.Pending = false
+			delivery.Failed = true
+			delivery.LastError = "subscription was deleted"
+			c.saveDelivery(ctx, delivery)
+			continue
+		}
+		c.attemptDelivery(ctx, delivery, secret)
+		attempted++
+	}
+	return attempted
+}
+
+// attemptDelivery posts a delivery payload and records the attempt, the
+// payload is signed with the subscription secret or WebhookSecret
+func (c Client) attemptDelivery(ctx context.Context, delivery *database.Delivery, secret string) {
+	if secret == "" {
+		secret = c.WebhookSecret
+	}
+	delivery.Attempts++
+	statusCode, err := c.postWebhook(ctx, delivery, secret)
+	delivery.LastStatusCode = statusCode
+	deliveryLogger := c.Logger.WithFields(log.Fields{
+		"JobID":      delivery.JobID,
+		"DeliveryID": delivery.ID,
+		"URL":        delivery.URL,
+		"Attempts":   delivery.Attempts,
+	})
+	switch {
+	case err == nil:
+		delivery.Pending = false
+		delivery.LastError = ""
+		delivery.NextRetryAt = time.Time{}
+		delivery.DeliveredAt = time.Now()
+		deliveryLogger.Info("Webhook delivered")
+	case c.WebhookMaxAttempts > 0 && delivery.Attempts >= c.WebhookMaxAttempts:
+		delivery.Pending = false
+		delivery.Failed = true
+		delivery.LastError = err.Error()
+		delivery.NextRetryAt = time.Time{}
+		deliveryLogger.WithError(err).Error("Giving up on webhook delivery")
+	default:
+		delivery.LastError = err.Error()
+		delivery.NextRetryAt = time.Now().Add(retryDelay(c.WebhookRetryBackoff, delivery.Attempts))
+		deliveryLogger.WithError(err).Warn("Webhook delivery will be retried")
+	}
+	c.saveDelivery(ctx, delivery)
+}
+
+func (c Client) saveDelivery(ctx context.Context, delivery *database.Delivery) {
+	if err := c.DB.SaveDelivery(ctx, delivery); err != nil {
+		c.Logger.WithError(err).WithField("DeliveryID", delivery.ID).Error("Could not store webhook delivery")
+	}
+}
+
+// postWebhook posts a delivery payload, any non 2xx response is an error
+func (c Client) postWebhook(ctx context.Context, delivery *database.Delivery, secret string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(eventHeader, delivery.Event)
+	req.Header.Set(deliveryHeader, delivery.ID)
+	if secret != "" {
+		req.Header.Set(signatureHeader, signPayload(secret, time.Now(), delivery.Payload))
+	}
+	resp, err := (&http.Client{Timeout: c.WebhookTimeout}).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if !(resp.StatusCode >= 200 && resp.StatusCode < 300) {
+		return resp.StatusCode, fmt.Errorf("%v", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
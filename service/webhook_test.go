@@ -0,0 +1,260 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NYTimes/gizmo/server"
+	"github.com/nytimes/video-captions-api/config"
+	"github.com/nytimes/video-captions-api/database"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type webhookRequest struct {
+	header  http.Header
+	body    []byte
+	payload webhookPayload
+}
+
+// fakeWebhook records the requests it receives and answers with statuses, then 200
+type fakeWebhook struct {
+	mtx      sync.Mutex
+	statuses []int
+	requests []webhookRequest
+}
+
+func (f *fakeWebhook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	request := webhookRequest{header: r.Header, body: body}
+	json.Unmarshal(body, &request.payload)
+	f.requests = append(f.requests, request)
+	if len(f.statuses) > 0 {
+		w.WriteHeader(f.statuses[0])
+		f.statuses = f.statuses[1:]
+	}
+}
+
+func (f *fakeWebhook) events() []string {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	var events []string
+	for _, request := range f.requests {
+		events = append(events, request.payload.Event)
+	}
+	return events
+}
+
+func TestWebhookNotifications(t *testing.T) {
+	assert := assert.New(t)
+	jobHook, subscriptionHook := &fakeWebhook{}, &fakeWebhook{}
+	jobServer, subscriptionServer := httptest.NewServer(jobHook), httptest.NewServer(subscriptionHook)
+	defer jobServer.Close()
+	defer subscriptionServer.Close()
+
+	server := server.NewSimpleServer(&server.Config{})
+	service, client := createCaptionsService("")
+	service.client.WebhookSecret = "service-secret"
+	service.AddProvider(fakeProvider{logger: log.New()})
+	server.Register(service)
+
+	_, err := client.CreateSubscription(context.Background(), subscriptionServer.URL, []string{EventCreated, EventCancelled}, "subscription-secret")
+	assert.Nil(err)
+
+	body := `{"media_url": "http://vp.nyt.com/video.mp4", "provider": "test-provider", "callback_url": "` + jobServer.URL + `"}`
+	r, _ := http.NewRequest("POST", "/captions", bytes.NewReader([]byte(body)))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(201, w.Code)
+	var job database.Job
+	json.NewDecoder(w.Body).Decode(&job)
+	assert.Equal(jobServer.URL, job.CallbackURL)
+
+	canceled, err := client.CancelJob(context.Background(), job.ID)
+	assert.True(canceled)
+	assert.Nil(err)
+
+	assert.Len(jobHook.requests, 0)
+	assert.Equal(5, service.client.RetryDeliveries(context.Background()))
+	assert.Equal([]string{EventCreated, EventStatusChanged, EventCancelled}, jobHook.events())
+	assert.Equal([]string{EventCreated, EventCancelled}, subscriptionHook.events())
+
+	created := jobHook.requests[0]
+	assert.Equal(job.ID, created.payload.Job.ID)
+	assert.Equal(EventCreated, created.header.Get(eventHeader))
+	assert.Equal(created.payload.DeliveryID, created.header.Get(deliveryHeader))
+	assert.Nil(verifySignature("service-secret", created.header.Get(signatureHeader), created.body, time.Now(), time.Minute))
+	assert.Equal(database.StatusCancelled, jobHook.requests[2].payload.Job.Status)
+	signature := subscriptionHook.requests[0].header.Get(signatureHeader)
+	assert.Nil(verifySignature("subscription-secret", signature, subscriptionHook.requests[0].body, time.Now(), time.Minute))
+
+	r, _ = http.NewRequest("GET", "/jobs/"+job.ID+"/deliveries", nil)
+	w = httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(200, w.Code)
+	var deliveries []database.Delivery
+	json.NewDecoder(w.Body).Decode(&deliveries)
+	assert.Len(deliveries, 5)
+	for _, delivery := range deliveries {
+		assert.False(delivery.Pending)
+		assert.Equal(1, delivery.Attempts)
+		assert.Equal(200, delivery.LastStatusCode)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	assert := assert.New(t)
+	hook := &fakeWebhook{statuses: []int{500, 502, 500, 500}}
+	hookServer := httptest.NewServer(hook)
+	defer hookServer.Close()
+	_, client := createCaptionsService("")
+	client.WebhookMaxAttempts = 3
+	job := &database.Job{ID: "123", CallbackURL: hookServer.URL, Status: database.StatusProcessing}
+	client.DB.StoreJob(context.Background(), job)
+
+	client.notify(context.Background(), job, EventCreated)
+	deliveries, _ := client.GetDeliveries(context.Background(), "123")
+	assert.Len(deliveries, 1)
+	assert.True(deliveries[0].Pending)
+	assert.Equal(0, deliveries[0].Attempts)
+	assert.Len(hook.requests, 0)
+
+	assert.Equal(1, client.RetryDeliveries(context.Background()))
+	deliveries, _ = client.GetDeliveries(context.Background(), "123")
+	assert.True(deliveries[0].Pending)
+	assert.Equal(500, deliveries[0].LastStatusCode)
+	assert.Equal("500 Internal Server Error", deliveries[0].LastError)
+
+	assert.Equal(1, client.RetryDeliveries(context.Background()))
+	assert.Equal(1, client.RetryDeliveries(context.Background()))
+	deliveries, _ = client.GetDeliveries(context.Background(), "123")
+	assert.False(deliveries[0].Pending)
+	assert.True(deliveries[0].Failed)
+	assert.Equal(3, deliveries[0].Attempts)
+	assert.Equal(0, client.RetryDeliveries(context.Background()))
+
+	client.WebhookRetryBackoff = time.Hour
+	client.notify(context.Background(), job, EventFailed)
+	assert.Equal(1, client.RetryDeliveries(context.Background()))
+	assert.Equal(0, client.RetryDeliveries(context.Background()))
+	assert.Len(hook.requests, 4)
+}
+
+func TestWebhookDeletedSubscription(t *testing.T) {
+	assert := assert.New(t)
+	hook := &fakeWebhook{statuses: []int{500}}
+	hookServer := httptest.NewServer(hook)
+	defer hookServer.Close()
+	_, client := createCaptionsService("")
+	job := &database.Job{ID: "123", Status: database.StatusProcessing}
+	client.DB.StoreJob(context.Background(), job)
+
+	subscription, _ := client.CreateSubscription(context.Background(), hookServer.URL, nil, "")
+	client.notify(context.Background(), job, EventStatusChanged)
+	assert.Equal(1, client.RetryDeliveries(context.Background()))
+	assert.Nil(client.DeleteSubscription(context.Background(), subscription.ID))
+	assert.Equal(0, client.RetryDeliveries(context.Background()))
+
+	deliveries, _ := client.GetDeliveries(context.Background(), "123")
+	assert.True(deliveries[0].Failed)
+	assert.Equal("subscription was deleted", deliveries[0].LastError)
+	assert.Len(hook.requests, 1)
+}
+
+func TestWebhookEndpoints(t *testing.T) {
+	server := server.NewSimpleServer(&server.Config{})
+	service, client := createCaptionsService("")
+	server.Register(service)
+	subscription, _ := client.CreateSubscription(context.Background(), "https://example.com/hook", nil, "")
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		status int
+		err    string
+	}{
+		{"Create", "POST", "/webhooks", `{"url": "https://example.com/other", "events": ["completed"], "secret": "s"}`, 201, ""},
+		{"Invalid URL", "POST", "/webhooks", `{"url": "example.com"}`, 400, "webhook url must be an absolute http or https URL"},
+		{"Invalid event", "POST", "/webhooks", `{"url": "https://example.com", "events": ["deleted"]}`, 400, "webhook events must be any of [created status_changed completed failed cancelled overdue]"},
+		{"List", "GET", "/webhooks", ``, 200, ""},
+		{"Delete", "DELETE", "/webhooks/" + subscription.ID, ``, 200, ""},
+		{"Delete missing", "DELETE", "/webhooks/" + subscription.ID, ``, 404, "subscription not found"},
+		{"Deliveries of missing job", "GET", "/jobs/404/deliveries", ``, 404, "job not found"},
+		{"Invalid callback URL", "POST", "/captions", `{"media_url": "http://vp.nyt.com/video.mp4", "callback_url": "ftp://example.com"}`, 400, "callback_url must be an absolute http or https URL"},
+	}
+	for _, test := range tests {
+		r, _ := http.NewRequest(test.method, test.url, bytes.NewReader([]byte(test.body)))
+		w := httptest.NewRecorder()
+		server.ServeHTTP(w, r)
+		assert.Equal(t, test.status, w.Code, test.name)
+		if test.err != "" {
+			var body map[string]interface{}
+			json.NewDecoder(w.Body).Decode(&body)
+			assert.Equal(t, test.err, body["error"], test.name)
+		}
+	}
+
+	subscriptions, _ := client.GetSubscriptions(context.Background())
+	assert.Len(t, subscriptions, 1)
+	assert.Equal(t, []string{EventCompleted}, subscriptions[0].Events)
+	assert.Equal(t, "s", subscriptions[0].Secret)
+}
+
+func TestWebhookSender(t *testing.T) {
+	assert := assert.New(t)
+	release := make(chan struct{})
+	hook := &fakeWebhook{}
+	hookServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		hook.ServeHTTP(w, r)
+	}))
+	defer hookServer.Close()
+
+	server := server.NewSimpleServer(&server.Config{})
+	service, _ := createCaptionsService("")
+	service.AddProvider(fakeProvider{logger: log.New()})
+	service.client.WebhookRetryBackoff = time.Minute
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	service.StartWebhookSender(ctx, &config.CaptionsServiceConfig{WebhookWorkers: 1})
+	server.Register(service)
+	client := service.client
+	client.CreateSubscription(context.Background(), hookServer.URL, []string{EventCreated}, "")
+
+	// the request doesn't wait on the webhook
+	body := `{"media_url": "http://vp.nyt.com/video.mp4", "provider": "test-provider"}`
+	r, _ := http.NewRequest("POST", "/captions", bytes.NewReader([]byte(body)))
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	assert.Equal(201, w.Code)
+	var job database.Job
+	json.NewDecoder(w.Body).Decode(&job)
+
+	deliveries, _ := client.GetDeliveries(context.Background(), job.ID)
+	assert.Len(deliveries, 1)
+	assert.True(deliveries[0].Pending)
+	assert.Equal(0, client.RetryDeliveries(context.Background()))
+
+	close(release)
+	for i := 0; i < 100 && len(hook.events()) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal([]string{EventCreated}, hook.events())
+	for i := 0; i < 100 && deliveries[0].Pending; i++ {
+		time.Sleep(10 * time.Millisecond)
+		deliveries, _ = client.GetDeliveries(context.Background(), job.ID)
+	}
+	assert.False(deliveries[0].Pending)
+	assert.Equal(1, deliveries[0].Attempts)
+}